@@ -6,13 +6,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"runtime/trace"
+	"sort"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/TheSnook/polyester/crawler"
 	"github.com/TheSnook/polyester/site"
@@ -21,7 +33,10 @@ import (
 
 // Config flags
 var dbPath = flag.String("db", "", "Scheme and path to database of staticated content.")
-var configFile = flag.String("site", "", "A YAML file defining site parameters for smart updates.")
+var keyNamespaces = flag.String("key_namespaces", "", "Prefixes keeping pages (HTML and redirects) and assets apart in --db, as <pages>,<assets>, e.g. html,assets stores /about/ as html/about/. The server must use the same --key_namespaces. Doesn't apply to --db_routes.")
+var dbRoutes = flag.String("db_routes", "", "Comma-separated list of <pattern>=<scheme>:<path> rules storing some content outside --db. Patterns are key prefixes (/wp-content/uploads/), extensions (.jpg) or content types (image/*).")
+var configFile = flag.String("site", "", "A YAML (or .json) file defining site parameters for smart updates.")
+var strictEnv = flag.Bool("strict_env", false, "Fail if the site config references an unset ${VAR} environment variable.")
 
 // Action flags
 var startURL = flag.String("url", "", "Root URL to fetch.")
@@ -29,8 +44,74 @@ var aliasDomains = flag.String("domains", "", "Comma-separated list of domains t
 var newResource = flag.String("new_resource", "", "URL of a newly-created resource (page, post, etc.) to fetch.")
 var updateResource = flag.String("update_resource", "", "URL of an updated resource (page, post, etc.) to fetch.")
 var deleteResource = flag.String("delete_resource", "", "URL of a resource (page, post, etc.) to remove from the database.")
+var single = flag.Bool("single", false, "With --url, fetch and store only that URL, without following any links.")
+var prune = flag.Bool("prune", false, "With --url, after a complete crawl, delete stored resources which were not seen.")
+var pruneDryRun = flag.Bool("prune_dry_run", false, "With --url, list the stored resources --prune would delete, without deleting them.")
 var fetchLimit = flag.Int("limit", 1, "Max URLs to fetch.")
 var maxParallel = flag.Int("parallel", 1, "Max concurrent fetches.")
+var maxRuntime = flag.Duration("max_runtime", 0, "Stop crawling after this long, e.g. 30m. 0 for no limit.")
+var oneHopDomains = flag.String("follow_external_one_hop", "", "Comma-separated list of external domains from which directly linked pages are fetched, without following their links.")
+var keepAbsoluteHosts = flag.String("keep_absolute_hosts", "", "Comma-separated list of hosts whose URLs are left absolute and not crawled, even if they would otherwise be local.")
+var publishDomain = flag.String("publish_domain", "", "Domain the mirror is published under. Replaces the origin domain in URLs which must stay absolute.")
+var basePath = flag.String("base_path", "", "Path the mirror is published under, e.g. /mirror, prefixing rewritten local URLs so /page links to /mirror/page. Content is stored under the same keys as without it.")
+var deferRelativize = flag.Bool("defer_relativize", false, "Store HTML with local URLs left absolute, for the server to relativize when serving (with its --relativize), e.g. to publish under several domains. Not compatible with --publish_domain, which the server then sets.")
+var allowContentTypes = flag.String("allow_content_types", "", "Comma-separated list of content types (e.g. application/json, image/*) of non-HTML content to store. Default all.")
+var denyContentTypes = flag.String("deny_content_types", "", "Comma-separated list of content types of non-HTML content never to store. Overrides --allow_content_types.")
+var extractEmbeddedLinks = flag.Bool("extract_embedded_links", false, "Also crawl local URLs found in JSON <script> data and CSS @import rules.")
+var minify = flag.Bool("minify", false, "Collapse insignificant whitespace in stored HTML.")
+var stripComments = flag.Bool("strip_comments", false, "With --minify, also remove HTML comments.")
+var excludeSelectors = flag.String("exclude_selectors", "", "Comma-separated list of selectors (tag, #id, .class or combinations like div.widget) of elements to remove from stored pages.")
+var forms = flag.String("forms", "defang", `How to rewrite local <form> actions: "defang" (replace with "#") or "relativize".`)
+var noQuerySort = flag.Bool("no_query_sort", false, "Preserve the order of query parameters rather than sorting them, for order-sensitive origins.")
+var capturePreloads = flag.Bool("capture_preloads", false, "Also fetch local assets named in <link rel=preload/prefetch/modulepreload> hints.")
+var stripIntegrity = flag.Bool("strip_integrity", false, "Remove integrity and crossorigin attributes from <link> and <script> tags pointing at the mirror, whose content may differ from the origin's.")
+var captureAMP = flag.Bool("capture_amp", false, "Also crawl the AMP variants of pages, linked by <link rel=amphtml>.")
+var captureIcons = flag.Bool("capture_icons", false, "Also fetch /favicon.ico, /apple-touch-icon.png, icons and manifests named by <link> tags, and the icons listed in web app manifests.")
+var respectRobotsTag = flag.Bool("respect_robots_tag", false, "Honor X-Robots-Tag response headers: don't store noindex pages, or follow links from nofollow pages.")
+var storeOriginal = flag.Bool("store_original", false, "Also store the HTML of each page as fetched, before statication, under the key prefix /__orig, e.g. to debug statication.")
+var storeOriginalMaxSize = flag.Int("store_original_max_size", 1<<20, "With --store_original, don't store the original of pages larger than this many bytes. 0 for no limit.")
+var rewriteCSS = flag.Bool("rewrite_css", false, "Relativize local URLs in stylesheets, <style> elements and style attributes, including image-set() candidates.")
+var elementURLAttrs = flag.String("element_url_attrs", "", "Comma-separated tag:attribute pairs of custom elements' URL attributes to relativize, e.g. my-player:url, besides those of AMP elements like amp-img:src.")
+var maxAssetAge = flag.Duration("max_asset_age", 0, "Don't fetch assets (non-HTML content) again if already stored less than this long ago, e.g. 24h. 0 to always fetch.")
+var maxRPS = flag.Float64("max_rps", 0, "Max requests per second across the whole crawl. 0 for no limit.")
+var crawlDelay = flag.Duration("crawl_delay", 0, "Min time between requests to the same host, e.g. 500ms.")
+var respectCrawlDelay = flag.Bool("respect_crawl_delay", false, "Also honor the Crawl-delay in each host's robots.txt (for polyester, or else for *), where longer than --crawl_delay.")
+var crawlDelayJitter = flag.Float64("crawl_delay_jitter", 0, "With --crawl_delay, randomly vary each delay by up to this fraction, e.g. 0.2 for ±20%.")
+var allowInsecureLocal = flag.Bool("allow_insecure_local", false, "Skip TLS certificate verification for hosts on private or loopback IP addresses, e.g. internal staging servers.")
+var maxConnsPerHost = flag.Int("max_conns_per_host", 0, "Max concurrent requests to any one host, within --parallel. 0 for no limit.")
+var idleConns = flag.Int("idle_conns", crawler.DEFAULT_MAX_IDLE_CONNS, "Max idle HTTP connections to keep open across all hosts.")
+var idleConnsPerHost = flag.Int("idle_conns_per_host", 0, "Max idle HTTP connections to keep open to each host. 0 to match --parallel.")
+var idleConnTimeout = flag.Duration("idle_conn_timeout", crawler.DEFAULT_IDLE_CONN_TIMEOUT, "How long to keep idle HTTP connections open.")
+var maxQueue = flag.Int("max_queue", 0, "Max URLs waiting to be fetched to hold in memory. Any more are spilled to a temporary file. 0 for no limit.")
+var canonicalHostOnly = flag.Bool("canonical_host_only", false, "Fetch local URLs on --domains aliases from the origin host of --url (or --canonical_host) instead, so each path is crawled once.")
+var canonicalHost = flag.String("canonical_host", "", "With --canonical_host_only, the host to fetch local URLs from. Defaults to the origin.")
+var progressInterval = flag.Duration("progress_interval", 0, "Log crawl progress this often, e.g. 30s. 0 for no progress reports.")
+var paginate = flag.Int("paginate", 0, "With --url, fetch and store only that page and up to this many pages in total following its next-page links, e.g. of an archive.")
+var paginationSelectors = flag.String("pagination_selectors", ".nav-next,a.next", "With --paginate, comma-separated list of selectors of elements linking to the next page, used where pages have no rel=next link.")
+var maxRedirects = flag.Int("max_redirects", crawler.MAX_REDIRECTS, "Max length of a redirect chain to follow before giving up.")
+var sitemapURL = flag.String("sitemap", "", "With --url, also crawl the local URLs listed in this XML sitemap (or sitemap index).")
+var sitemapPriority = flag.Bool("sitemap_priority", false, "With --sitemap, fetch URLs in order of their sitemap <priority>, so the most important are fetched within --limit.")
+var keyTransform = flag.String("key_transform", "", "Comma-separated transforms of storage keys, applied in order: html_suffix, no_trailing_slash, prefix=/some/prefix. The server must use the same --key_transform.")
+var bloomSeen = flag.Uint("bloom_seen", 0, "If set, track URLs seen with a bloom filter sized for this many URLs, bounding memory use on huge sites. A few URLs may be wrongly skipped. Not compatible with --state or --prune.")
+var bloomFPRate = flag.Float64("bloom_fp_rate", 0.0001, "With --bloom_seen, the fraction of URLs which may be wrongly skipped.")
+var hostLimit = flag.Int("host_limit", 0, "Max URLs to fetch from any single host. 0 for no per-host limit.")
+var stateFile = flag.String("state", "", "File in which to keep the set of URLs crawled between runs, for incremental crawls.")
+var redirectMap = flag.String("redirect_map", "", "File to write a map of all stored redirects to, e.g. to configure a CDN: after a complete crawl with --url, or on its own.")
+var redirectMapFormat = flag.String("redirect_map_format", crawler.RedirectMapNetlify, `With --redirect_map, "netlify" for a _redirects file, or "json".`)
+var manifestFile = flag.String("manifest", "", "File of content hashes of resources written to --db, kept between runs so that unchanged resources are not written again.")
+var fresh = flag.Bool("fresh", false, "With --state, ignore any saved state and start the crawl from scratch.")
+var importDir = flag.String("import_dir", "", "Directory of pre-crawled content (e.g. a wget mirror) to import into --db.")
+var importOrigin = flag.String("import_origin", "", "With --import_dir, hostname of the original site. If set, links in imported HTML are relativized.")
+var loginURL = flag.String("login_url", "", "URL of a login form to submit before crawling. The password is read from $POLYESTER_LOGIN_PASSWORD.")
+var loginUser = flag.String("login_user", "", "With --login_url, the username to log in as.")
+var loginUserField = flag.String("login_user_field", "log", "With --login_url, the name of the username form field.")
+var loginPasswordField = flag.String("login_password_field", "pwd", "With --login_url, the name of the password form field.")
+var copyTo = flag.String("copy_to", "", "Scheme and path of a database to copy all content from --db into.")
+var keepGoing = flag.Bool("keep_going", false, "With --copy_to, continue past resources which fail to copy.")
+var list = flag.Bool("list", false, "List stored resources with their content type and size.")
+var listPrefix = flag.String("list_prefix", "", "With --list, only list keys starting with this prefix.")
+var printURL = flag.String("print", "", "URL of a page to fetch and staticate, writing the result to stdout and the links found to stderr, without storing anything. For checking rewriting options.")
+var get = flag.String("get", "", "Key of a stored resource whose content to write to stdout.")
 
 // Development and debug flags
 var traceFile = flag.String("trace", "", "Write a Go execution trace file.")
@@ -56,12 +137,6 @@ func main() {
 		}
 	}
 
-	if *dbPath == "" {
-		log.Fatal("Flag --db is required")
-	}
-	db := storage.New(*dbPath)
-	defer db.Close()
-
 	aliasDomainStrings := strings.Split(*aliasDomains, ",")
 	aliases := make([]string, len(aliasDomainStrings))
 	for i, a := range aliasDomainStrings {
@@ -72,34 +147,569 @@ func main() {
 		aliases[i] = u.Host
 	}
 
+	if *printURL != "" {
+		// Before opening --db, which isn't needed.
+		mustPrint(*printURL, aliases, siteConfig)
+		return
+	}
+	if *dbPath == "" {
+		log.Fatal("Flag --db is required")
+	}
+	if *redirectMap != "" && *redirectMapFormat != crawler.RedirectMapNetlify && *redirectMapFormat != crawler.RedirectMapJSON {
+		// Before crawling, rather than after.
+		log.Fatalf("Unknown --redirect_map_format %q\n", *redirectMapFormat)
+	}
+	db := storage.New(*dbPath)
+	if *keyNamespaces != "" {
+		ns, err := storage.ParseNamespaces(db, *keyNamespaces)
+		if err != nil {
+			log.Fatalf("Bad --key_namespaces: %v\n", err)
+		}
+		db = ns
+	}
+	if *dbRoutes != "" {
+		db = storage.ParseRoutes(db, *dbRoutes)
+	}
+	defer db.Close()
+
+	if *importDir != "" {
+		c := newCrawler(*importOrigin, aliases, db, siteConfig)
+		n, err := c.ImportDir(*importDir, *importOrigin != "")
+		if err != nil {
+			log.Fatalf("Import from %q failed after %d files: %v\n", *importDir, n, err)
+		}
+		log.Printf("Imported %d files from %q\n", n, *importDir)
+		return
+	}
+	if *copyTo != "" {
+		dst := storage.New(*copyTo)
+		defer dst.Close()
+		err := storage.Copy(db, dst, *keepGoing, func(done, total int, k string, err error) {
+			if err != nil {
+				log.Printf("[%d/%d] Error copying %q: %v\n", done, total, k, err)
+				return
+			}
+			log.Printf("[%d/%d] Copied %q\n", done, total, k)
+		})
+		if err != nil {
+			log.Fatalf("Copy from %q to %q failed: %v\n", *dbPath, *copyTo, err)
+		}
+		return
+	}
+	if *list {
+		if err := listResources(os.Stdout, db, *listPrefix); err != nil {
+			log.Fatalf("Listing %q failed: %v\n", *dbPath, err)
+		}
+		return
+	}
+	if *get != "" {
+		r, err := db.Read(*get)
+		if err != nil {
+			log.Fatalf("Could not read %q: %v\n", *get, err)
+		}
+		if r.GetRedirect() != "" {
+			log.Printf("%q redirects to %q\n", *get, r.GetRedirect())
+		}
+		os.Stdout.Write(r.GetContent())
+		return
+	}
+	if *redirectMap != "" && *startURL == "" {
+		mustWriteRedirectMap(db, *redirectMap)
+		return
+	}
 	if *startURL != "" {
 		u, err := url.Parse(*startURL)
 		if err != nil {
 			log.Fatalf("Could not parse start url %q: %v\n", *startURL, err)
 		}
-		c := crawler.New(u.Hostname(), aliases, db)
-		c.CrawlP(*u, *fetchLimit, *maxParallel)
+		// Stop cleanly on Ctrl-C or SIGTERM, so that what was crawled is saved.
+		// A second signal kills the process as usual.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+		if *maxRuntime > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *maxRuntime)
+			defer cancel()
+		}
+		c := newCrawler(u.Hostname(), aliases, db, siteConfig)
+		if *stateFile != "" && !*fresh {
+			mustLoadState(c, *stateFile)
+		}
+		if *loginURL != "" {
+			mustLogin(ctx, c)
+		}
+		// The manifest must be saved after any writes, even if the crawl
+		// failed, so that it still matches the database.
+		saveManifest := func() {}
+		if *manifestFile != "" {
+			c.Manifest = mustLoadManifest(*manifestFile)
+			saveManifest = func() { mustSaveManifest(c.Manifest, *manifestFile) }
+		}
+		if *single {
+			err := c.CrawlOne(ctx, *u)
+			saveManifest()
+			if err != nil {
+				log.Fatalf("Could not fetch %q: %v\n", u, err)
+			}
+			return
+		}
+		if *paginate > 0 {
+			n, err := c.CrawlPages(ctx, *u, *paginate)
+			saveManifest()
+			if err != nil {
+				log.Fatalf("Could not fetch page %d from %q: %v\n", n+1, u, err)
+			}
+			log.Printf("Fetched %d pages.\n", n)
+			return
+		}
+		if *sitemapURL != "" {
+			c.Seeds = mustFetchSitemap(ctx, c)
+		}
+		if *progressInterval > 0 {
+			stop := reportProgress(c, *progressInterval)
+			defer stop()
+		}
+		stats := c.CrawlP(ctx, *u, *fetchLimit, *maxParallel)
+		var apiErr error
+		if siteConfig != nil && len(siteConfig.APIs) > 0 && !stats.Stopped {
+			var n int
+			n, apiErr = c.CrawlAPIs(ctx, *u, siteConfig.APIs)
+			log.Printf("Fetched %d JSON API pages.\n", n)
+		}
+		saveManifest()
+		if *stateFile != "" {
+			mustSaveState(c, *stateFile)
+		}
+		if stats.Stopped {
+			db.Close()
+			reason := "by a signal"
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				reason = fmt.Sprintf("after exceeding --max_runtime=%v", *maxRuntime)
+			}
+			log.Fatalf("Crawl stopped %s. Fetched %d URLs.\n", reason, stats.Fetched)
+		}
+		if apiErr != nil {
+			// Before pruning, which would delete the APIs' stored pages.
+			log.Fatalf("Could not fetch JSON APIs: %v\n", apiErr)
+		}
+		if *prune || *pruneDryRun {
+			mustPrune(c, stats)
+			saveManifest()
+		}
+		if *redirectMap != "" {
+			mustWriteRedirectMap(db, *redirectMap)
+		}
 
 		return
 	}
 	if *newResource != "" {
-		u, err := url.Parse(*startURL)
+		u, err := url.Parse(*newResource)
 		if err != nil {
-			log.Fatalf("Could not parse resource url %q: %v\n", *startURL, err)
+			log.Fatalf("Could not parse resource url %q: %v\n", *newResource, err)
+		}
+		if siteConfig == nil {
+			log.Fatal("Flag --site is required with --new_resource")
 		}
-		c := crawler.New(u.Hostname(), aliases, db)
-		if err := c.CrawlNewResource(u, siteConfig, *fetchLimit); err != nil {
+		c := newCrawler(u.Hostname(), aliases, db, siteConfig)
+		if err := c.CrawlNewResource(context.Background(), u, siteConfig, *fetchLimit); err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 	if *updateResource != "" {
-		log.Fatalln("Updating resources is not yet implemented.")
+		u, err := url.Parse(*updateResource)
+		if err != nil {
+			log.Fatalf("Could not parse resource url %q: %v\n", *updateResource, err)
+		}
+		if siteConfig == nil {
+			log.Fatal("Flag --site is required with --update_resource")
+		}
+		c := newCrawler(u.Hostname(), aliases, db, siteConfig)
+		if err := c.CrawlUpdateResource(context.Background(), u, siteConfig, *fetchLimit); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 	if *deleteResource != "" {
 		log.Fatalln("Deleting resources is not yet implemented.")
 	}
-	log.Fatalln("Nothing to do. Please specify --url or one of the --<new|update|delete>_resouce parameters.")
+	log.Fatalln("Nothing to do. Please specify --url, --copy_to, --import_dir or one of the --<new|update|delete>_resouce parameters.")
+}
+
+// newCrawler creates a crawler configured from flags and the (optional) site config.
+func newCrawler(origin string, aliases []string, db storage.Storage, siteConfig *site.Config) *crawler.Crawler {
+	mustPing(db)
+	c := crawler.New(origin, aliases, db)
+	c.MaxPagesPerHost = *hostLimit
+	c.PublishDomain = *publishDomain
+	if p := strings.Trim(*basePath, "/"); p != "" {
+		c.BasePath = "/" + p
+	}
+	if *deferRelativize && *basePath != "" {
+		log.Fatal("Flag --base_path can't be used with --defer_relativize.")
+	}
+	if *deferRelativize && *publishDomain != "" {
+		log.Fatal("Flag --publish_domain can't be used with --defer_relativize. Set the server's --publish_domains instead.")
+	}
+	c.DeferRelativize = *deferRelativize
+	c.ExtractEmbeddedLinks = *extractEmbeddedLinks
+	c.Minify = *minify
+	c.StripComments = *stripComments
+	c.NoQuerySort = *noQuerySort
+	c.CapturePreloads = *capturePreloads
+	c.CaptureAMP = *captureAMP
+	c.CaptureIcons = *captureIcons
+	c.MaxRedirects = *maxRedirects
+	c.RewriteCSS = *rewriteCSS
+	c.MaxAssetAge = *maxAssetAge
+	attrs, err := crawler.ParseElementURLAttrs(*elementURLAttrs)
+	if err != nil {
+		log.Fatalf("Bad --element_url_attrs: %v\n", err)
+	}
+	c.ElementURLAttrs = attrs
+	if *bloomSeen > 0 {
+		if *stateFile != "" || *prune || *pruneDryRun {
+			log.Fatal("Flag --bloom_seen can't be used with --state or --prune, which need the exact set of URLs seen.")
+		}
+		if *bloomFPRate <= 0 || *bloomFPRate >= 1 {
+			log.Fatalf("Flag --bloom_fp_rate must be between 0 and 1, got %v\n", *bloomFPRate)
+		}
+		c.UseBloomFilter(*bloomSeen, *bloomFPRate)
+	}
+	c.TuneTransport(crawler.TransportTuning{
+		MaxIdleConns:        *idleConns,
+		MaxIdleConnsPerHost: *idleConnsPerHost,
+		MaxConnsPerHost:     *maxConnsPerHost,
+		IdleConnTimeout:     *idleConnTimeout,
+	})
+	kt, err := crawler.ParseKeyTransform(*keyTransform)
+	if err != nil {
+		log.Fatalf("Bad --key_transform: %v\n", err)
+	}
+	c.KeyTransform = kt
+	c.StoreOriginal = *storeOriginal
+	c.MaxOriginalSize = *storeOriginalMaxSize
+	c.RespectRobotsTag = *respectRobotsTag
+	c.StripIntegrity = *stripIntegrity
+	c.MaxRPS = *maxRPS
+	c.MaxQueue = *maxQueue
+	c.MaxConnsPerHost = *maxConnsPerHost
+	c.AllowInsecureLocal = *allowInsecureLocal
+	if *canonicalHostOnly {
+		c.CanonicalHost = origin
+		if *canonicalHost != "" {
+			c.CanonicalHost = *canonicalHost
+		}
+	}
+	c.CrawlDelay = *crawlDelay
+	c.RespectCrawlDelay = *respectCrawlDelay
+	if *crawlDelayJitter < 0 || *crawlDelayJitter > 1 {
+		log.Fatalf("Flag --crawl_delay_jitter must be between 0 and 1, got %v\n", *crawlDelayJitter)
+	}
+	c.CrawlDelayJitter = *crawlDelayJitter
+	switch *forms {
+	case "defang":
+		c.FormActions = crawler.DefangForms
+	case "relativize":
+		c.FormActions = crawler.RelativizeForms
+	default:
+		log.Fatalf("Unknown --forms mode %q\n", *forms)
+	}
+	if *paginationSelectors != "" {
+		for _, s := range strings.Split(*paginationSelectors, ",") {
+			sel, err := crawler.ParseSelector(s)
+			if err != nil {
+				log.Fatalf("Bad --pagination_selectors: %v\n", err)
+			}
+			c.PaginationSelectors = append(c.PaginationSelectors, sel)
+		}
+	}
+	if *excludeSelectors != "" {
+		for _, s := range strings.Split(*excludeSelectors, ",") {
+			sel, err := crawler.ParseSelector(s)
+			if err != nil {
+				log.Fatalf("Bad --exclude_selectors: %v\n", err)
+			}
+			c.ExcludeSelectors = append(c.ExcludeSelectors, sel)
+		}
+	}
+	if *allowContentTypes != "" {
+		c.AllowContentTypes = strings.Split(*allowContentTypes, ",")
+	}
+	if *denyContentTypes != "" {
+		c.DenyContentTypes = strings.Split(*denyContentTypes, ",")
+	}
+	if *keepAbsoluteHosts != "" {
+		c.KeepAbsoluteHosts = strings.Split(strings.ToLower(*keepAbsoluteHosts), ",")
+	}
+	if *oneHopDomains != "" {
+		c.OneHopHosts = strings.Split(strings.ToLower(*oneHopDomains), ",")
+	}
+	if siteConfig == nil {
+		return c
+	}
+	metadata, err := crawler.MetadataRules(siteConfig.Metadata)
+	if err != nil {
+		log.Fatalf("Bad metadata in site config: %v\n", err)
+	}
+	c.Metadata = metadata
+	if s := siteConfig.Soft404; len(s.Title) > 0 || len(s.Body) > 0 {
+		c.Soft404 = &crawler.Soft404Detector{
+			Title: mustCompileAll(s.Title),
+			Body:  mustCompileAll(s.Body),
+		}
+	}
+	if v := siteConfig.Validation; v.MinSize > 0 || len(v.Require) > 0 || len(v.ErrorMarkers) > 0 {
+		c.Validator = &crawler.PageValidator{
+			MinSize:      v.MinSize,
+			ErrorMarkers: mustCompileAll(v.ErrorMarkers),
+		}
+		for _, s := range v.Require {
+			sel, err := crawler.ParseSelector(s)
+			if err != nil {
+				log.Fatalf("Bad validation selector in site config: %v\n", err)
+			}
+			c.Validator.Require = append(c.Validator.Require, sel)
+		}
+	}
+	return c
+}
+
+func mustCompileAll(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			log.Fatalf("Bad regular expression %q in site config: %v\n", p, err)
+		}
+		res[i] = re
+	}
+	return res
+}
+
+// listResources writes a table of stored resources with keys starting with
+// prefix to w.
+func listResources(w io.Writer, db storage.Storage, prefix string) error {
+	keys, err := db.Keys()
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTYPE\tSIZE\tREDIRECT")
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		r, err := db.Read(k)
+		if err != nil {
+			return fmt.Errorf("reading %q: %w", k, err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", k, r.GetContentType(), len(r.GetContent()), r.GetRedirect())
+	}
+	return tw.Flush()
+}
+
+// mustPrune deletes (or with --prune_dry_run, lists) stored resources not
+// seen in a crawl, refusing if the crawl didn't cover the whole site.
+func mustPrune(c *crawler.Crawler, stats *crawler.CrawlStats) {
+	if stats.Errors > 0 || len(stats.Unvisited) > 0 {
+		log.Fatalf("Not pruning after an incomplete crawl: %d errors, %d URLs unvisited (raise --limit?).\n", stats.Errors, len(stats.Unvisited))
+	}
+	stale, err := c.Prune(*pruneDryRun)
+	if err != nil {
+		log.Fatalf("Pruning failed: %v\n", err)
+	}
+	if *pruneDryRun {
+		for _, k := range stale {
+			fmt.Println(k)
+		}
+		log.Printf("Would prune %d stale resources.\n", len(stale))
+		return
+	}
+	log.Printf("Pruned %d stale resources.\n", len(stale))
+}
+
+// mustWriteRedirectMap writes the redirects stored in db to a file in
+// --redirect_map_format, replacing it atomically.
+func mustWriteRedirectMap(db storage.Storage, path string) {
+	rs, err := crawler.StoredRedirects(db)
+	if err != nil {
+		log.Fatalf("Could not list redirects in %q: %v\n", *dbPath, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		log.Fatalf("Could not create redirect map for %q: %v\n", path, err)
+	}
+	if err := crawler.WriteRedirectMap(f, rs, *redirectMapFormat); err != nil {
+		os.Remove(f.Name())
+		log.Fatalf("Could not write redirect map to %q: %v\n", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Could not write redirect map to %q: %v\n", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		log.Fatalf("Could not write redirect map to %q: %v\n", path, err)
+	}
+	log.Printf("Wrote %d redirects to %q.\n", len(rs), path)
+}
+
+// mustPrint fetches and staticates a URL with the crawler options from flags
+// and the site config, and prints the result, without any storage.
+func mustPrint(rawURL string, aliases []string, siteConfig *site.Config) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		log.Fatalf("Could not parse url %q: %v\n", rawURL, err)
+	}
+	c := newCrawler(u.Hostname(), aliases, nil, siteConfig)
+	if err := printPage(os.Stdout, os.Stderr, c, *u); err != nil {
+		log.Fatalf("Could not fetch %q: %v\n", u, err)
+	}
+}
+
+// printPage fetches and staticates u with c, writing the result to w and the
+// links found to links, one per line.
+func printPage(w, links io.Writer, c *crawler.Crawler, u url.URL) error {
+	res, found, err := c.Fetch(context.Background(), u)
+	if err != nil {
+		return err
+	}
+	for _, l := range found {
+		if _, err := fmt.Fprintln(links, l.String()); err != nil {
+			return err
+		}
+	}
+	if res == nil {
+		log.Printf("%q would not be stored.\n", &u)
+		return nil
+	}
+	if res.GetRedirect() != "" {
+		log.Printf("%q redirects to %q\n", &u, res.GetRedirect())
+	}
+	_, err = w.Write(res.GetContent())
+	return err
+}
+
+// mustPing checks the database is reachable and writable before any work is
+// done, rather than failing partway through.
+func mustPing(db storage.Storage) {
+	p, ok := db.(storage.Pinger)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := p.Ping(ctx); err != nil {
+		log.Fatalf("Database %q is not usable: %v\n", *dbPath, err)
+	}
+}
+
+// mustFetchSitemap returns the URLs in --sitemap as crawl seeds.
+func mustFetchSitemap(ctx context.Context, c *crawler.Crawler) []crawler.Seed {
+	u, err := url.Parse(*sitemapURL)
+	if err != nil {
+		log.Fatalf("Could not parse sitemap url %q: %v\n", *sitemapURL, err)
+	}
+	seeds, err := c.FetchSitemap(ctx, *u)
+	if err != nil {
+		log.Fatalf("Could not fetch sitemap: %v\n", err)
+	}
+	if !*sitemapPriority {
+		for i := range seeds {
+			seeds[i].Priority = 0
+		}
+	}
+	log.Printf("Found %d URLs in sitemap %q\n", len(seeds), u)
+	return seeds
+}
+
+// mustLogin logs the crawler in using the --login_* flags.
+func mustLogin(ctx context.Context, c *crawler.Crawler) {
+	u, err := url.Parse(*loginURL)
+	if err != nil {
+		log.Fatalf("Could not parse login url %q: %v\n", *loginURL, err)
+	}
+	conf := crawler.LoginConfig{
+		URL: *u,
+		Fields: map[string]string{
+			*loginUserField:     *loginUser,
+			*loginPasswordField: os.Getenv("POLYESTER_LOGIN_PASSWORD"),
+		},
+	}
+	if err := c.Login(ctx, conf); err != nil {
+		log.Fatalf("Login failed: %v\n", err)
+	}
+}
+
+// mustLoadState restores crawler state saved by a previous run, if any.
+func mustLoadState(c *crawler.Crawler, path string) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("No saved crawl state at %q. Starting from scratch.\n", path)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Could not open crawl state file %q: %v\n", path, err)
+	}
+	defer f.Close()
+	if err := c.LoadState(f); err != nil {
+		log.Fatalf("Could not load crawl state from %q: %v\n", path, err)
+	}
+}
+
+// mustSaveState saves crawler state for the next run, replacing the state file atomically.
+func mustSaveState(c *crawler.Crawler, path string) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		log.Fatalf("Could not create crawl state file for %q: %v\n", path, err)
+	}
+	if err := c.SaveState(f); err != nil {
+		log.Fatalf("Could not save crawl state to %q: %v\n", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Could not save crawl state to %q: %v\n", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		log.Fatalf("Could not save crawl state to %q: %v\n", path, err)
+	}
+}
+
+// mustLoadManifest reads a content manifest saved by a previous run, if any.
+func mustLoadManifest(path string) *crawler.ContentManifest {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("No content manifest at %q. Writing all content.\n", path)
+		return crawler.NewContentManifest()
+	}
+	if err != nil {
+		log.Fatalf("Could not open content manifest %q: %v\n", path, err)
+	}
+	defer f.Close()
+	m, err := crawler.LoadContentManifest(f)
+	if err != nil {
+		log.Fatalf("Could not load content manifest from %q: %v\n", path, err)
+	}
+	return m
+}
+
+// mustSaveManifest saves a content manifest, replacing the file atomically.
+func mustSaveManifest(m *crawler.ContentManifest, path string) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		log.Fatalf("Could not create content manifest for %q: %v\n", path, err)
+	}
+	if err := m.Save(f); err != nil {
+		log.Fatalf("Could not save content manifest to %q: %v\n", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Could not save content manifest to %q: %v\n", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		log.Fatalf("Could not save content manifest to %q: %v\n", path, err)
+	}
 }
 
 func mustLoadSiteConfig(path string) *site.Config {
@@ -108,9 +718,33 @@ func mustLoadSiteConfig(path string) *site.Config {
 	if err != nil {
 		log.Fatalf("Could not open site config file %q: %v\n", path, err)
 	}
-	if siteConfig, err = site.Load(yaml); err != nil {
+	opts := site.LoadOptions{
+		JSON:      strings.EqualFold(filepath.Ext(path), ".json"),
+		StrictEnv: *strictEnv,
+	}
+	if siteConfig, err = site.LoadWith(yaml, opts); err != nil {
 		log.Fatalf("Could not parse site config file %q: %v\n", path, err)
 	}
 
 	return siteConfig
 }
+
+// reportProgress logs the crawler's progress every interval until the
+// returned function is called.
+func reportProgress(c *crawler.Crawler, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				p := c.Progress()
+				log.Printf("Progress: %d fetched, %d in flight, %d queued, %d errors\n", p.Fetched, p.InFlight, p.Queued, p.Errors)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/TheSnook/polyester/storage"
+)
+
+func TestListResources(t *testing.T) {
+	db := storage.NewMem()
+	for k, r := range map[string]*resource.Resource{
+		"/":                 {ContentType: "text/html", Content: []byte("<p>Home</p>")},
+		"/old/":             {Redirect: "/new/"},
+		"/wp-content/a.css": {ContentType: "text/css", Content: []byte("a{}")},
+		"/wp-content/b.png": {ContentType: "image/png", Content: make([]byte, 1234)},
+	} {
+		if err := db.Write(k, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := listResources(&b, db, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := `KEY                TYPE       SIZE  REDIRECT
+/                  text/html  11    
+/old/                         0     /new/
+/wp-content/a.css  text/css   3     
+/wp-content/b.png  image/png  1234  
+`
+	if b.String() != want {
+		t.Errorf("listResources() =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := listResources(&b, db, "/wp-content/b"); err != nil {
+		t.Fatal(err)
+	}
+	want = `KEY                TYPE       SIZE  REDIRECT
+/wp-content/b.png  image/png  1234  
+`
+	if b.String() != want {
+		t.Errorf("listResources() with a prefix =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestPrintPage(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, `<html><head></head><body><a href="`+srv.URL+`/about/">About</a> <img src="/logo.png"/></body></html>`)
+		case "/old/":
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No storage is needed.
+	c := newCrawler(u.Hostname(), nil, nil, nil)
+	var out, links bytes.Buffer
+	if err := printPage(&out, &links, c, *u); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<html><head></head><body><a href="/about/">About</a> <img src="/logo.png"/></body></html>`; out.String() != want {
+		t.Errorf("printPage() wrote\n%s\nwant\n%s", out.String(), want)
+	}
+	if want := srv.URL + "/about/\n"; links.String() != want {
+		t.Errorf("printPage() links =\n%s\nwant\n%s", links.String(), want)
+	}
+
+	// A redirect prints no page.
+	out.Reset()
+	links.Reset()
+	if err := printPage(&out, &links, c, *u.JoinPath("old/")); err != nil || out.Len() != 0 {
+		t.Errorf("printPage(/old/) = %v and wrote %q, want nothing", err, out.String())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cacheKey identifies a variant (e.g. an encoding) of the content stored
+// under a key at a crawl time. A recrawl changes the crawl time, so stale
+// entries are never hit and just age out.
+type cacheKey struct {
+	key       string
+	crawledAt time.Time
+	variant   string
+}
+
+type cacheEntry struct {
+	k cacheKey
+	v []byte
+}
+
+// byteCache is a least recently used cache of response bodies, holding at
+// most max bytes. A nil *byteCache caches nothing. It is safe for concurrent
+// use.
+type byteCache struct {
+	mu    sync.Mutex
+	max   int
+	size  int
+	order *list.List // Of *cacheEntry, most recently used first.
+	items map[cacheKey]*list.Element
+}
+
+func newByteCache(max int) *byteCache {
+	if max <= 0 {
+		return nil
+	}
+	return &byteCache{max: max, order: list.New(), items: map[cacheKey]*list.Element{}}
+}
+
+func (c *byteCache) Get(k cacheKey) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[k]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).v, true
+}
+
+// Add caches v, which must not be modified afterwards, evicting the least
+// recently used entries to make room.
+func (c *byteCache) Add(k cacheKey, v []byte) {
+	if c == nil || len(v) > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[k]; ok {
+		c.size -= len(e.Value.(*cacheEntry).v)
+		c.order.Remove(e)
+	}
+	c.items[k] = c.order.PushFront(&cacheEntry{k, v})
+	c.size += len(v)
+	for c.size > c.max {
+		e := c.order.Back()
+		old := e.Value.(*cacheEntry)
+		c.order.Remove(e)
+		delete(c.items, old.k)
+		c.size -= len(old.v)
+	}
+}
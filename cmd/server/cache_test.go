@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestByteCache(t *testing.T) {
+	c := newByteCache(10)
+	k := func(key string) cacheKey { return cacheKey{key: key, crawledAt: time.Unix(1, 0)} }
+	c.Add(k("a"), []byte("aaaa"))
+	c.Add(k("b"), []byte("bbbb"))
+	c.Get(k("a"))
+	// Evicts b, the least recently used.
+	c.Add(k("c"), []byte("cccc"))
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.Get(k(key)); ok != want {
+			t.Errorf("Get(%q) found = %v, want %v", key, ok, want)
+		}
+	}
+	// A recrawl is a different entry.
+	if _, ok := c.Get(cacheKey{key: "a", crawledAt: time.Unix(2, 0)}); ok {
+		t.Errorf("Found content crawled at another time")
+	}
+	// Too big to cache at all.
+	c.Add(k("d"), make([]byte, 11))
+	if _, ok := c.Get(k("d")); ok || c.size != 8 {
+		t.Errorf("Cached an oversized entry, size %d", c.size)
+	}
+	// Replacing an entry replaces its size.
+	c.Add(k("a"), []byte("aa"))
+	if v, _ := c.Get(k("a")); string(v) != "aa" || c.size != 6 {
+		t.Errorf("Replaced entry = %q, size %d", v, c.size)
+	}
+
+	var none *byteCache
+	none.Add(k("a"), []byte("a"))
+	if _, ok := none.Get(k("a")); ok || newByteCache(0) != nil {
+		t.Errorf("Disabled cache cached")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content encodings we can serve, most preferred first.
+var encodings = []struct {
+	name, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows an
+// encoding, i.e. lists it without q=0, or else lists "*" without q=0.
+func acceptsEncoding(accept, enc string) bool {
+	wildcard := false
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != enc && name != "*" {
+			continue
+		}
+		ok := true
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				ok = false
+			}
+		}
+		if name == enc {
+			// Naming the encoding overrides any wildcard.
+			return ok
+		}
+		wildcard = ok
+	}
+	return wildcard
+}
+
+// isCompressible reports whether content of a type is worth compressing.
+// Images, video and fonts (other than SVG) are already compressed.
+func isCompressible(contentType string) bool {
+	t, _, _ := strings.Cut(contentType, ";")
+	t = strings.ToLower(strings.TrimSpace(t))
+	return strings.HasPrefix(t, "text/") ||
+		strings.HasSuffix(t, "+xml") || strings.HasSuffix(t, "+json") ||
+		t == "application/json" || t == "application/javascript" || t == "application/xml"
+}
+
+// preferredEncoding returns the best encoding a request accepts, or "" if
+// none.
+func preferredEncoding(req *http.Request) string {
+	accept := req.Header.Get("Accept-Encoding")
+	for _, e := range encodings {
+		if acceptsEncoding(accept, e.name) {
+			return e.name
+		}
+	}
+	return ""
+}
+
+// encodeContent compresses content with an encoding from encodings.
+func encodeContent(content []byte, enc string) ([]byte, error) {
+	var b bytes.Buffer
+	var err error
+	switch enc {
+	case "br":
+		bw := brotli.NewWriterLevel(&b, brotli.DefaultCompression)
+		if _, err = bw.Write(content); err == nil {
+			err = bw.Close()
+		}
+	case "gzip":
+		gw := gzip.NewWriter(&b)
+		if _, err = gw.Write(content); err == nil {
+			err = gw.Close()
+		}
+	default:
+		err = fmt.Errorf("unknown encoding %q", enc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// precompressedAssets serves a precompressed variant of an asset file, e.g.
+// style.css.br for style.css, if the client accepts its encoding and it
+// exists in dir. Otherwise the request is passed on to h.
+func precompressedAssets(h http.Handler, dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p := path.Clean("/" + req.URL.Path)
+		w.Header().Add("Vary", "Accept-Encoding")
+		accept := req.Header.Get("Accept-Encoding")
+		for _, e := range encodings {
+			if !acceptsEncoding(accept, e.name) {
+				continue
+			}
+			f := filepath.Join(dir, filepath.FromSlash(p)+e.ext)
+			if fi, err := os.Stat(f); err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			if t := mime.TypeByExtension(path.Ext(p)); t != "" {
+				w.Header().Set("Content-Type", t)
+			}
+			w.Header().Set("Content-Encoding", e.name)
+			http.ServeFile(w, req, f)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/andybalholm/brotli"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		accept, enc string
+		want        bool
+	}{
+		{"gzip, deflate, br", "br", true},
+		{"gzip, deflate", "br", false},
+		{"GZIP", "gzip", true},
+		{"br;q=0.5, gzip;q=1.0", "br", true},
+		{"br;q=0", "br", false},
+		{"*", "br", true},
+		{"*;q=0", "gzip", false},
+		{"*, gzip;q=0", "gzip", false},
+		{"gzip;q=0, *", "gzip", false},
+		{"*, gzip;q=0", "br", true},
+		{"*;q=0, br", "br", true},
+		{"", "gzip", false},
+	} {
+		if got := acceptsEncoding(tc.accept, tc.enc); got != tc.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tc.accept, tc.enc, got, tc.want)
+		}
+	}
+}
+
+func decode(t *testing.T, enc string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch enc {
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	default:
+		return string(body)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompress(t *testing.T) {
+	const page = "<p>Hello, world. Hello, world. Hello, world.</p>"
+	setFlag(t, compress, true)
+	h := newTestHandler(t, map[string]*resource.Resource{
+		"/":         htmlPage(page),
+		"/logo.png": {ContentType: "image/png", Content: []byte("PNG")},
+	})
+	for _, tc := range []struct {
+		accept, want string
+	}{
+		{"gzip, deflate, br", "br"},
+		{"gzip, deflate", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"*, br;q=0", "gzip"},
+		{"identity", ""},
+		{"", ""},
+	} {
+		w := get(h, "/", "Accept-Encoding", tc.accept)
+		if got := w.Header().Get("Content-Encoding"); got != tc.want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", tc.accept, got, tc.want)
+		}
+		if got := decode(t, tc.want, w.Body.Bytes()); got != page {
+			t.Errorf("Accept-Encoding %q: body %q, want %q", tc.accept, got, page)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary %q", tc.accept, got)
+		}
+	}
+
+	// Already compressed.
+	if w := get(h, "/logo.png", "Accept-Encoding", "br"); w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "" {
+		t.Errorf("Image served with Content-Encoding %q, Vary %q", w.Header().Get("Content-Encoding"), w.Header().Get("Vary"))
+	}
+
+	// A cache receiving a 304 must still know the response varies.
+	w := get(h, "/", "Accept-Encoding", "br", "If-Modified-Since", "Tue, 02 Jan 2024 03:04:05 GMT")
+	if w.Code != http.StatusNotModified || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Not modified: got %d with Vary %q, want 304 with Accept-Encoding", w.Code, w.Header().Get("Vary"))
+	}
+}
+
+func TestCompressCache(t *testing.T) {
+	setFlag(t, compress, true)
+	res := htmlPage("<p>Cached</p>")
+	h := newTestHandler(t, map[string]*resource.Resource{"/": res})
+	first := get(h, "/", "Accept-Encoding", "br").Body.Bytes()
+	k := cacheKey{key: "/", crawledAt: res.CrawledAt.AsTime(), variant: "\x00br"}
+	if c, ok := h.cache.Get(k); !ok || !bytes.Equal(c, first) {
+		t.Fatalf("Brotli response not cached")
+	}
+	// Served from the cache, not compressed again.
+	h.cache.Add(k, []byte("from cache"))
+	if got := get(h, "/", "Accept-Encoding", "br").Body.String(); got != "from cache" {
+		t.Errorf("Second response = %q, want the cached one", got)
+	}
+	// Other encodings are cached separately.
+	if got := decode(t, "gzip", get(h, "/", "Accept-Encoding", "gzip").Body.Bytes()); got != "<p>Cached</p>" {
+		t.Errorf("gzip response = %q", got)
+	}
+}
+
+func TestPrecompressedAssets(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"style.css":    "body{}",
+		"style.css.br": "BROTLI",
+		"style.css.gz": "GZIP",
+		"app.js":       "app()",
+		"app.js.gz":    "GZIP",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := precompressedAssets(http.FileServer(http.Dir(dir)), dir)
+	for _, tc := range []struct {
+		path, accept, enc, body string
+	}{
+		{"/style.css", "gzip, br", "br", "BROTLI"},
+		{"/style.css", "gzip", "gzip", "GZIP"},
+		{"/style.css", "", "", "body{}"},
+		{"/app.js", "br", "", "app()"},
+		{"/app.js", "br, gzip", "gzip", "GZIP"},
+	} {
+		w := get(h, tc.path, "Accept-Encoding", tc.accept)
+		if got := w.Header().Get("Content-Encoding"); got != tc.enc || w.Body.String() != tc.body {
+			t.Errorf("%s with Accept-Encoding %q: %q encoded as %q, want %q as %q", tc.path, tc.accept, w.Body.String(), got, tc.body, tc.enc)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/") {
+			t.Errorf("%s: Content-Type %q", tc.path, ct)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheSnook/polyester/crawler"
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/TheSnook/polyester/storage"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// setFlag sets a flag's value for the duration of a test.
+func setFlag[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// newTestHandler returns a StorageHandler serving resources from memory.
+func newTestHandler(t *testing.T, resources map[string]*resource.Resource) *StorageHandler {
+	t.Helper()
+	db := storage.NewMem()
+	for k, r := range resources {
+		if err := db.Write(k, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewStorageHandler(db, nil, nil)
+}
+
+// get requests a path from a handler, with optional header name/value pairs.
+func get(h http.Handler, path string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// htmlPage returns a stored HTML page crawled at a fixed time.
+func htmlPage(body string) *resource.Resource {
+	return &resource.Resource{
+		ContentType: "text/html; charset=utf-8",
+		Content:     []byte(body),
+		CrawledAt:   timestamppb.New(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	h := newTestHandler(t, map[string]*resource.Resource{"/": htmlPage("<p>Hello, world. Hello, world. Hello, world.</p>")})
+	check := func(name string, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if got, want := w.Header().Get("Content-Length"), strconv.Itoa(w.Body.Len()); got != want {
+			t.Errorf("%s: Content-Length %q, want %q", name, got, want)
+		}
+	}
+	check("plain", get(h, "/"))
+
+	setFlag(t, compress, true)
+	w := get(h, "/", "Accept-Encoding", "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Response not gzipped")
+	}
+	check("gzip", w)
+
+	w = get(h, "/", "If-Modified-Since", "Tue, 02 Jan 2024 03:04:05 GMT")
+	if w.Code != http.StatusNotModified || w.Header().Get("Content-Length") != "" || w.Body.Len() != 0 {
+		t.Errorf("If-Modified-Since: got %d with Content-Length %q, want a bare 304", w.Code, w.Header().Get("Content-Length"))
+	}
+}
+
+func TestStoredRedirects(t *testing.T) {
+	h := newTestHandler(t, map[string]*resource.Resource{
+		"/old/":       {Redirect: "/new/?page=2"},
+		"/away/":      {Redirect: "https://elsewhere.example/x/"},
+		"/sneaky/":    {Redirect: "//evil.example/"},
+		"/backslash/": {Redirect: "/\\evil.example/"},
+		"/script/":    {Redirect: "javascript:alert(1)"},
+		"/relative/":  {Redirect: "new/"},
+		"/malformed/": {Redirect: "https://[::1/"},
+	})
+	for path, want := range map[string]string{
+		"/old/":  "/new/?page=2",
+		"/away/": "https://elsewhere.example/x/",
+	} {
+		w := get(h, path)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != want {
+			t.Errorf("GET %s = %d to %q, want 301 to %q", path, w.Code, w.Header().Get("Location"), want)
+		}
+	}
+	for _, path := range []string{"/sneaky/", "/backslash/", "/script/", "/relative/", "/malformed/"} {
+		w := get(h, path)
+		if w.Code != http.StatusInternalServerError || w.Header().Get("Location") != "" {
+			t.Errorf("GET %s = %d to %q, want a 500 without a Location", path, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestKeyTransformRoundTrip(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<p>Page %s</p>", r.URL.Path)
+	}))
+	defer origin.Close()
+	u, _ := url.Parse(origin.URL + "/a/")
+	for _, spec := range []string{"html_suffix", "prefix=/site"} {
+		kt, err := crawler.ParseKeyTransform(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := storage.NewMem()
+		c := crawler.New(u.Hostname(), nil, db)
+		c.KeyTransform = kt
+		if err := c.CrawlOne(context.Background(), *u); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := db.Exists(kt("/a/")); !ok {
+			t.Errorf("%s: page not stored under %q", spec, kt("/a/"))
+		}
+		w := get(NewStorageHandler(db, nil, kt), "/a/")
+		if w.Code != 200 || !strings.Contains(w.Body.String(), "Page /a/") {
+			t.Errorf("%s: GET /a/ = %d %q, want the page", spec, w.Code, w.Body.String())
+		}
+		// Without the transform, the page can't be found. (/a/index.html
+		// would be, as the index document.)
+		if w := get(NewStorageHandler(db, nil, nil), "/a/"); spec == "prefix=/site" && w.Code != http.StatusNotFound {
+			t.Errorf("%s: GET /a/ without the transform = %d, want 404", spec, w.Code)
+		}
+		// The health check looks up its key with the transform too.
+		setFlag(t, healthKey, "/a/")
+		if w := get(NewStorageHandler(db, nil, kt), "/healthz"); w.Code != 200 {
+			t.Errorf("%s: GET /healthz = %d %q, want 200", spec, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestServeTimeRelativize(t *testing.T) {
+	var origin *httptest.Server
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<html><head><link rel="stylesheet" href="%[1]s/style.css" integrity="sha384-abc">`+
+			`<link rel="canonical" href="%[1]s/a/"></head><body><a href="%[1]s/b/">B</a> `+
+			`<a href="https://shop.example.com/cart/">Cart</a> <span style="background: url(%[1]s/bg.png)">C</span></body></html>`, origin.URL)
+	}))
+	defer origin.Close()
+	u, _ := url.Parse(origin.URL + "/a/")
+	crawl := func(setup func(c *crawler.Crawler)) storage.Storage {
+		db := storage.NewMem()
+		c := crawler.New(u.Hostname(), []string{"shop.example.com"}, db)
+		c.KeepAbsoluteHosts = []string{"shop.example.com"}
+		c.StripIntegrity = true
+		c.RewriteCSS = true
+		setup(c)
+		if err := c.CrawlOne(context.Background(), *u); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	now := crawl(func(c *crawler.Crawler) { c.PublishDomain = "example.org" })
+	later := crawl(func(c *crawler.Crawler) { c.DeferRelativize = true })
+	want, err := now.Read("/a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, relativize, true)
+	setFlag(t, localDomains, "shop.example.com")
+	setFlag(t, keepAbsoluteHosts, "shop.example.com")
+	setFlag(t, stripIntegrity, true)
+	setFlag(t, rewriteCSS, true)
+	setFlag(t, publishDomains, "example.org,example.net")
+	h := NewStorageHandler(later, nil, nil)
+	w := get(h, "/a/")
+	if w.Code != 200 || w.Body.String() != string(want.GetContent()) {
+		t.Errorf("GET /a/ = %d\n%s\nwant as crawled\n%s", w.Code, w.Body.String(), want.GetContent())
+	}
+
+	// Each publish domain's page is cached, as of its crawl time.
+	stored, err := later.Read("/a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/a/", nil)
+	req.Host = "example.net"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	for _, domain := range []string{"example.org", "example.net"} {
+		c, ok := h.cache.Get(cacheKey{key: "/a/", crawledAt: stored.GetCrawledAt().AsTime(), variant: domain})
+		if !ok {
+			t.Errorf("Page for %s not cached", domain)
+		} else if domain == "example.net" && string(c) != w.Body.String() {
+			t.Errorf("Cached page for %s differs from that served", domain)
+		}
+	}
+	if !strings.Contains(w.Body.String(), `href="http://example.net/a/"`) {
+		t.Errorf("Canonical link not published under example.net:\n%s", w.Body.String())
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	h := newTestHandler(t, map[string]*resource.Resource{
+		"/index.html": htmlPage(`<div id="app"></div>`),
+		"/about/":     htmlPage("<p>About</p>"),
+	})
+	setFlag(t, spaFallback, "/index.html")
+	for path, want := range map[string]string{
+		"/app/settings": `<div id="app"></div>`,
+		"/about/":       "<p>About</p>",
+	} {
+		if w := get(h, path); w.Code != 200 || w.Body.String() != want {
+			t.Errorf("GET %s = %d %q, want 200 %q", path, w.Code, w.Body.String(), want)
+		}
+	}
+	if w := get(h, "/missing.js"); w.Code != 404 {
+		t.Errorf("GET /missing.js = %d, want 404", w.Code)
+	}
+
+	setFlag(t, spaFallback, "")
+	if w := get(h, "/app/settings"); w.Code != 404 {
+		t.Errorf("GET /app/settings without a fallback = %d, want 404", w.Code)
+	}
+}
+
+func TestIndexDocument(t *testing.T) {
+	h := newTestHandler(t, map[string]*resource.Resource{
+		"/blog/":           htmlPage("<p>Blog</p>"),
+		"/docs/index.html": htmlPage("<p>Docs</p>"),
+		"/docs/index.htm":  htmlPage("<p>Old docs</p>"),
+		"/both/":           htmlPage("<p>Crawled</p>"),
+		"/both/index.html": htmlPage("<p>Uploaded</p>"),
+		"/style.css":       {ContentType: "text/css", Content: []byte("p{}")},
+	})
+	for path, want := range map[string]string{
+		"/blog":            "<p>Blog</p>",
+		"/blog/":           "<p>Blog</p>",
+		"/docs/":           "<p>Docs</p>",
+		"/docs":            "<p>Docs</p>",
+		"/docs/index.html": "<p>Docs</p>",
+		"/both/":           "<p>Crawled</p>",
+	} {
+		if w := get(h, path); w.Code != 200 || w.Body.String() != want {
+			t.Errorf("GET %s = %d %q, want 200 %q", path, w.Code, w.Body.String(), want)
+		}
+	}
+	// Paths with extensions are files, not directories.
+	if w := get(h, "/style.css/"); w.Code != 404 {
+		t.Errorf("GET /style.css/ = %d, want 404", w.Code)
+	}
+
+	setFlag(t, indexDocument, "index.htm")
+	if w := get(h, "/docs/"); w.Code != 200 || w.Body.String() != "<p>Old docs</p>" {
+		t.Errorf("GET /docs/ with index.htm = %d %q, want the old docs", w.Code, w.Body.String())
+	}
+	setFlag(t, indexDocument, "")
+	if w := get(h, "/docs/"); w.Code != 404 {
+		t.Errorf("GET /docs/ without an index document = %d, want 404", w.Code)
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	h := newTestHandler(t, map[string]*resource.Resource{"/": htmlPage("<p>Hi</p>")})
+	w := get(h, "/")
+	if got, want := w.Header().Get("Last-Modified"), "Tue, 02 Jan 2024 03:04:05 GMT"; w.Code != 200 || got != want {
+		t.Fatalf("Got %d with Last-Modified %q, want 200 with %q", w.Code, got, want)
+	}
+
+	for _, tc := range []struct {
+		ims  string
+		want int
+	}{
+		{"Tue, 02 Jan 2024 03:04:05 GMT", http.StatusNotModified},
+		{"Wed, 03 Jan 2024 00:00:00 GMT", http.StatusNotModified},
+		{"Tuesday, 02-Jan-24 03:04:05 GMT", http.StatusNotModified}, // RFC 850
+		{"Tue Jan  2 03:04:05 2024", http.StatusNotModified},        // ANSI C
+		{"Tue, 02 Jan 2024 03:04:04 GMT", http.StatusOK},
+		{"Mon, 01 Jan 2024 23:59:59 GMT", http.StatusOK},
+		{"yesterday", http.StatusOK},
+	} {
+		w := get(h, "/", "If-Modified-Since", tc.ims)
+		if w.Code != tc.want {
+			t.Errorf("If-Modified-Since %q: got %d, want %d", tc.ims, w.Code, tc.want)
+		}
+		if tc.want == http.StatusOK && w.Body.String() != "<p>Hi</p>" {
+			t.Errorf("If-Modified-Since %q: got body %q", tc.ims, w.Body.String())
+		}
+	}
+
+	// Only GET and HEAD are conditional.
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("If-Modified-Since", "Wed, 03 Jan 2024 00:00:00 GMT")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("POST with If-Modified-Since: got %d, want 200", w.Code)
+	}
+}
+
+// noBucketStorage fails like a bbolt database whose bucket is gone.
+type noBucketStorage struct {
+	*storage.MemStorage
+}
+
+var errNoBucket = errors.New(`bucket "polyester" not found in database "site.db"`)
+
+func (s *noBucketStorage) Ping(ctx context.Context) error { return errNoBucket }
+
+func (s *noBucketStorage) Read(k string) (*resource.Resource, error) { return nil, errNoBucket }
+
+func TestMissingBucket(t *testing.T) {
+	h := NewStorageHandler(&noBucketStorage{storage.NewMem()}, nil, nil)
+	for _, path := range []string{"/", "/blog", "/missing.css"} {
+		if w := get(h, path); w.Code != http.StatusInternalServerError {
+			t.Errorf("GET %s without the bucket = %d, want 500", path, w.Code)
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "site.db")
+	db := storage.New("bbolt:" + path + ":polyester")
+	if err := db.Write("/", htmlPage("<p>Home</p>")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	h := NewStorageHandler(storage.New("bbolt:"+path+":polyester:ro"), nil, nil)
+	defer h.Close()
+
+	w := get(h, "/healthz")
+	if w.Code != 200 || w.Body.String() != "OK\r\n" {
+		t.Errorf("Healthy database: got %d %q, want 200 OK", w.Code, w.Body.String())
+	}
+
+	setFlag(t, healthKey, "/missing/")
+	w = get(h, "/healthz")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "/missing/") {
+		t.Errorf("Missing health key: got %d %q, want 503 naming the key", w.Code, w.Body.String())
+	}
+
+	// A read-only database lacking the bucket can't be opened at all, but one
+	// being written to can lose it.
+	w = get(NewStorageHandler(&noBucketStorage{storage.NewMem()}, nil, nil), "/healthz")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "bucket") {
+		t.Errorf("Missing bucket: got %d %q, want 503 naming the bucket", w.Code, w.Body.String())
+	}
+}
+
+func TestKeyNamespaces(t *testing.T) {
+	db, err := storage.ParseNamespaces(storage.NewMem(), "html,assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, r := range map[string]*resource.Resource{
+		"/about/":   htmlPage("<p>About</p>"),
+		"/logo.png": {ContentType: "image/png", Content: []byte("PNG")},
+	} {
+		if err := db.Write(k, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewStorageHandler(db, nil, nil)
+	for path, want := range map[string]string{"/about/": "<p>About</p>", "/logo.png": "PNG"} {
+		if w := get(h, path); w.Code != 200 || w.Body.String() != want {
+			t.Errorf("GET %s = %d %q, want 200 %q", path, w.Code, w.Body.String(), want)
+		}
+	}
+}
+
+// hangingStorage is reachable, but never answers a ping.
+type hangingStorage struct {
+	*storage.MemStorage
+}
+
+func (s *hangingStorage) Ping(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestHealthTimeout(t *testing.T) {
+	setFlag(t, healthTimeout, 10*time.Millisecond)
+	setFlag(t, healthKey, "")
+	h := NewStorageHandler(&hangingStorage{storage.NewMem()}, nil, nil)
+	w := get(h, "/healthz")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "deadline exceeded") {
+		t.Errorf("GET /healthz of a hanging database = %d %q, want 503 after the timeout", w.Code, w.Body.String())
+	}
+}
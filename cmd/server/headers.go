@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// HeaderRule adds response headers to content served for paths matching a
+// glob, e.g. a Content-Security-Policy for the whole site, or a longer
+// Cache-Control for /static/**.
+type HeaderRule struct {
+	// Path is a path.Match pattern, e.g. "/blog/*.html", in which * doesn't
+	// match "/". A trailing "/**" matches everything under a directory.
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// matches reports whether the rule applies to a request path.
+func (r HeaderRule) matches(p string) bool {
+	if dir, ok := strings.CutSuffix(r.Path, "/**"); ok {
+		return strings.HasPrefix(p, dir+"/")
+	}
+	ok, _ := path.Match(r.Path, p)
+	return ok
+}
+
+// LoadHeaderRules reads a YAML list of header rules from a file, each with a
+// path pattern and a map of headers, e.g.
+//
+//	[{path: "/**", headers: {X-Frame-Options: DENY}}]
+func LoadHeaderRules(file string) ([]HeaderRule, error) {
+	in, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	d := yaml.NewDecoder(bytes.NewReader(in))
+	d.KnownFields(true)
+	var rules []HeaderRule
+	if err := d.Decode(&rules); err != nil {
+		return nil, err
+	}
+	for i, r := range rules {
+		if _, err := path.Match(r.Path, ""); err != nil || !strings.HasPrefix(r.Path, "/") {
+			return nil, fmt.Errorf("rule %d: bad path pattern %q", i+1, r.Path)
+		}
+		if len(r.Headers) == 0 {
+			return nil, fmt.Errorf("rule %d (%q): no headers", i+1, r.Path)
+		}
+	}
+	return rules, nil
+}
+
+// applyHeaderRules sets the headers of every rule matching a request path.
+// Rules are applied in order, so later rules override earlier ones, and all
+// override the headers the server sets itself.
+func applyHeaderRules(h http.Header, rules []HeaderRule, p string) {
+	for _, r := range rules {
+		if !r.matches(p) {
+			continue
+		}
+		for k, v := range r.Headers {
+			h.Set(k, v)
+		}
+	}
+}
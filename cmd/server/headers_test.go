@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+)
+
+func TestLoadHeaderRules(t *testing.T) {
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		yaml string
+		ok   bool
+	}{
+		"valid":     {`[{path: "/blog/**", headers: {Content-Security-Policy: "default-src 'self'"}}, {path: "/*.css", headers: {Cache-Control: max-age=60}}]`, true},
+		"relative":  {`[{path: "blog/**", headers: {X-Frame-Options: DENY}}]`, false},
+		"bad glob":  {`[{path: "/[", headers: {X-Frame-Options: DENY}}]`, false},
+		"no header": {`[{path: "/**"}]`, false},
+		"typo":      {`[{path: "/**", header: {X-Frame-Options: DENY}}]`, false},
+	} {
+		f := filepath.Join(dir, "rules.yaml")
+		if err := os.WriteFile(f, []byte(tc.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadHeaderRules(f); (err == nil) != tc.ok {
+			t.Errorf("%s: LoadHeaderRules() = %v, want ok %v", name, err, tc.ok)
+		}
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	h := newTestHandler(t, map[string]*resource.Resource{
+		"/":           htmlPage("<p>Home</p>"),
+		"/blog/":      htmlPage("<p>Blog</p>"),
+		"/blog/post/": htmlPage("<p>Post</p>"),
+		"/blog/old/":  {Redirect: "/blog/post/"},
+	})
+	const csp = "default-src 'self'"
+	h.headerRules = []HeaderRule{
+		{Path: "/**", Headers: map[string]string{"X-Frame-Options": "DENY", "Cache-Control": "max-age=60"}},
+		{Path: "/blog/**", Headers: map[string]string{"Content-Security-Policy": csp, "Cache-Control": "max-age=3600"}},
+	}
+	for path, want := range map[string]string{"/": "", "/blog/post/": csp, "/blog/old/": csp} {
+		w := get(h, path)
+		if got := w.Header().Get("Content-Security-Policy"); got != want {
+			t.Errorf("GET %s: Content-Security-Policy %q, want %q", path, got, want)
+		}
+		if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("GET %s: X-Frame-Options %q, want DENY", path, got)
+		}
+	}
+	// Later rules override earlier ones.
+	if got := get(h, "/blog/post/").Header().Get("Cache-Control"); got != "max-age=3600" {
+		t.Errorf("GET /blog/post/: Cache-Control %q, want max-age=3600", got)
+	}
+	// "/blog/**" is everything under /blog/, which includes its index page.
+	if got := get(h, "/blog/").Header().Get("Content-Security-Policy"); got != csp {
+		t.Errorf("GET /blog/: Content-Security-Policy %q, want %q", got, csp)
+	}
+	// And they apply to 304s too.
+	w := get(h, "/blog/post/", "If-Modified-Since", "Tue, 02 Jan 2024 03:04:05 GMT")
+	if w.Code != http.StatusNotModified || w.Header().Get("Content-Security-Policy") != csp {
+		t.Errorf("GET /blog/post/ if modified: %d with CSP %q, want 304 with the CSP", w.Code, w.Header().Get("Content-Security-Policy"))
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const hitsBucket = "hits"
+
+// HitCounter counts requests per path, persisting the counts to a bbolt
+// database. Hits are batched in memory and flushed periodically, to avoid a
+// write transaction per request.
+type HitCounter struct {
+	db      *bbolt.DB
+	mu      sync.Mutex
+	pending map[string]uint64 // Hits not yet flushed to db.
+	done    chan struct{}
+}
+
+func NewHitCounter(dbPath string, flushEvery time.Duration) (*HitCounter, error) {
+	db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(hitsBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	h := &HitCounter{
+		db:      db,
+		pending: map[string]uint64{},
+		done:    make(chan struct{}),
+	}
+	go func() {
+		t := time.NewTicker(flushEvery)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := h.Flush(); err != nil {
+					log.Printf("Error flushing hit counts: %v", err)
+				}
+			case <-h.done:
+				return
+			}
+		}
+	}()
+	return h, nil
+}
+
+// Hit records one request for a path.
+func (h *HitCounter) Hit(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending[path]++
+}
+
+// Flush adds all pending hits to the stored counts.
+func (h *HitCounter) Flush() error {
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = map[string]uint64{}
+	h.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	return h.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(hitsBucket))
+		for k, n := range pending {
+			if v := b.Get([]byte(k)); v != nil {
+				n += binary.BigEndian.Uint64(v)
+			}
+			if err := b.Put([]byte(k), binary.BigEndian.AppendUint64(nil, n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type pathHits struct {
+	path string
+	hits uint64
+}
+
+// Top returns the `n` most-requested paths, including unflushed hits.
+func (h *HitCounter) Top(n int) ([]pathHits, error) {
+	counts := map[string]uint64{}
+	h.mu.Lock()
+	for k, v := range h.pending {
+		counts[k] = v
+	}
+	h.mu.Unlock()
+	err := h.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(hitsBucket)).ForEach(func(k, v []byte) error {
+			counts[string(k)] += binary.BigEndian.Uint64(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	top := make([]pathHits, 0, len(counts))
+	for k, v := range counts {
+		top = append(top, pathHits{k, v})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].hits != top[j].hits {
+			return top[i].hits > top[j].hits
+		}
+		return top[i].path < top[j].path
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top, nil
+}
+
+// ServeTop writes a plain-text list of the most-requested paths.
+func (h *HitCounter) ServeTop(w http.ResponseWriter, req *http.Request) {
+	top, err := h.Top(100)
+	if err != nil {
+		log.Printf("Error reading hit counts: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, t := range top {
+		fmt.Fprintf(w, "%d\t%s\r\n", t.hits, t.path)
+	}
+}
+
+// Close flushes any pending hits and closes the database.
+func (h *HitCounter) Close() {
+	close(h.done)
+	if err := h.Flush(); err != nil {
+		log.Printf("Error flushing hit counts: %v", err)
+	}
+	h.db.Close()
+}
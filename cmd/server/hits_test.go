@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheSnook/polyester/proto/resource"
+)
+
+func TestHitCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hits.db")
+	hits, err := NewHitCounter(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t, map[string]*resource.Resource{
+		"/":    htmlPage("Home"),
+		"/a/":  htmlPage("A"),
+		"/b/":  htmlPage("B"),
+		"/old": {Redirect: "/a/"},
+	})
+	h.hits = hits
+	for _, p := range []string{"/a/", "/", "/a/", "/b/", "/a/", "/", "/old", "/missing/"} {
+		get(h, p)
+	}
+	if err := hits.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Counts survive a restart.
+	hits.Close()
+	if hits, err = NewHitCounter(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer hits.Close()
+	h.hits = hits
+	get(h, "/b/")
+
+	w := get(h, "/topz")
+	want := "3\t/a/\r\n2\t/\r\n2\t/b/\r\n1\t/old\r\n"
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("GET /topz = %d %q, want %q", w.Code, w.Body.String(), want)
+	}
+}
+
+func TestTopzWithoutHits(t *testing.T) {
+	h := newTestHandler(t, map[string]*resource.Resource{"/topz": htmlPage("A page")})
+	if w := get(h, "/topz"); w.Code != 404 {
+		t.Errorf("GET /topz without hit counting = %d, want 404", w.Code)
+	}
+}
@@ -6,18 +6,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/TheSnook/polyester/proto/resource"
-	"go.etcd.io/bbolt"
-	"google.golang.org/protobuf/proto"
+	"github.com/TheSnook/polyester/crawler"
+	"github.com/TheSnook/polyester/storage"
 )
 
 // URL prefixes to serve from assets in the filesystem. Others are blocked.
@@ -36,76 +41,150 @@ var _DEFAULT_ASSET_PATHS = []string{
 	"wp-includes/js",
 }
 
+// Content types for asset file extensions which Go or the OS may get wrong.
+var _DEFAULT_MIME_TYPES = []string{
+	".ico=image/x-icon",
+	".mjs=text/javascript",
+	".otf=font/otf",
+	".ttf=font/ttf",
+	".webmanifest=application/manifest+json",
+	".woff=font/woff",
+	".woff2=font/woff2",
+}
+
 var port = flag.Int("port", 8080, "TCP port to listen on.")
 var assetRoot = flag.String("asset_root", "/var/www/html", "Local root of asset files.")
 var assetPaths = flag.String("asset_paths", strings.Join(_DEFAULT_ASSET_PATHS, ","), "Allowed paths under the asset root to serve assets from.")
+var mimeTypes = flag.String("mime_types", strings.Join(_DEFAULT_MIME_TYPES, ","), "Comma-separated list of .ext=content/type overrides for serving assets.")
 var dbPath = flag.String("db", "", "Database of staticated content.") // TODO: Make this a handler URI as used in polyester.go
 var dbBucket = flag.String("bucket", "polyester", "BBolt bucket to read from.")
+var spaFallback = flag.String("spa_fallback", "", "Key of a page (e.g. /index.html) to serve for unknown paths without a file extension, for client-side routed apps.")
+var hitsDB = flag.String("hits_db", "", "If set, count requests per path in this bbolt database, and list the top paths at /topz.")
+var noQuerySort = flag.Bool("no_query_sort", false, "Look up content with query parameters in their original order. Must match the crawler's --no_query_sort.")
+var healthTimeout = flag.Duration("health_timeout", 5*time.Second, "How long /healthz waits for the database to respond before reporting it unusable.")
+var healthKey = flag.String("health_key", "/", "Key which /healthz checks is readable. Empty to only check the database is open.")
+var compress = flag.Bool("compress", false, "Compress text content from the database with Brotli or gzip, if the client accepts it.")
+var precompressed = flag.Bool("precompressed", false, "Serve precompressed .br or .gz variants of asset files, if present and the client accepts them.")
+var keyTransform = flag.String("key_transform", "", "Transforms of storage keys used by the crawler's --key_transform, to apply when looking up content.")
+var indexDocument = flag.String("index_document", "index.html", "File name of directory index pages, e.g. /blog/index.html, served for /blog/ if that isn't stored itself. Empty to disable.")
+var headerRulesFile = flag.String("header_rules", "", "YAML file listing extra response headers for content from the database, by path glob, e.g. [{path: \"/static/**\", headers: {Cache-Control: max-age=86400}}]. Later rules override earlier ones.")
+var relativize = flag.Bool("relativize", false, "Relativize local URLs in HTML when serving it, for content crawled with --defer_relativize.")
+var localDomains = flag.String("local_domains", "", "With --relativize, comma-separated hostnames whose URLs are local besides each page's own, i.e. the crawl's --url host and --domains.")
+var keepAbsoluteHosts = flag.String("keep_absolute_hosts", "", "With --relativize, comma-separated hosts whose URLs are left absolute, as given to the crawler's flag of the same name.")
+var elementURLAttrs = flag.String("element_url_attrs", "", "With --relativize, comma-separated tag:attribute pairs of custom elements' URL attributes to relativize, as given to the crawler's flag of the same name. Those of AMP elements are always relativized.")
+var stripIntegrity = flag.Bool("strip_integrity", false, "With --relativize, remove integrity and crossorigin attributes pointing at the mirror, as the crawler's flag of the same name does.")
+var rewriteCSS = flag.Bool("rewrite_css", false, "With --relativize, relativize URLs in <style> elements and style attributes, as the crawler's flag of the same name does.")
+var publishDomains = flag.String("publish_domains", "", "With --relativize, comma-separated domains the content is published under, which replace the origin in URLs that must stay absolute. Requests for one of these use it, others the first.")
+var keyNamespaces = flag.String("key_namespaces", "", "Prefixes of pages and assets used by the crawler's --key_namespaces, e.g. html,assets.")
+var cacheMB = flag.Int("cache_mb", 64, "Memory for caching compressed responses, in MB. 0 to disable.")
+var debugHeaders = flag.Bool("debug_headers", false, "Add X-Polyester-* headers describing the origin of served content.")
+
+// registerMimeTypes applies the --mime_types overrides used when serving assets.
+func registerMimeTypes() {
+	if *mimeTypes == "" {
+		return
+	}
+	for _, m := range strings.Split(*mimeTypes, ",") {
+		ext, typ, ok := strings.Cut(m, "=")
+		if !ok {
+			log.Fatalf("MIME type override %q does not have expected format \".ext=content/type\".", m)
+		}
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			log.Fatalf("Bad MIME type override %q: %v", m, err)
+		}
+	}
+}
+
+// versionedAssets serves assets without regard to their query string, which
+// WordPress uses only for cache-busting (e.g. style.css?ver=1.2). Versioned
+// assets can be cached indefinitely, since a new version gets a new URL.
+func versionedAssets(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.RawQuery != "" {
+			if req.URL.Query().Has("ver") {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			}
+			r := new(http.Request)
+			*r = *req
+			r.URL = new(url.URL)
+			*r.URL = *req.URL
+			r.URL.RawQuery = ""
+			req = r
+		}
+		h.ServeHTTP(w, req)
+	})
+}
 
 func handleAssetPaths() {
 	for _, prefix := range strings.Split(*assetPaths, ",") {
 		urlPrefix := fmt.Sprintf("/%s/", prefix)
 		localDir := fmt.Sprintf("%s/%s", *assetRoot, prefix)
-		http.Handle(urlPrefix, http.StripPrefix(urlPrefix, http.FileServer(http.Dir(localDir))))
+		http.Handle(urlPrefix, assetHandler(urlPrefix, localDir))
 	}
 }
 
-type ReopenableDB struct {
-	dbPath string
-	db     *bbolt.DB
-	mu     sync.RWMutex
-}
-
-func (r *ReopenableDB) DB() *bbolt.DB {
-	if r.db == nil {
-		r.open()
+// assetHandler serves asset files under urlPrefix from localDir.
+func assetHandler(urlPrefix, localDir string) http.Handler {
+	var h http.Handler = http.FileServer(http.Dir(localDir))
+	if *precompressed {
+		h = precompressedAssets(h, localDir)
 	}
-	r.mu.RLock()
-	return r.db
+	return versionedAssets(http.StripPrefix(urlPrefix, h))
 }
 
-func (r *ReopenableDB) Release() {
-	r.mu.RUnlock()
+// isRoute reports whether a request path looks like a page route rather than
+// an asset, i.e. it has no file extension.
+func isRoute(p string) bool {
+	return path.Ext(p) == ""
 }
 
-func (r *ReopenableDB) Close() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if r.db == nil {
-		return
+// notModified reports whether a request's If-Modified-Since header shows the
+// client already has content last modified at t.
+func notModified(req *http.Request, t time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
 	}
-	r.db.Close()
-	r.db = nil
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	return err == nil && !t.After(ims)
 }
 
-func (r *ReopenableDB) open() {
-	db, err := bbolt.Open(r.dbPath, 0600, &bbolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+// redirectLocation validates a stored redirect target. Root-relative paths
+// (to this site) and absolute http(s) URLs (to anywhere) are served as they
+// are. Anything else is refused, in particular protocol-relative URLs like
+// "//evil.example", which browsers treat as off-site despite looking relative.
+func redirectLocation(loc string) (string, error) {
+	if strings.ContainsAny(loc, "\\\r\n\t") {
+		return "", fmt.Errorf("redirect %q contains invalid characters", loc)
+	}
+	u, err := url.Parse(loc)
 	if err != nil {
-		log.Printf("Error (re)opening database at %q: %v", r.dbPath, err)
-		return
+		return "", err
 	}
-
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	olddb := r.db
-	r.db = db
-	if olddb != nil {
-		olddb.Close()
+	switch {
+	case u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(loc, "//"):
+		return loc, nil
+	case (u.Scheme == "http" || u.Scheme == "https") && u.Host != "":
+		return loc, nil
 	}
+	return "", fmt.Errorf("redirect %q is neither root-relative nor an absolute web URL", loc)
 }
 
-type BBoltHandler struct {
-	db *ReopenableDB
+type StorageHandler struct {
+	db           storage.Storage
+	hits         *HitCounter          // Optional.
+	keyTransform crawler.KeyTransform // Optional.
+	headerRules  []HeaderRule         // Optional.
+	cache        *byteCache           // Encoded responses. Optional.
+	// URL attributes of custom elements to relativize, by tag name. Those of
+	// AMP elements by default.
+	elementURLAttrs map[string][]string
 }
 
-func NewBBoltHandler(dbPath string) *BBoltHandler {
-	return &BBoltHandler{
-		db: &ReopenableDB{dbPath: dbPath},
-	}
+func NewStorageHandler(db storage.Storage, hits *HitCounter, kt crawler.KeyTransform) *StorageHandler {
+	return &StorageHandler{db: db, hits: hits, keyTransform: kt, cache: newByteCache(*cacheMB << 20), elementURLAttrs: crawler.AMP_ELEMENT_URL_ATTRS}
 }
 
-func (b *BBoltHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+func (h *StorageHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Look up req.URL
 	path := req.URL.Path
 	switch path {
@@ -113,52 +192,283 @@ func (b *BBoltHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
 		w.Write([]byte("I am running.\r\nTODO: Put something useful here."))
 		return
+	case "/healthz":
+		h.serveHealth(w, req)
+		return
+	case "/topz":
+		if h.hits == nil {
+			// Not counting hits, so don't look for a stored page either.
+			http.NotFound(w, req)
+			return
+		}
+		h.hits.ServeTop(w, req)
+		return
 	case "/reloadz":
-		log.Printf("Reopening database at %q", b.db.dbPath)
-		b.db.open()
+		if r, ok := h.db.(storage.Reopener); ok {
+			log.Printf("Reopening database at %q", *dbPath)
+			if err := r.Reopen(); err != nil {
+				log.Printf("Error reopening database at %q: %v", *dbPath, err)
+			}
+		}
 		http.Redirect(w, req, "/", http.StatusFound)
 		return
 	}
 
-	var res = new(resource.Resource)
-	err := func() error {
-		// Get an RLocked handle on the database.
-		db := b.db.DB()
-		defer b.db.Release()
-		return db.View(func(tx *bbolt.Tx) error {
-			bkt := tx.Bucket([]byte(*dbBucket))
-			val := bkt.Get([]byte(path))
-			if val == nil {
-				log.Printf("Path %q not in db.\n", path)
-				w.WriteHeader(404)
-				return nil
-			}
-			return proto.Unmarshal(val, res)
-		})
-	}()
+	key := h.storageKey(*req.URL)
+	res, err := h.db.Read(key)
+	for _, alt := range directoryKeys(*req.URL) {
+		if !errors.Is(err, storage.ErrNotFound) {
+			break
+		}
+		key = h.storageKey(alt)
+		res, err = h.db.Read(key)
+	}
+	if errors.Is(err, storage.ErrNotFound) && *spaFallback != "" && isRoute(path) {
+		// Looks like a client-side route rather than a missing asset.
+		res, err = h.db.Read(*spaFallback)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Key %q not in db.\n", key)
+		w.WriteHeader(404)
+		return
+	}
 	if err != nil {
+		log.Printf("Error reading %q from db: %v\n", key, err)
 		w.WriteHeader(500)
 		return
 	}
-	if location := res.GetRedirect(); location != "" {
+	if h.hits != nil {
+		h.hits.Hit(key)
+	}
+	if *debugHeaders && res.GetSourceUrl() != "" {
+		w.Header().Set("X-Polyester-Source", res.GetSourceUrl())
+	}
+	if res.GetRedirect() != "" {
+		location, err := redirectLocation(res.GetRedirect())
+		if err != nil {
+			log.Printf("Bad redirect stored for %q: %v\n", key, err)
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("Location", location)
+		applyHeaderRules(w.Header(), h.headerRules, path)
 		w.WriteHeader(301)
-		w.Header().Add("Location", location)
 		return
 	}
 
 	w.Header().Set("Content-Type", res.GetContentType())
-	if i, err := w.Write(res.GetContent()); i != len(res.Content) || err != nil {
-		log.Printf("Error writing response: %d/%d bytes, %v", i, len(res.Content), err)
+	compressible := *compress && isCompressible(res.GetContentType())
+	// Before any 304 response, which should carry e.g. Cache-Control and
+	// Vary too.
+	if compressible {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	applyHeaderRules(w.Header(), h.headerRules, path)
+	if res.GetCrawledAt() != nil {
+		// HTTP dates have one second resolution.
+		modified := res.GetCrawledAt().AsTime().UTC().Truncate(time.Second)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		if notModified(req, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	content := res.GetContent()
+	// Identifies this content, as served to this request, for caching.
+	var ck *cacheKey
+	if res.GetCrawledAt() != nil {
+		ck = &cacheKey{key: key, crawledAt: res.GetCrawledAt().AsTime()}
+	}
+	if *relativize && strings.HasPrefix(res.GetContentType(), "text/html") {
+		domain := publishDomain(req.Host)
+		if ck != nil {
+			ck.variant = domain
+		}
+		if c, err := h.relativize(ck, content, res.GetSourceUrl(), domain); err != nil {
+			log.Printf("Could not relativize %q, serving as stored: %v\n", key, err)
+			ck = nil
+		} else {
+			content = c
+		}
+	}
+	if enc := preferredEncoding(req); compressible && enc != "" {
+		if c, err := h.encode(ck, content, enc); err != nil {
+			log.Printf("Could not encode %q as %s, serving uncompressed: %v\n", key, enc, err)
+		} else {
+			content = c
+			w.Header().Set("Content-Encoding", enc)
+		}
+	}
+	// The whole body is in memory, so avoid chunked encoding.
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	if i, err := w.Write(content); i != len(content) || err != nil {
+		log.Printf("Error writing response: %d/%d bytes, %v", i, len(content), err)
+	}
+}
+
+// relativize relativizes a page for a publish domain, or returns it from the
+// cache if it already was. If ck is nil, the page isn't cached.
+func (h *StorageHandler) relativize(ck *cacheKey, content []byte, sourceURL, domain string) ([]byte, error) {
+	if ck != nil {
+		if c, ok := h.cache.Get(*ck); ok {
+			return c, nil
+		}
+	}
+	c, err := crawler.RelativizePage(content, sourceURL, crawler.RelativizeOptions{
+		Aliases:           splitList(*localDomains),
+		PublishDomain:     domain,
+		KeepAbsoluteHosts: splitList(*keepAbsoluteHosts),
+		StripIntegrity:    *stripIntegrity,
+		RewriteCSS:        *rewriteCSS,
+		ElementURLAttrs:   h.elementURLAttrs,
+	})
+	if err == nil && ck != nil {
+		h.cache.Add(*ck, c)
+	}
+	return c, err
+}
+
+// encode compresses content, or returns it from the cache if it was already
+// compressed. If ck is nil, the content isn't cached.
+func (h *StorageHandler) encode(ck *cacheKey, content []byte, enc string) ([]byte, error) {
+	if ck == nil {
+		return encodeContent(content, enc)
+	}
+	k := *ck
+	k.variant += "\x00" + enc
+	if c, ok := h.cache.Get(k); ok {
+		return c, nil
+	}
+	c, err := encodeContent(content, enc)
+	if err == nil {
+		h.cache.Add(k, c)
+	}
+	return c, err
+}
+
+// publishDomain returns the domain to publish a page requested from host
+// under, given --publish_domains.
+func publishDomain(host string) string {
+	domains := splitList(*publishDomains)
+	if len(domains) == 0 {
+		return ""
+	}
+	if slices.Contains(domains, strings.ToLower(host)) {
+		return strings.ToLower(host)
 	}
+	return domains[0]
 }
 
-func (b *BBoltHandler) Close() {
-	b.db.Close()
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.ToLower(strings.TrimSpace(x)); x != "" {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+// storageKey returns the key content for a URL is stored under. Stored keys
+// include any (canonicalized) query string, e.g. for feeds.
+func (h *StorageHandler) storageKey(u url.URL) string {
+	key := crawler.CanonicalKey(u, !*noQuerySort)
+	if h.keyTransform != nil {
+		key = h.keyTransform(key)
+	}
+	return key
+}
+
+// directoryKeys returns other URLs whose content can be served for a URL
+// not stored as is, as a static host would: the directory page for a path
+// without a trailing slash (e.g. /blog/ for /blog), and the --index_document
+// of a directory (e.g. /blog/index.html for /blog/).
+func directoryKeys(u url.URL) []url.URL {
+	var alts []url.URL
+	dir := u
+	dir.RawPath = ""
+	if !strings.HasSuffix(u.Path, "/") {
+		if !isRoute(u.Path) {
+			return nil
+		}
+		dir.Path += "/"
+		alts = append(alts, dir)
+	}
+	if *indexDocument != "" {
+		index := dir
+		index.Path += *indexDocument
+		alts = append(alts, index)
+	}
+	return alts
+}
+
+// serveHealth reports whether the database is usable, with a 503 status if
+// not, or if it takes longer than --health_timeout to tell.
+func (h *StorageHandler) serveHealth(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	var err error
+	if p, ok := h.db.(storage.Pinger); ok {
+		ctx, cancel := context.WithTimeout(req.Context(), *healthTimeout)
+		err = p.Ping(ctx)
+		cancel()
+	}
+	if key := *healthKey; err == nil && key != "" {
+		// Stored like any other page.
+		if h.keyTransform != nil {
+			key = h.keyTransform(key)
+		}
+		if _, err = h.db.Read(key); err != nil {
+			err = fmt.Errorf("reading key %q: %w", key, err)
+		}
+	}
+	if err != nil {
+		log.Printf("Health check failed: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "Database unusable: %v\r\n", err)
+		return
+	}
+	w.Write([]byte("OK\r\n"))
+}
+
+func (h *StorageHandler) Close() {
+	h.db.Close()
+	if h.hits != nil {
+		h.hits.Close()
+	}
 }
 
 // handlePolyesterPaths adds handlers to serve content from a database.
-func handlePolyesterPaths(dbPath string) *BBoltHandler {
-	h := NewBBoltHandler(dbPath)
+func handlePolyesterPaths(dbPath string) *StorageHandler {
+	// Open read-only, so that a crawl can update the database while we serve it.
+	var hits *HitCounter
+	if *hitsDB != "" {
+		var err error
+		if hits, err = NewHitCounter(*hitsDB, 10*time.Second); err != nil {
+			log.Fatalf("Could not open hit counter database %q: %v", *hitsDB, err)
+		}
+	}
+	kt, err := crawler.ParseKeyTransform(*keyTransform)
+	if err != nil {
+		log.Fatalf("Bad --key_transform: %v", err)
+	}
+	db := storage.New(fmt.Sprintf("bbolt:%s:%s:ro", dbPath, *dbBucket))
+	if *keyNamespaces != "" {
+		ns, err := storage.ParseNamespaces(db, *keyNamespaces)
+		if err != nil {
+			log.Fatalf("Bad --key_namespaces: %v", err)
+		}
+		db = ns
+	}
+	h := NewStorageHandler(db, hits, kt)
+	if h.elementURLAttrs, err = crawler.ParseElementURLAttrs(*elementURLAttrs); err != nil {
+		log.Fatalf("Bad --element_url_attrs: %v", err)
+	}
+	if *headerRulesFile != "" {
+		if h.headerRules, err = LoadHeaderRules(*headerRulesFile); err != nil {
+			log.Fatalf("Could not load --header_rules %q: %v", *headerRulesFile, err)
+		}
+	}
 	http.Handle("/", http.StripPrefix("", h))
 	return h
 }
@@ -169,6 +479,7 @@ func main() {
 		log.Fatal("Must specify a content database to open with --db= flag.")
 	}
 	log.SetOutput(os.Stderr)
+	registerMimeTypes()
 	handleAssetPaths()
 
 	polyHandler := handlePolyesterPaths(*dbPath)
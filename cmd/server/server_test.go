@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMimeTypes(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"site.webmanifest", "app.mjs", "font.woff2"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registerMimeTypes()
+	h := assetHandler("/assets/", dir)
+	for f, want := range map[string]string{
+		"site.webmanifest": "application/manifest+json",
+		"app.mjs":          "text/javascript; charset=utf-8",
+		"font.woff2":       "font/woff2",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/assets/"+f, nil))
+		if got := w.Header().Get("Content-Type"); w.Code != 200 || got != want {
+			t.Errorf("GET %s = %d with Content-Type %q, want %q", f, w.Code, got, want)
+		}
+	}
+}
+
+func TestVersionedAssets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "style.css"), []byte("p{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := assetHandler("/wp-content/", dir)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/wp-content/style.css?ver=6.4", nil))
+	if w.Code != 200 || w.Body.String() != "p{}" {
+		t.Errorf("GET style.css?ver=6.4 = %d %q, want the file", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc == "" {
+		t.Errorf("Versioned asset served without Cache-Control")
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/wp-content/missing.css?ver=6.4", nil))
+	if w.Code != 404 {
+		t.Errorf("GET missing.css?ver=6.4 = %d, want 404", w.Code)
+	}
+}
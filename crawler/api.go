@@ -0,0 +1,134 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/TheSnook/polyester/site"
+)
+
+// Matches an absolute web URL within a JSON string, e.g. in rendered HTML.
+var jsonURLRE = regexp.MustCompile(`https?://[^\s"'<>()\\]+`)
+
+// linkNext returns the target of a `Link: <...>; rel="next"` response header,
+// as sent by paginated APIs, resolved against u.
+func linkNext(h http.Header, u url.URL) *url.URL {
+	for _, v := range h.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			target, params, _ := strings.Cut(link, ";")
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, p := range strings.Split(params, ";") {
+				name, rel, _ := strings.Cut(p, "=")
+				if strings.TrimSpace(name) != "rel" || !hasRel(strings.Trim(strings.TrimSpace(rel), `"`), "next") {
+					continue
+				}
+				ref, err := url.Parse(target[1 : len(target)-1])
+				if err != nil {
+					return nil
+				}
+				return u.ResolveReference(ref)
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteJSON rewrites local URLs in the string values of a JSON document,
+// including those embedded in e.g. rendered HTML, with feedURL. Keys are
+// re-encoded in sorted order.
+func (c *Crawler) rewriteJSON(body []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber() // Don't round large IDs.
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, x := range v {
+				v[k] = walk(x)
+			}
+		case []any:
+			for i, x := range v {
+				v[i] = walk(x)
+			}
+		case string:
+			return jsonURLRE.ReplaceAllStringFunc(v, c.feedURL)
+		}
+		return v
+	}
+	out := new(bytes.Buffer)
+	e := json.NewEncoder(out)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(walk(v)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+// CrawlAPIs fetches and stores the JSON API endpoints of a site config, and
+// the further pages of results each links to, resolving their paths against
+// u. Pages are fetched even if already seen, e.g. linked earlier in the
+// crawl, as their Link headers are needed to reach the next page. Returns
+// the number of pages fetched. Failures don't stop other APIs being fetched.
+func (c *Crawler) CrawlAPIs(ctx context.Context, u url.URL, apis []site.API) (int, error) {
+	fetched := 0
+	var errs []error
+	for _, api := range apis {
+		var rewrite func([]byte) ([]byte, error)
+		if api.Relativize {
+			rewrite = c.rewriteJSON
+		}
+		ref, err := url.Parse(api.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("API %q: %w", api.Path, err))
+			continue
+		}
+		next := u.ResolveReference(ref)
+		// Pages of this API fetched, in case the Link headers loop.
+		paged := map[string]bool{}
+		for pages := 0; next != nil && (api.MaxPages <= 0 || pages < api.MaxPages); pages++ {
+			if err := ctx.Err(); err != nil {
+				return fetched, errors.Join(append(errs, err)...)
+			}
+			if !c.isLocal(*next) {
+				log.Printf("Not following API pagination off-site to %q\n", next)
+				break
+			}
+			k := c.pageKey(*next)
+			if paged[k] {
+				log.Printf("API pagination loops back to %q\n", next)
+				break
+			}
+			paged[k] = true
+			if err := c.rateLimit(ctx, *next); err != nil {
+				return fetched, errors.Join(append(errs, err)...)
+			}
+			log.Printf("Fetching API page %q\n", next)
+			h, err := c.saveRaw(*next, rewrite)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("API %q: %w", api.Path, err))
+				break
+			}
+			if h == nil {
+				break
+			}
+			fetched++
+			next = linkNext(h, *next)
+		}
+	}
+	return fetched, errors.Join(errs...)
+}
@@ -0,0 +1,94 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/TheSnook/polyester/site"
+)
+
+func TestLinkNext(t *testing.T) {
+	u := mustParse(t, "https://example.com/wp-json/wp/v2/posts?page=2")
+	for _, tc := range []struct {
+		links []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{`<https://example.com/wp-json/wp/v2/posts?page=3>; rel="next"`}, "https://example.com/wp-json/wp/v2/posts?page=3"},
+		{[]string{`</wp-json/wp/v2/posts?page=1>; rel="prev", </wp-json/wp/v2/posts?page=3>; rel="next"`}, "https://example.com/wp-json/wp/v2/posts?page=3"},
+		{[]string{`<https://example.com/wp-json/>; rel="https://api.w.org/"`, `<?page=3>; rel=next`}, "https://example.com/wp-json/wp/v2/posts?page=3"},
+		{[]string{`</wp-json/wp/v2/posts?page=1>; rel="prev"`}, ""},
+	} {
+		got := ""
+		if n := linkNext(http.Header{"Link": tc.links}, u); n != nil {
+			got = n.String()
+		}
+		if got != tc.want {
+			t.Errorf("linkNext(%q) = %q, want %q", tc.links, got, tc.want)
+		}
+	}
+}
+
+func TestCrawlAPIs(t *testing.T) {
+	s := newTestSite(t, nil)
+	const last = 3
+	loop := false // Whether the last page links back to the second.
+	s.handle("/wp-json/wp/v2/posts", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		page = max(page, 1)
+		switch {
+		case page < last:
+			w.Header().Add("Link", fmt.Sprintf(`<%s/wp-json/wp/v2/posts?page=%d>; rel="next"`, s.URL, page+1))
+		case loop:
+			w.Header().Add("Link", fmt.Sprintf(`<%s/wp-json/wp/v2/posts?page=2>; rel="next"`, s.URL))
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		fmt.Fprintf(w, `[{"id":%d,"link":"%s/post-%d/","content":{"rendered":"<a href=\"%s/about/\">About</a>"}}]`, page, s.URL, page, s.URL)
+	})
+	api := site.API{Path: "/wp-json/wp/v2/posts", Relativize: true}
+	pages := []string{"/wp-json/wp/v2/posts", "/wp-json/wp/v2/posts?page=2", "/wp-json/wp/v2/posts?page=3"}
+
+	c, db := newTestCrawler(s)
+	// Seen earlier in the crawl, e.g. linked from a page.
+	c.markSeen(s.u("/wp-json/wp/v2/posts?page=2"))
+	n, err := c.CrawlAPIs(context.Background(), s.u("/"), []site.API{api})
+	if err != nil || n != last {
+		t.Errorf("CrawlAPIs() = %d, %v, want %d pages", n, err, last)
+	}
+	if got := storedKeys(t, db); !slices.Equal(got, pages) {
+		t.Errorf("Stored %q, want %q", got, pages)
+	}
+	r, err := db.Read("/wp-json/wp/v2/posts?page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(r.GetContent()), `[{"content":{"rendered":"<a href=\"/about/\">About</a>"},"id":2,"link":"/post-2/"}]`; got != want {
+		t.Errorf("Stored page 2 as %s, want %s", got, want)
+	}
+
+	// With a page limit.
+	api.MaxPages = 2
+	c, db = newTestCrawler(s)
+	if n, err := c.CrawlAPIs(context.Background(), s.u("/"), []site.API{api}); err != nil || n != 2 {
+		t.Errorf("CrawlAPIs() with MaxPages 2 = %d, %v, want 2 pages", n, err)
+	}
+	if got := storedKeys(t, db); !slices.Equal(got, pages[:2]) {
+		t.Errorf("Stored %q with MaxPages 2, want %q", got, pages[:2])
+	}
+
+	// Pagination which loops stops once every page is fetched.
+	api.MaxPages = 0
+	loop = true
+	c, _ = newTestCrawler(s)
+	before := s.total()
+	if n, err := c.CrawlAPIs(context.Background(), s.u("/"), []site.API{api}); err != nil || n != last {
+		t.Errorf("CrawlAPIs() with looping pagination = %d, %v, want %d pages", n, err, last)
+	}
+	if got := s.total() - before; got != last {
+		t.Errorf("Made %d requests with looping pagination, want %d", got, last)
+	}
+}
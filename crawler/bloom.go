@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// errBloomSeen is returned by operations which need to list the URLs seen,
+// which a bloom filter can't do.
+var errBloomSeen = errors.New("not supported with a bloom filter seen set")
+
+// bloomFilter is a set of strings in bounded memory, which may wrongly
+// report that a string is in the set, but never that it isn't.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64 // Number of bits.
+	k    uint64 // Number of hashes per string.
+}
+
+// newBloomFilter sizes a filter to hold n strings with a false positive rate
+// of about p.
+func newBloomFilter(n uint, p float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: max(k, 1)}
+}
+
+// hashes returns two independent hashes of s, combined to give k positions.
+func (f *bloomFilter) hashes(s string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(s))
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+func (f *bloomFilter) Add(s string) {
+	h1, h2 := f.hashes(s)
+	for i := uint64(0); i < f.k; i++ {
+		b := (h1 + i*h2) % f.m
+		f.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+func (f *bloomFilter) Has(s string) bool {
+	h1, h2 := f.hashes(s)
+	for i := uint64(0); i < f.k; i++ {
+		b := (h1 + i*h2) % f.m
+		if f.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// UseBloomFilter tracks URLs seen with a bloom filter sized for n URLs with
+// a false positive rate of about fpRate, instead of an exact set. Memory use
+// is then fixed, at the cost of wrongly skipping roughly that fraction of
+// URLs. SaveState and Prune need the exact set, so return an error. Must be
+// called before crawling.
+func (c *Crawler) UseBloomFilter(n uint, fpRate float64) {
+	c.muSeen.Lock()
+	defer c.muSeen.Unlock()
+	c.bloom = newBloomFilter(n, fpRate)
+	for k := range c.seen {
+		c.bloom.Add(k)
+	}
+	clear(c.seen)
+}
@@ -0,0 +1,97 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	f := newBloomFilter(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("/%d/post-%d/", 2000+i%20, i))
+	}
+	for i := 0; i < n; i++ {
+		if k := fmt.Sprintf("/%d/post-%d/", 2000+i%20, i); !f.Has(k) {
+			t.Fatalf("Has(%q) = false after Add", k)
+		}
+	}
+	fp := 0
+	for i := 0; i < n; i++ {
+		if f.Has(fmt.Sprintf("/page/%d/", i)) {
+			fp++
+		}
+	}
+	// Allow for chance, well above the 1% asked for.
+	if rate := float64(fp) / n; rate > 0.02 {
+		t.Errorf("False positive rate %.3f, want about 0.01", rate)
+	}
+}
+
+func TestBloomCrawl(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":   `<a href="/a/">A</a> <a href="/b/">B</a>`,
+		"/a/": `<a href="/">Home</a> <a href="/b/">B</a> <a href="/c/">C</a>`,
+		"/b/": `<a href="/a/">A</a> <a href="/c/">C</a>`,
+		"/c/": `<a href="/">Home</a> <a href="/a/">A</a>`,
+	})
+	c, db := newTestCrawler(site)
+	c.UseBloomFilter(1000, 0.001)
+	stats := c.CrawlP(context.Background(), site.u("/"), 100, 2)
+	if stats.Fetched != 4 || len(storedKeys(t, db)) != 4 {
+		t.Errorf("CrawlP() = %+v, want 4 pages fetched and stored", stats)
+	}
+	for _, p := range []string{"/", "/a/", "/b/", "/c/"} {
+		if n := site.fetches(p); n != 1 {
+			t.Errorf("Fetched %s %d times, want once", p, n)
+		}
+	}
+	if err := c.SaveState(io.Discard); !errors.Is(err, errBloomSeen) {
+		t.Errorf("SaveState() with a bloom filter = %v, want errBloomSeen", err)
+	}
+}
+
+// BenchmarkSeenSet compares the memory used to track a million URLs.
+func BenchmarkSeenSet(b *testing.B) {
+	const n = 1000000
+	urls := make([]url.URL, n)
+	for i := range urls {
+		urls[i] = url.URL{Scheme: "https", Host: "example.com", Path: fmt.Sprintf("/%d/%02d/post-number-%d/", 2000+i%25, i%12+1, i)}
+	}
+	heap := func() uint64 {
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	for _, bc := range []struct {
+		name string
+		c    func() *Crawler
+	}{
+		{"map", func() *Crawler { return New("example.com", nil, nil) }},
+		{"bloom", func() *Crawler {
+			c := New("example.com", nil, nil)
+			c.UseBloomFilter(n, 0.001)
+			return c
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var used uint64
+			for i := 0; i < b.N; i++ {
+				before := heap()
+				c := bc.c()
+				for _, u := range urls {
+					c.markSeen(u)
+				}
+				used = heap() - before
+				runtime.KeepAlive(c)
+			}
+			b.ReportMetric(float64(used)/n, "B/url")
+		})
+	}
+}
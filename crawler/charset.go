@@ -0,0 +1,86 @@
+package crawler
+
+import (
+	"bytes"
+	"log"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// decodeHTML converts an HTML body to UTF-8, according to the charset given
+// in its Content-Type header, a byte order mark or a <meta> tag. Undeclared
+// bodies are only converted if they aren't valid UTF-8, since the guess made
+// from their first KB is often wrong. It reports whether the body was
+// converted.
+func decodeHTML(body []byte, contentType string) ([]byte, bool) {
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if name == "utf-8" {
+		return body, false
+	}
+	if !certain && metaCharset(body) == "" && utf8.Valid(body) {
+		return body, false
+	}
+	out, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		log.Printf("  Could not decode HTML from %q: %v", name, err)
+		return body, false
+	}
+	// A byte order mark is decoded as a zero width no-break space.
+	return bytes.TrimPrefix(out, []byte("\ufeff")), true
+}
+
+// metaCharset returns the charset declared by a <meta> tag in the first KB of
+// an HTML body, where browsers look for it, if any.
+func metaCharset(body []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(body[:min(len(body), 1024)]))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if t.DataAtom != atom.Meta {
+				continue
+			}
+			var httpEquiv, content string
+			for _, a := range t.Attr {
+				switch strings.ToLower(a.Key) {
+				case "charset":
+					return a.Val
+				case "http-equiv":
+					httpEquiv = a.Val
+				case "content":
+					content = a.Val
+				}
+			}
+			if strings.EqualFold(httpEquiv, "content-type") {
+				if _, params, err := mime.ParseMediaType(content); err == nil && params["charset"] != "" {
+					return params["charset"]
+				}
+			}
+		}
+	}
+}
+
+// setUTF8Charset rewrites any charset declared by <meta> tags in a document
+// to UTF-8, the encoding it is rendered in.
+func setUTF8Charset(doc *html.Node) {
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode || n.DataAtom != atom.Meta {
+			continue
+		}
+		if a := getAttr(n, "charset"); a != nil {
+			a.Val = "utf-8"
+		}
+		if h := getAttr(n, "http-equiv"); h != nil && strings.EqualFold(h.Val, "content-type") {
+			if a := getAttr(n, "content"); a != nil {
+				a.Val = "text/html; charset=utf-8"
+			}
+		}
+	}
+}
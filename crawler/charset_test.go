@@ -0,0 +1,56 @@
+package crawler
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHTML(t *testing.T) {
+	// Enough ASCII that any non-ASCII bytes fall outside the first KB, from
+	// which a charset would be guessed.
+	pad := "<!-- " + strings.Repeat("x", 1100) + " -->"
+	for _, tc := range []struct {
+		name, contentType, body, want string
+		converted                     bool
+	}{
+		{"header", "text/html; charset=ISO-8859-1", "<p>caf\xe9</p>", "<p>café</p>", true},
+		{"meta charset", "text/html", `<meta charset="iso-8859-1"><p>caf` + "\xe9</p>", `<meta charset="iso-8859-1"><p>café</p>`, true},
+		{"meta http-equiv", "text/html", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1252"><p>` + "\x93Hi\x94</p>", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1252"><p>“Hi”</p>`, true},
+		{"BOM", "text/html", "\xfe\xff\x00<\x00p\x00>\x00\xe9", "<p>é", true},
+		{"declared UTF-8", "text/html; charset=utf-8", "<p>café</p>", "<p>café</p>", false},
+		{"undeclared UTF-8", "text/html", pad + "<p>café</p>", pad + "<p>café</p>", false},
+		{"undeclared Latin-1", "text/html", pad + "<p>caf\xe9</p>", pad + "<p>café</p>", true},
+	} {
+		got, converted := decodeHTML([]byte(tc.body), tc.contentType)
+		if string(got) != tc.want || converted != tc.converted {
+			t.Errorf("%s: decodeHTML() = %.60q, %v, want %.60q, %v", tc.name, got, converted, tc.want, tc.converted)
+		}
+	}
+}
+
+func TestLatin1Page(t *testing.T) {
+	site := newTestSite(t, nil)
+	site.file("/", "text/html; charset=ISO-8859-1",
+		"<html><head><meta http-equiv=\"Content-Type\" content=\"text/html; charset=ISO-8859-1\"></head>"+
+			"<body><p>Cr\xe8me br\xfbl\xe9e, \xa3\xbd, na\xefve</p></body></html>")
+	c, db := newTestCrawler(site)
+	if err := c.CrawlOne(context.Background(), site.u("/")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Read("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("Stored content type %q, want UTF-8", r.ContentType)
+	}
+	for _, s := range []string{
+		"<p>Crème brûlée, £½, naïve</p>",
+		`<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>`,
+	} {
+		if !strings.Contains(string(r.Content), s) {
+			t.Errorf("Stored page lacks %s:\n%s", s, r.Content)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package crawler
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// inferContentType returns the content type of a response, falling back to
+// one based on the URL's file extension if the origin sent none, or only a
+// generic binary type.
+func inferContentType(contentType string, u url.URL) string {
+	switch t, _, _ := strings.Cut(contentType, ";"); strings.ToLower(strings.TrimSpace(t)) {
+	case "", "application/octet-stream", "binary/octet-stream":
+		if ext := mime.TypeByExtension(path.Ext(u.Path)); ext != "" {
+			return ext
+		}
+	}
+	return contentType
+}
+
+// matchesContentType reports whether a Content-Type header value matches any
+// of the given patterns. Patterns are media types (e.g. "application/rss+xml")
+// or type wildcards (e.g. "image/*"). Parameters such as charset are ignored.
+func matchesContentType(contentType string, patterns []string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == t || p == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok && strings.HasPrefix(t, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// storesContentType reports whether raw content of a given type should be
+// stored, according to the crawler's allow and deny lists. Deny wins.
+func (c *Crawler) storesContentType(contentType string) bool {
+	if matchesContentType(contentType, c.DenyContentTypes) {
+		return false
+	}
+	return len(c.AllowContentTypes) == 0 || matchesContentType(contentType, c.AllowContentTypes)
+}
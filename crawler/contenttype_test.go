@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestMatchesContentType(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		patterns    []string
+		want        bool
+	}{
+		{"application/rss+xml; charset=UTF-8", []string{"application/rss+xml"}, true},
+		{"Image/PNG", []string{"image/*"}, true},
+		{"image/png", []string{"application/json", "text/*"}, false},
+		{"text/css", []string{"*/*"}, true},
+		{"text/css", nil, false},
+	} {
+		if got := matchesContentType(tc.contentType, tc.patterns); got != tc.want {
+			t.Errorf("matchesContentType(%q, %q) = %v, want %v", tc.contentType, tc.patterns, got, tc.want)
+		}
+	}
+}
+
+func TestInferContentType(t *testing.T) {
+	for _, tc := range []struct {
+		contentType, path, want string
+	}{
+		{"application/octet-stream", "/style.css", "text/css; charset=utf-8"},
+		{"Binary/Octet-Stream", "/app.js", "text/javascript; charset=utf-8"},
+		{"", "/logo.svg", "image/svg+xml"},
+		{"application/octet-stream", "/file", "application/octet-stream"},
+		{"application/octet-stream", "/archive.unknownext", "application/octet-stream"},
+		{"text/plain", "/style.css", "text/plain"},
+	} {
+		u := url.URL{Scheme: "https", Host: "example.com", Path: tc.path}
+		if got := inferContentType(tc.contentType, u); got != tc.want {
+			t.Errorf("inferContentType(%q, %q) = %q, want %q", tc.contentType, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestCSSServedAsOctetStream(t *testing.T) {
+	site := newTestSite(t, nil)
+	site.file("/style.css", "application/octet-stream", "body { color: red }")
+	c, db := newTestCrawler(site)
+	if err := c.CrawlOne(context.Background(), site.u("/style.css")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Read("/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ContentType != "text/css; charset=utf-8" || string(r.Content) != "body { color: red }" {
+		t.Errorf("Stored %q: %q, want CSS", r.ContentType, r.Content)
+	}
+}
+
+func TestAllowDenyContentTypes(t *testing.T) {
+	site := newTestSite(t, nil)
+	site.file("/feed/", "application/rss+xml; charset=UTF-8", "<rss></rss>")
+	site.file("/photo.png", "image/png", "PNG")
+	site.file("/data.json", "application/json", "{}")
+	c, db := newTestCrawler(site)
+	c.AllowContentTypes = []string{"application/rss+xml", "image/*"}
+	c.DenyContentTypes = []string{"image/*"}
+	for _, p := range []string{"/feed/", "/photo.png", "/data.json"} {
+		c.CrawlOne(context.Background(), site.u(p))
+	}
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/feed/"}) {
+		t.Errorf("Stored %q, want only the allowed feed", keys)
+	}
+}
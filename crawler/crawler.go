@@ -2,27 +2,36 @@ package crawler
 
 import (
 	"bytes"
-	"crypto/tls"
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"net/url"
-	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/TheSnook/polyester/proto/resource"
-	"github.com/TheSnook/polyester/site"
 	"github.com/TheSnook/polyester/storage"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// Default max length of a redirect chain followed when saving raw content.
 const MAX_REDIRECTS = 10
 
+// Raw content at least this large is streamed to storage back-ends which
+// support it, rather than held in memory.
+const STREAM_MIN_SIZE = 1 << 20
+
 // If strings appear in script bodies, they get any `https:\/\/{HOSTNAME}` prefix stripped by plain-text substitution.
 var STATIC_REPLACEMENTS = []string{
 	// concatemoji
@@ -31,6 +40,55 @@ var STATIC_REPLACEMENTS = []string{
 	`\/wp-content\/plugins\/jetpack\/_inc\/build\/carousel\/swiper-bundle.min.js`,
 }
 
+// Query parameters which only track the source of a visit and don't affect
+// page content. These are dropped from canonical URLs.
+var TRACKING_PARAMS = []string{
+	"fbclid",
+	"gclid",
+	"mc_cid",
+	"mc_eid",
+	"utm_campaign",
+	"utm_content",
+	"utm_medium",
+	"utm_source",
+	"utm_term",
+}
+
+// URL attributes of AMP elements, by tag name, for Crawler.ElementURLAttrs.
+var AMP_ELEMENT_URL_ATTRS = map[string][]string{
+	"amp-anim":  {"src", "srcset"},
+	"amp-audio": {"src"},
+	"amp-img":   {"src", "srcset"},
+	"amp-video": {"src", "poster"},
+}
+
+// ParseElementURLAttrs parses comma-separated tag:attribute pairs, e.g.
+// "my-player:url,my-player:poster", into ElementURLAttrs, along with those
+// of AMP_ELEMENT_URL_ATTRS.
+func ParseElementURLAttrs(spec string) (map[string][]string, error) {
+	attrs := maps.Clone(AMP_ELEMENT_URL_ATTRS)
+	if spec == "" {
+		return attrs, nil
+	}
+	for _, p := range strings.Split(spec, ",") {
+		tag, attr, ok := strings.Cut(strings.TrimSpace(p), ":")
+		if !ok || tag == "" || attr == "" {
+			return nil, fmt.Errorf("bad entry %q: want tag:attribute", p)
+		}
+		tag = strings.ToLower(tag)
+		attrs[tag] = append(attrs[tag], strings.ToLower(attr))
+	}
+	return attrs, nil
+}
+
+// FormAction says how the actions of local <form>s are rewritten.
+type FormAction int
+
+const (
+	DefangForms     FormAction = iota // Replace local form actions with "#".
+	RelativizeForms                   // Relativize local form actions, like links.
+)
+
 // TODO: Break up this class. The Crawler, a Crawl, and the resource processing should be separated.
 type Crawler struct {
 	db         storage.Storage
@@ -38,26 +96,227 @@ type Crawler struct {
 	origin     string
 	aliases    []string
 	seen       map[string]struct{}
+	bloom      *bloomFilter // Replaces seen, if set. Guarded by muSeen.
+	unvisited  []string     // Keys found but not fetched by the last crawl. Guarded by muSeen.
 	muSeen     sync.Mutex
+	errs       errorCollector  // Errors seen during the current crawl.
+	progress   progressTracker // Counts for Progress, under their own lock.
+
+	// Set when MaxIdleConnsPerHost was chosen with TuneTransport.
+	idleConnsTuned bool
+
+	// Set by RelativizePage, where logging every link would be noise.
+	quiet bool
+
+	// Optional settings. Change these before starting a crawl.
+
+	// MaxPagesPerHost caps the number of URLs fetched from any single host
+	// during a crawl, independently of the overall fetch limit.
+	// Zero means no per-host limit.
+	MaxPagesPerHost int
+
+	// Soft404, if set, identifies "not found" pages served with a 200 status.
+	// These are neither stored nor followed.
+	Soft404 *Soft404Detector
+
+	// Validator, if set, rejects pages which don't look like real content,
+	// e.g. error pages served with a 200 status. These are neither stored,
+	// so any copy stored earlier is kept, nor followed.
+	Validator *PageValidator
+
+	// OneHopHosts lists external hosts whose pages are fetched and stored
+	// (under EXTERNAL_KEY_PREFIX) when linked from a local page, but whose own
+	// links are not followed.
+	OneHopHosts []string
+
+	// PublishDomain, if set, replaces the origin domain in local URLs which
+	// must stay absolute, e.g. canonical links and JSON-LD.
+	PublishDomain string
+
+	// BasePath, if set, is the path the mirror is published under, e.g.
+	// "/mirror", with a leading and no trailing slash. It prefixes the paths
+	// of rewritten local URLs, so "/page" becomes "/mirror/page", and of the
+	// targets of stored redirects. Storage keys are unchanged.
+	BasePath string
+
+	// ElementURLAttrs lists attributes holding URLs to relativize, by tag
+	// name, for elements the crawler doesn't otherwise know, e.g. AMP and
+	// web components. See AMP_ELEMENT_URL_ATTRS.
+	ElementURLAttrs map[string][]string
+
+	// DeferRelativize leaves local URLs in stored HTML absolute, for a server
+	// to relativize with RelativizePage when serving, e.g. to publish the
+	// same content under several domains. PublishDomain is then applied by
+	// the server too.
+	DeferRelativize bool
+
+	// Content types of raw (non-HTML) content to store, e.g. "application/json"
+	// or "image/*". If AllowContentTypes is empty, all types not denied are stored.
+	AllowContentTypes []string
+	DenyContentTypes  []string
+
+	// NodeRewriters apply custom statication rules to every HTML node.
+	NodeRewriters []NodeRewriter
+
+	// ExtractEmbeddedLinks enables crawling of local URLs found in JSON
+	// <script> payloads and CSS @import rules.
+	ExtractEmbeddedLinks bool
+
+	// RespectRobotsTag honors X-Robots-Tag response headers: pages marked
+	// noindex are not stored, and links in pages marked nofollow are not
+	// followed.
+	RespectRobotsTag bool
+
+	// StoreOriginal also stores the HTML of each page as fetched, before
+	// statication, under ORIGINAL_KEY_PREFIX, e.g. for debugging. Pages
+	// larger than MaxOriginalSize bytes are skipped, if it is set.
+	StoreOriginal   bool
+	MaxOriginalSize int
+
+	// RewriteCSS relativizes absolute local URLs in stylesheets, <style>
+	// elements and style attributes, including image-set() candidates.
+	RewriteCSS bool
+
+	// MaxAssetAge, if set, skips fetching assets (non-HTML content) already
+	// stored less than this long ago, going by their CrawledAt. HTML pages
+	// are always fetched.
+	MaxAssetAge time.Duration
+
+	// Minify collapses insignificant whitespace in stored HTML. If
+	// StripComments is also set, comments are removed.
+	Minify        bool
+	StripComments bool
+
+	// ExcludeSelectors match elements which are removed, along with their
+	// contents, from stored pages. E.g. comment forms and live search widgets.
+	ExcludeSelectors []Selector
+
+	// MaxRedirects caps the length of redirect chains followed, both when
+	// saving raw content and by CrawlP. 0 for the default, MAX_REDIRECTS.
+	MaxRedirects int
+
+	// Seeds are queued by CrawlP along with its start URL, e.g. from a
+	// sitemap. Higher priority seeds are fetched first, and links found
+	// while crawling have priority 0.
+	Seeds []Seed
+
+	// KeyTransform, if set, maps the root-relative key of each page (e.g.
+	// "/about/") to the key it is stored under, e.g. to suit a hosting
+	// platform. Servers must apply the same transform to look pages up.
+	KeyTransform KeyTransform
+
+	// Manifest, if set, records a hash of each resource written, and
+	// resources whose hash is unchanged are not written again. Streamed
+	// content is always written.
+	Manifest *ContentManifest
+
+	// PaginationSelectors match elements which link to the next page of a
+	// listing, for CrawlPages, where there is no rel=next link.
+	PaginationSelectors []Selector
+
+	// FormActions says how local <form> actions are rewritten. Off-site
+	// actions are left intact.
+	FormActions FormAction
+
+	// NoQuerySort preserves the order of query parameters in seen and storage
+	// keys, for origins where it matters. By default they are sorted, so that
+	// reordered URLs are only fetched once.
+	NoQuerySort bool
+
+	// CapturePreloads fetches and stores local assets named by <link>
+	// preload, prefetch and modulepreload hints.
+	CapturePreloads bool
+
+	// StripIntegrity removes integrity (and crossorigin) attributes from
+	// <link> and <script> elements pointing at the mirror.
+	StripIntegrity bool
+
+	// CaptureAMP crawls the AMP variants of pages, linked by
+	// <link rel="amphtml">, and points those links at the mirrored copies.
+	CaptureAMP bool
+
+	// CaptureIcons fetches the well-known favicon and Apple touch icon of
+	// the start host, icons and manifests named by <link> tags, and the
+	// icons listed in web app manifests.
+	CaptureIcons bool
+
+	// MaxRPS caps the rate of requests across the whole crawl, regardless of
+	// host or parallelism. 0 for no limit.
+	MaxRPS      float64
+	limiter     *rate.Limiter
+	limiterOnce sync.Once
+
+	// CrawlDelay spaces out requests to each host. CrawlDelayJitter varies
+	// each delay randomly by up to that fraction (e.g. 0.2 for ±20%), so that
+	// requests to different hosts don't fall into step, but never below a
+	// robots.txt Crawl-delay.
+	CrawlDelay       time.Duration
+	CrawlDelayJitter float64
+	hostLimiters     map[string]*rateLimiter
+	muLimiters       sync.Mutex
+
+	// RespectCrawlDelay also honors the Crawl-delay in each host's
+	// robots.txt, for ROBOTS_USER_AGENT or else all agents, where it is
+	// longer than CrawlDelay.
+	RespectCrawlDelay bool
+	robotsDelays      map[string]*robotsDelay // Guarded by muLimiters.
+
+	// AllowInsecureLocal skips TLS certificate verification for hosts which
+	// resolve to private or loopback addresses. Public hosts are always verified.
+	AllowInsecureLocal bool
+
+	// MaxConnsPerHost caps the number of requests in flight to any one host,
+	// independently of the overall parallelism. 0 for no limit.
+	MaxConnsPerHost int
+	hostSems        map[string]chan struct{} // Guarded by muLimiters.
+	hostBackoff     map[string]time.Time     // No requests to a host before its time. Guarded by muLimiters.
+
+	// CanonicalHost, if set, replaces the host of local URLs, including those
+	// on alias domains, so each path is fetched and stored only once.
+	CanonicalHost string
+
+	// KeepAbsoluteHosts are hosts which are never treated as local, even if
+	// they match the origin or an alias, so links to them stay fully
+	// qualified and aren't crawled. E.g. an app subdomain which isn't mirrored.
+	KeepAbsoluteHosts []string
+
+	// Metadata lists values (e.g. the title) to extract from every page and
+	// store alongside it, for building indexes. See MetadataRules.
+	Metadata []MetadataRule
+
+	// MaxQueue caps the number of URLs waiting to be fetched which are held
+	// in memory. Any more are spilled to a temporary file, trading disk I/O
+	// for bounded memory on sites with very many links. 0 for no limit.
+	MaxQueue int
 }
 
 func noRedirects(req *http.Request, via []*http.Request) error {
 	return http.ErrUseLastResponse
 }
 
-func New(origin string, aliases []string, db storage.Storage) Crawler {
-	return Crawler{
-		db: db,
-		httpClient: &http.Client{
-			CheckRedirect: noRedirects,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // FIXME
-			},
-		},
+func New(origin string, aliases []string, db storage.Storage) *Crawler {
+	c := &Crawler{
+		db:      db,
 		origin:  origin,
 		aliases: aliases,
 		seen:    map[string]struct{}{},
 	}
+	c.httpClient = &http.Client{
+		CheckRedirect: noRedirects,
+		Transport: &http.Transport{
+			DialTLSContext:  c.dialTLS,
+			MaxIdleConns:    DEFAULT_MAX_IDLE_CONNS,
+			IdleConnTimeout: DEFAULT_IDLE_CONN_TIMEOUT,
+		},
+	}
+	return c
+}
+
+// SetTransport replaces the transport used for all requests, e.g. with a
+// mock which returns canned responses in tests. Redirects are still returned
+// to the crawler rather than followed.
+func (c *Crawler) SetTransport(t http.RoundTripper) {
+	c.httpClient.Transport = t
 }
 
 // getURLAttr finds a named attribute of an HTML node and returns a reference to it.
@@ -84,43 +343,223 @@ func getURLAttr(n *html.Node, name string) (*html.Attribute, *url.URL) {
 	return a, u
 }
 
+// isSamePageRef reports whether an href refers to the page it is on without
+// reloading it, i.e. it is empty or only a fragment, e.g. "#section".
+func isSamePageRef(href string) bool {
+	href = strings.TrimSpace(href)
+	return href == "" || strings.HasPrefix(href, "#")
+}
+
 // relativize turns an fully-qualified URL into a relative URL.
 func relativize(u *url.URL) {
 	u.Scheme = ""
 	u.Host = ""
 }
 
+// relativizeLocal relativizes a local URL in a page being staticated, under
+// any BasePath, unless that is deferred to serving.
+func (c *Crawler) relativizeLocal(u *url.URL) {
+	if !c.DeferRelativize {
+		relativize(u)
+		c.addBasePath(u)
+	}
+}
+
+// needsBasePath reports whether a URL is root-relative, e.g. "/page", and a
+// BasePath is set, so that it must be rewritten though already relative.
+func (c *Crawler) needsBasePath(u *url.URL) bool {
+	return c.BasePath != "" && u.Host == "" && u.Scheme == "" && strings.HasPrefix(u.Path, "/")
+}
+
+// addBasePath prefixes the path of a root-relative or absolute local URL with
+// BasePath, e.g. "/page" becomes "/mirror/page".
+func (c *Crawler) addBasePath(u *url.URL) {
+	if c.BasePath == "" || !strings.HasPrefix(u.Path, "/") {
+		return
+	}
+	u.Path = c.BasePath + u.Path
+	if u.RawPath != "" {
+		u.RawPath = c.BasePath + u.RawPath
+	}
+}
+
+// redirectLocation returns the location stored for a redirect to a local
+// URL: the key its target will be stored under, e.g. "/" for a bare origin
+// URL, under any BasePath.
+func (c *Crawler) redirectLocation(target url.URL) string {
+	return c.BasePath + c.pageKey(target)
+}
+
+// logLink logs a decision about a link in a page being staticated.
+func (c *Crawler) logLink(format string, args ...any) {
+	if !c.quiet {
+		log.Printf(format, args...)
+	}
+}
+
 // rootRelativeURL returns a root-relative URL string based on the passed URL
 func rootRelativeURL(u url.URL) string {
 	relativize(&u)
 	return u.String()
 }
 
-// sortQueryValues sorts the values of all multi-valued query parameters.
-func sortQueryValues(u *url.URL) {
-	q := u.Query()
-	for k, v := range q {
-		sort.Strings(v)
-		q[k] = v
+// withoutFragment returns a URL without any fragment, which names a place in
+// a page rather than a different page.
+func withoutFragment(u url.URL) url.URL {
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u
+}
+
+// canonicalURL returns the canonical form of a URL. Every URL should pass
+// through here before it is used as a seen-set or storage key, so that
+// trivially different spellings of a URL are only fetched and stored once.
+//   - An empty path becomes "/".
+//   - Any fragment is removed.
+//   - Tracking parameters are removed.
+//   - If `sortQuery` is set, query parameters are sorted by key, and
+//     multi-valued parameters by value. Otherwise their order is preserved.
+//   - The host is lower-cased and any default port is removed.
+func canonicalURL(u url.URL, sortQuery bool) url.URL {
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u = withoutFragment(u)
+
+	if sortQuery {
+		q := u.Query()
+		for _, p := range TRACKING_PARAMS {
+			q.Del(p)
+		}
+		for _, v := range q {
+			sort.Strings(v)
+		}
+		// url.Values.Encode() outputs querystrings in key-sorted order.
+		u.RawQuery = q.Encode()
+	} else {
+		u.RawQuery = stripQueryParams(u.RawQuery, TRACKING_PARAMS)
+	}
+	u.ForceQuery = false
+
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	return u
+}
+
+// stripQueryParams removes the named parameters from a raw query string,
+// leaving the rest in their original order.
+func stripQueryParams(rawQuery string, names []string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	parts := strings.Split(rawQuery, "&")
+	kept := parts[:0]
+	for _, p := range parts {
+		k, _, _ := strings.Cut(p, "=")
+		if uk, err := url.QueryUnescape(k); err == nil {
+			k = uk
+		}
+		if p != "" && !slices.Contains(names, k) {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// canonicalize returns the canonical form of a URL, according to the
+// crawler's settings.
+func (c *Crawler) canonicalize(u url.URL) url.URL {
+	u = canonicalURL(u, !c.NoQuerySort)
+	if c.CanonicalHost != "" && u.Host != "" && c.isLocal(u) {
+		// Fetch each local path once, whichever alias it was linked from.
+		u.Host = c.CanonicalHost
+	}
+	return u
+}
+
+// CanonicalKey returns the key under which content for a local URL is stored,
+// including its canonicalized query string. Servers should use this to look
+// up stored content for a request URL, with `sortQuery` matching the
+// crawler's NoQuerySort setting.
+func CanonicalKey(u url.URL, sortQuery bool) string {
+	return rootRelativeURL(canonicalURL(u, sortQuery))
+}
+
+// Prefix of the storage keys of pages fetched from one-hop external hosts.
+const EXTERNAL_KEY_PREFIX = "/_external/"
+
+// Prefix of the storage keys of original, unstaticated HTML pages, stored with
+// StoreOriginal.
+const ORIGINAL_KEY_PREFIX = "/__orig"
+
+// storageKey returns the key under which a URL is stored: its pageKey, passed
+// through any KeyTransform.
+func (c *Crawler) storageKey(u url.URL) string {
+	return c.transformKey(c.pageKey(u))
+}
+
+// transformKey applies any KeyTransform to a page key.
+func (c *Crawler) transformKey(k string) string {
+	if c.KeyTransform == nil {
+		return k
 	}
-	u.RawQuery = q.Encode()
+	return c.KeyTransform(k)
+}
+
+// pageKey returns the root-relative key under which a URL is tracked as seen,
+// which is also the path it is served at. Non-local URLs are namespaced under
+// their host.
+func (c *Crawler) pageKey(u url.URL) string {
+	u = c.canonicalize(u)
+	if !c.isLocal(u) {
+		return EXTERNAL_KEY_PREFIX + u.Host + rootRelativeURL(u)
+	}
+	return rootRelativeURL(u)
 }
 
 func (c *Crawler) isLocal(u url.URL) bool {
-	return u.Hostname() == "" || strings.TrimPrefix(u.Hostname(), "www.") == strings.TrimPrefix(c.origin, "www.")
+	if slices.Contains(c.KeepAbsoluteHosts, strings.ToLower(u.Hostname())) {
+		return false
+	}
+	h := strings.TrimPrefix(u.Hostname(), "www.")
+	if h == "" || h == strings.TrimPrefix(c.origin, "www.") {
+		return true
+	}
+	for _, a := range c.aliases {
+		// Aliases may include a port.
+		if a := (&url.URL{Host: a}).Hostname(); a != "" && h == strings.TrimPrefix(a, "www.") {
+			return true
+		}
+	}
+	return false
+}
+
+// isOneHop reports whether a URL is on an external host whose linked pages
+// should be fetched, without following any further links.
+func (c *Crawler) isOneHop(u url.URL) bool {
+	return slices.Contains(c.OneHopHosts, strings.ToLower(u.Hostname()))
 }
 
 func (c *Crawler) isSeen(u url.URL) bool {
 	c.muSeen.Lock()
 	defer c.muSeen.Unlock()
-	_, ok := c.seen[u.String()]
+	if c.bloom != nil {
+		return c.bloom.Has(c.pageKey(u))
+	}
+	_, ok := c.seen[c.pageKey(u)]
 	return ok
 }
 
 func (c *Crawler) markSeen(u url.URL) {
 	c.muSeen.Lock()
 	defer c.muSeen.Unlock()
-	c.seen[u.String()] = struct{}{}
+	if c.bloom != nil {
+		c.bloom.Add(c.pageKey(u))
+		return
+	}
+	c.seen[c.pageKey(u)] = struct{}{}
 }
 
 func isDynamicPage(u *url.URL) bool {
@@ -131,6 +570,11 @@ func isDynamicPage(u *url.URL) bool {
 	return !strings.Contains(parts[len(parts)-1], ".")
 }
 
+// isWebScheme reports whether a URL is relative or has an http(s) scheme.
+func isWebScheme(u *url.URL) bool {
+	return u.Scheme == "" || u.Scheme == "http" || u.Scheme == "https"
+}
+
 func isHTMLContentType(s string) bool {
 	t, _, _ := strings.Cut(s, ";")
 	return s == "" || t == "text/html"
@@ -146,109 +590,203 @@ func isHTMLContentType(s string) bool {
 //   - Detect and save any dynamically-generated non-HTML where possible
 //   - Limit returned links to defined sub-page patterns
 func (c *Crawler) staticateDoc(root *html.Node, origin string) []url.URL {
-	links := []url.URL{}
-	links = append(links, c.staticateNode(root, origin)...)
-	for x := range root.Descendants() {
-		links = append(links, c.staticateNode(x, origin)...)
+	links := make([]url.URL, 0, 64)
+	rc := RewriteContext{Origin: origin, Aliases: c.aliases, c: c}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		links = c.staticateNode(n, origin, links)
+		for _, r := range c.NodeRewriters {
+			links = append(links, r.Rewrite(n, rc)...)
+		}
+		if c.StripIntegrity && n.Type == html.ElementNode {
+			c.stripIntegrity(n)
+		}
+		for x := n.FirstChild; x != nil; {
+			next := x.NextSibling
+			if c.excluded(x) {
+				n.RemoveChild(x)
+			} else {
+				walk(x)
+			}
+			x = next
+		}
 	}
+	walk(root)
 	return links
 }
 
-// staticateDoc recursively parses an HTML document, excracting links to regular
-func (c *Crawler) staticateNode(n *html.Node, origin string) []url.URL {
-	links := []url.URL{}
+// staticateNoscript staticates the content of a <noscript> element. With
+// scripting enabled, as in a browser, the parser leaves this as raw text, so
+// it is re-parsed as HTML, staticated, and put back. This matters for e.g.
+// lazy-loaded images, whose real <img> is in a <noscript> fallback.
+func (c *Crawler) staticateNoscript(n *html.Node, origin string, links []url.URL) []url.URL {
+	if n.FirstChild == nil || n.FirstChild != n.LastChild || n.FirstChild.Type != html.TextNode {
+		// Already parsed as elements, so staticated along with the rest of the doc.
+		return links
+	}
+	text := n.FirstChild
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragmentWithOptions(strings.NewReader(text.Data), body, html.ParseOptionEnableScripting(false))
+	if err != nil {
+		log.Printf("  Could not parse <noscript> content: %v", err)
+		return links
+	}
+	container := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, x := range nodes {
+		container.AppendChild(x)
+	}
+	links = append(links, c.staticateDoc(container, origin)...)
+	var b strings.Builder
+	for x := container.FirstChild; x != nil; x = x.NextSibling {
+		html.Render(&b, x)
+	}
+	text.Data = b.String()
+	return links
+}
 
+// isPreloadRel reports whether a <link> rel attribute value hints at fetching
+// a resource, e.g. "preload" or "modulepreload".
+func isPreloadRel(rel string) bool {
+	return hasRel(rel, "preload") || hasRel(rel, "prefetch") || hasRel(rel, "modulepreload")
+}
+
+// hasRel reports whether a space-separated rel attribute value includes a
+// link type.
+func hasRel(rel, want string) bool {
+	for _, r := range strings.Fields(rel) {
+		if strings.EqualFold(r, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// staticateNode staticates a single HTML node, appending any links to follow
+// onto `links` and returning the extended slice.
+func (c *Crawler) staticateNode(n *html.Node, origin string, links []url.URL) []url.URL {
 	if n.Type == html.CommentNode {
 		// This deals with conditional comments containing links (e.g. to CSS)
 		// and also obscures the original domain in regular comments.
 		// FIXME: These might be resources we need to scrape and save.
-		n.Data = strings.Replace(n.Data, "https://"+origin+"/", "/", -1)
-		n.Data = strings.Replace(n.Data, "http://"+origin+"/", "/", -1)
-		return nil
+		if !c.DeferRelativize {
+			n.Data = strings.Replace(n.Data, "https://"+origin+"/", c.BasePath+"/", -1)
+			n.Data = strings.Replace(n.Data, "http://"+origin+"/", c.BasePath+"/", -1)
+		}
+		return links
 	}
 	if n.Type != html.ElementNode {
-		return nil
+		return links
+	}
+	if c.RewriteCSS {
+		c.rewriteStyle(n)
+	}
+	if len(c.ElementURLAttrs) > 0 {
+		c.relativizeElementAttrs(n)
 	}
 	// TODO: Prune nodes we don't want, e.g. <link rel="EditURI" ...>
 	// TODO: Deal with data-* attributes
 	switch n.DataAtom {
 	case atom.A:
 		a, u := getURLAttr(n, "href")
-		if a == nil || u == nil || !c.isLocal(*u) {
-			log.Printf("  Skipping invalid/non-local link %q", u)
+		if a != nil && isSamePageRef(a.Val) {
+			// An empty href is the current page, and a fragment a place on it.
+			// Neither needs fetching or rewriting, so both are left as authored.
+			break
+		}
+		if u != nil && !isWebScheme(u) {
+			// mailto:, tel:, javascript: etc. are left untouched.
+			break
+		}
+		if a != nil && u != nil && c.isOneHop(*u) {
+			links = append(links, withoutFragment(*u))
 			break
 		}
-		if u.Path == "" && u.Host == "" && u.RawQuery == "" {
-			// Fragment reference to current page or empty URL. No follow.
-			log.Printf("  Skipping fragment-only link %q", u)
+		if a == nil || u == nil || !c.isLocal(*u) {
+			c.logLink("  Skipping invalid/non-local link %q", u)
 			break
 		}
 
 		// Follow
-		if isDynamicPage(u) {
-			// Only things that don't look like static assets get crawled.
-			oURL := *u
-			links = append(links, oURL)
+		if getAttr(n, "download") != nil {
+			// Downloads are assets, even if they don't look like it.
+			c.logLink("  Skipping download link %q", u)
+		} else if isDynamicPage(u) {
+			// Only things that don't look like static assets get crawled. The
+			// page is followed without any fragment, so "/page#section" is
+			// fetched once along with "/page", but the fragment stays in the
+			// rewritten link for on-page navigation.
+			links = append(links, withoutFragment(*u))
 		} else {
-			log.Printf("  Skipping link that looks like a static asset %q", u)
+			c.logLink("  Skipping link that looks like a static asset %q", u)
 		}
 		// Relativize
-		relativize(u)
+		c.relativizeLocal(u)
 		a.Val = u.String()
 	case atom.Img:
 		// src
 		a, u := getURLAttr(n, "src")
-		if a != nil && u != nil && c.isLocal(*u) {
-			// Relativize
-			relativize(u)
+		if a != nil && u != nil && isWebScheme(u) && c.isLocal(*u) {
+			// Relativize. Lazy-loading placeholders are often data: URLs, which stay as they are.
+			c.relativizeLocal(u)
 			a.Val = u.String()
 		}
-		// srcset
-		a = getAttr(n, "srcset")
-		if a == nil {
-			break
-		}
-		srcs := strings.Split(a.Val, ",")
-		for i, img := range srcs {
-			var src, size string
-			fmt.Sscanf(img, "%s %s", &src, &size)
-			u, err := url.Parse(src)
-			if err != nil {
-				continue
-			}
-			if c.isLocal(*u) {
-				relativize(u)
-			}
-			srcs[i] = fmt.Sprintf("%s %s", u, size)
-		}
-		a.Val = strings.Join(srcs, ",")
-		// Handle data-medium-file, data-large-file, data-permalink, data-orig-file.
-		for _, d := range []string{"data-large-file", "data-medium-file", "data-orig-file", "data-permalink"} {
+		// Handle data-medium-file, data-large-file, data-permalink, data-orig-file,
+		// and lazy-loaded data-src.
+		for _, d := range []string{"data-large-file", "data-medium-file", "data-orig-file", "data-permalink", "data-src"} {
 			a, u := getURLAttr(n, d)
 			if a != nil && u != nil && c.isLocal(*u) {
 				// Relativize
-				relativize(u)
+				c.relativizeLocal(u)
 				a.Val = u.String()
 			}
 		}
+		// srcset
+		if a = getAttr(n, "srcset"); a != nil {
+			c.relativizeSrcset(a)
+		}
 	case atom.Link: // href
-		break // FIXME
+		rel := getAttr(n, "rel")
+		if rel != nil && rel.Val == "canonical" {
+			// Canonical URLs must stay absolute.
+			c.publishURLAttr(n, "href")
+			break
+		}
+		isAMP := rel != nil && c.CaptureAMP && hasRel(rel.Val, "amphtml")
+		isIcon := rel != nil && c.CaptureIcons && isIconRel(rel.Val)
+		// Stylesheets, like images, are relativized but not followed.
+		isStylesheet := rel != nil && hasRel(rel.Val, "stylesheet")
+		if rel == nil || (!isPreloadRel(rel.Val) && !isAMP && !isIcon && !isStylesheet) {
+			// TODO: Grab, but don't process or recurse into, dynamically-generated
+			// HTML-like links (e.g RSS feed) with c.saveRaw.
+			break
+		}
+		// Preconnect and dns-prefetch hints name other origins, so are left alone.
 		a, u := getURLAttr(n, "href")
-		if a == nil || u == nil || !c.isLocal(*u) {
+		if a == nil || u == nil || !isWebScheme(u) || !c.isLocal(*u) {
 			break
 		}
-		if isDynamicPage(u) {
-			// Grab, but don't process or recurse into, dynamically-generated HTML-like (e.g RSS feed)
-			c.saveRaw(*u)
+		if isAMP || isIcon || (c.CapturePreloads && isPreloadRel(rel.Val)) {
+			// AMP variants are crawled as regular pages.
+			links = append(links, *u)
 		}
-		relativize(u)
+		// Attributes like as= and crossorigin= are kept as they are.
+		c.relativizeLocal(u)
 		a.Val = u.String()
 	case atom.Script:
+		if t := getAttr(n, "type"); t != nil && t.Val == "application/ld+json" {
+			// Linked data requires absolute URLs.
+			c.publishText(n, origin)
+			break
+		}
+		if t := getAttr(n, "type"); c.ExtractEmbeddedLinks && t != nil && t.Val == "application/json" {
+			links = append(links, c.jsonLinks(nodeText(n))...)
+			break
+		}
 		break // FIXME
 		// src
 		a, u := getURLAttr(n, "src")
 		if a != nil && u != nil && c.isLocal(*u) {
-			relativize(u)
+			c.relativizeLocal(u)
 			a.Val = u.String()
 			break
 		}
@@ -269,84 +807,372 @@ func (c *Crawler) staticateNode(n *html.Node, origin string) []url.URL {
 		// log.Println("  Out:", js)
 		n.AppendChild(&html.Node{Type: html.TextNode, Data: js})
 		// TODO: Decide if there are URLs we need to extract from script for crawling, e.g. JSON data.
+	case atom.Noscript:
+		links = c.staticateNoscript(n, origin, links)
+	case atom.Style:
+		if c.ExtractEmbeddedLinks {
+			links = append(links, c.cssImportLinks(nodeText(n))...)
+		}
 	case atom.Meta:
+		if p := getAttr(n, "property"); p != nil && p.Val == "og:url" {
+			// Open Graph URLs must stay absolute.
+			c.publishURLAttr(n, "content")
+			break
+		}
 		break // FIXME
 		// TODO: Decide if we should do something more with these.
 		a, u := getURLAttr(n, "content")
 		if a != nil && u != nil && c.isLocal(*u) {
-			relativize(u)
+			c.relativizeLocal(u)
 			a.Val = u.String()
 			break
 		}
 	case atom.Form:
-		// We "defang" these for now.
-		// TODO: Conditionally allow local <form> submits to support smart edge routing.
-		a, u := getURLAttr(n, "content")
-		if a != nil && u != nil && c.isLocal(*u) {
+		// Local forms won't work on a static site, so by default we "defang" them.
+		// They can be kept (relativized) to support smart edge routing.
+		a, u := getURLAttr(n, "action")
+		if a == nil || u == nil || !isWebScheme(u) || !c.isLocal(*u) {
+			break
+		}
+		switch c.FormActions {
+		case DefangForms:
 			a.Val = "#"
+		case RelativizeForms:
+			c.relativizeLocal(u)
+			a.Val = u.String()
 		}
 	}
 
 	return links
 }
 
+// relativizeSrcset relativizes the local image URLs in a srcset attribute.
+func (c *Crawler) relativizeSrcset(a *html.Attribute) {
+	srcs := strings.Split(a.Val, ",")
+	for i, img := range srcs {
+		var src, size string
+		fmt.Sscanf(img, "%s %s", &src, &size)
+		u, err := url.Parse(src)
+		if err != nil {
+			continue
+		}
+		if c.isLocal(*u) {
+			// Query strings (e.g. ?ver=1.2) are preserved.
+			c.relativizeLocal(u)
+		}
+		srcs[i] = u.String()
+		if size != "" {
+			srcs[i] += " " + size
+		}
+	}
+	a.Val = strings.Join(srcs, ",")
+}
+
+// relativizeElementAttrs relativizes local URLs in the ElementURLAttrs of an
+// element, e.g. <amp-img src>. Custom elements have no atom, so these are
+// matched by tag name.
+func (c *Crawler) relativizeElementAttrs(n *html.Node) {
+	for _, name := range c.ElementURLAttrs[n.Data] {
+		if name == "srcset" {
+			if a := getAttr(n, name); a != nil {
+				c.relativizeSrcset(a)
+			}
+			continue
+		}
+		a, u := getURLAttr(n, name)
+		if a != nil && u != nil && isWebScheme(u) && c.isLocal(*u) {
+			c.relativizeLocal(u)
+			a.Val = u.String()
+		}
+	}
+}
+
 // processURL fetches, parses and staticates a URL
 // returning serialized (staticated) content and a list of further URLs to process.
-func (c *Crawler) processURL(u url.URL) (*resource.Resource, []url.URL, error) {
+// Large raw content may instead be streamed straight to storage, and content
+// marked noindex by X-Robots-Tag (with RespectRobotsTag) is not stored. In
+// these cases the returned resource is nil.
+// A response cut off mid-body is fetched again, rather than storing partial
+// content. Assets stored within MaxAssetAge aren't fetched at all.
+func (c *Crawler) processURL(ctx context.Context, u url.URL) (*resource.Resource, []url.URL, error) {
+	if c.isRecentAsset(u) {
+		log.Printf("Skipping %q: asset stored within the last %v\n", &u, c.MaxAssetAge)
+		return nil, nil, nil
+	}
+	for attempt := 0; ; attempt++ {
+		r, links, err := c.processURLOnce(ctx, u)
+		if !errors.Is(err, errTruncated) || attempt >= MAX_TRUNCATED_RETRIES || ctx.Err() != nil {
+			return r, links, err
+		}
+		log.Printf("Retrying %q: %v\n", &u, err)
+	}
+}
+
+// isRecentAsset reports whether u is stored as non-HTML content crawled less
+// than MaxAssetAge ago. Web app manifests are fetched regardless when
+// capturing icons, for the links in them. Only the stored metadata is read,
+// e.g. with a HEAD request on S3, and pages aren't looked up at all.
+func (c *Crawler) isRecentAsset(u url.URL) bool {
+	if c.MaxAssetAge <= 0 || c.db == nil || isDynamicPage(&u) {
+		return false
+	}
+	r, err := storage.ReadMetadata(c.db, c.storageKey(u))
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Could not read stored %q, fetching it: %v\n", &u, err)
+		}
+		return false
+	}
+	if r.GetRedirect() != "" || isHTMLContentType(r.GetContentType()) || r.GetCrawledAt() == nil {
+		return false
+	}
+	if c.CaptureIcons && isManifest(r.GetContentType(), u) {
+		return false
+	}
+	return time.Since(r.GetCrawledAt().AsTime()) < c.MaxAssetAge
+}
 
-	resp, err := c.httpClient.Get(u.String())
+// processURLOnce makes a single attempt at processURL.
+func (c *Crawler) processURLOnce(ctx context.Context, u url.URL) (*resource.Resource, []url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
-		fmt.Printf("Error fetching URL %q: %v\n", &u, err)
 		return nil, nil, err
 	}
+	release, err := c.acquireHost(ctx, u.Hostname())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		if err := c.rateLimit(ctx, u); err != nil {
+			return nil, nil, err
+		}
+		resp, err = c.httpClient.Do(req)
+		if err != nil {
+			fmt.Printf("Error fetching URL %q: %v\n", &u, err)
+			return nil, nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+		resp.Body.Close()
+		if attempt >= MAX_RATE_LIMIT_RETRIES {
+			return nil, nil, fmt.Errorf("still rate limited (HTTP 429) after %d retries", attempt)
+		}
+		// Back off the whole host, not just this URL.
+		d := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		log.Printf("Rate limited fetching %q, retrying in %v\n", &u, d)
+		c.backOff(u.Hostname(), d)
+	}
 	defer resp.Body.Close()
 
+	if c.CaptureIcons && resp.StatusCode == http.StatusNotFound && isWellKnownIcon(u) {
+		// Rather than storing the site's error page as an icon. Many sites
+		// have none, so this is no error.
+		log.Printf("No icon at %q\n", &u)
+		return nil, nil, nil
+	}
+
 	switch resp.StatusCode {
 	case 301, 302, 303, 307, 308:
 		loc := resp.Header.Get("Location")
-		l, err := url.ParseRequestURI(loc)
+		target, err := parseLocation(u, loc)
 		if err != nil {
 			log.Printf("Redirect from %q to invalid url %q: %v\n", &u, loc, err)
 			return nil, nil, err
 		}
-		log.Printf("Found redirect from %q to %q\n", &u, loc)
-		return &resource.Resource{Redirect: loc}, []url.URL{*l}, nil
+		log.Printf("Found redirect from %q to %q\n", &u, target)
+		// Off-site targets are stored resolved, as e.g. "//cdn.example.com/x"
+		// is not a valid stored redirect.
+		loc = target.String()
+		if c.isLocal(*target) {
+			loc = c.redirectLocation(*target)
+		}
+		return &resource.Resource{Redirect: loc, SourceUrl: u.String()}, []url.URL{*target}, nil
+	}
+
+	var noindex, nofollow bool
+	if c.RespectRobotsTag {
+		noindex, nofollow = robotsTag(resp.Header)
 	}
 
 	// Generated non-HTML resources get saved un-parsed.
 	// FIXME: Handle some special content types. E.g. generated CSS with image links.
-	r := &resource.Resource{ContentType: resp.Header.Get("Content-Type")}
+	r := &resource.Resource{
+		ContentType: inferContentType(resp.Header.Get("Content-Type"), u),
+		SourceUrl:   u.String(),
+	}
 	if !isHTMLContentType(r.ContentType) {
-		r.Content, err = io.ReadAll(resp.Body)
-		return r, nil, err
+		if noindex {
+			log.Printf("Not storing %q: X-Robots-Tag noindex\n", &u)
+			return nil, nil, nil
+		}
+		if !c.storesContentType(r.ContentType) {
+			log.Printf("    Skipping raw content of %q with type %q.\n", &u, r.ContentType)
+			return nil, nil, nil
+		}
+		if c.streams(resp, r.ContentType, u) {
+			return nil, nil, c.writeStream(c.storageKey(u), r, resp)
+		}
+		r.Content, err = readBody(resp)
+		if err == nil && isFeedContentType(r.ContentType) {
+			if feed, ferr := c.rewriteFeed(r.Content); ferr != nil {
+				log.Printf("Error rewriting feed %q, storing as is: %v\n", &u, ferr)
+			} else {
+				r.Content = feed
+			}
+		}
+		if err == nil && c.RewriteCSS && isCSSContentType(r.ContentType) {
+			r.Content = []byte(c.rewriteCSS(string(r.Content), false))
+		}
+		var links []url.URL
+		if err == nil && c.CaptureIcons && isManifest(r.ContentType, u) {
+			var merr error
+			if r.Content, links, merr = c.rewriteManifest(r.Content, u); merr != nil {
+				log.Printf("Error rewriting manifest %q, storing as is: %v\n", &u, merr)
+			}
+		}
+		if nofollow {
+			links = nil
+		}
+		return r, links, err
 	}
 
-	doc, err := html.Parse(resp.Body)
+	body, err := readBody(resp)
+	if err != nil {
+		log.Printf("Error reading HTML from %q: %v\n", &u, err)
+		return nil, nil, err
+	}
+	if c.StoreOriginal && c.db != nil && !noindex && (c.MaxOriginalSize <= 0 || len(body) <= c.MaxOriginalSize) {
+		c.saveOriginal(u, r.ContentType, body)
+	}
+	// The parser assumes UTF-8, and the document is rendered as UTF-8.
+	body, converted := decodeHTML(body, r.ContentType)
+	doc, err := html.Parse(bytes.NewReader(body))
 	if err != nil {
 		log.Printf("Error parsing HTML from %q: %v\n", &u, err)
 		return nil, nil, err
 	}
+	if converted {
+		setUTF8Charset(doc)
+		r.ContentType = "text/html; charset=utf-8"
+	}
+	if c.Soft404 != nil && c.Soft404.Match(body, doc) {
+		return nil, nil, errSoft404
+	}
+	if c.Validator != nil {
+		if err := c.Validator.Check(body, doc); err != nil {
+			log.Printf("Not storing %q, keeping any stored copy: %v\n", &u, err)
+			return nil, nil, err
+		}
+	}
 
 	// Convert the document to a static-compatible form with fully
 	// relative links, and extract links to other documents in the site.
 	links := c.staticateDoc(doc, u.Hostname())
+	for i := range links {
+		// Links may be relative to the page they were found on.
+		links[i] = *u.ResolveReference(&links[i])
+	}
+	if len(c.Metadata) > 0 {
+		if m := pageMetadata(doc, c.Metadata); len(m) > 0 {
+			r.Metadata = m
+		}
+	}
+	if c.Minify {
+		minifyDoc(doc, c.StripComments)
+	}
 	content := new(bytes.Buffer)
 	html.Render(content, doc)
 	r.Content = content.Bytes()
 
+	if nofollow {
+		links = nil
+	}
+	if noindex {
+		// Links are still followed, unless also nofollow.
+		log.Printf("Not storing %q: X-Robots-Tag noindex\n", &u)
+		return nil, links, nil
+	}
 	return r, links, nil
 }
 
-// followRedirects follows and saves a chain of redirects.
+// Max times a URL is fetched again after its response was cut off.
+const MAX_TRUNCATED_RETRIES = 2
+
+// errTruncated is returned when a response body could not be read in full,
+// e.g. because the connection was reset.
+var errTruncated = errors.New("response truncated")
+
+// readBody reads a whole response body. Any failure part way through means
+// the content is incomplete, so is reported as errTruncated.
+func readBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(&bodyReader{resp: resp})
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// bodyReader reads a response body, failing with errTruncated if it is cut
+// short.
+type bodyReader struct {
+	resp *http.Response
+	n    int64 // Bytes read so far.
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.resp.Body.Read(p)
+	b.n += int64(n)
+	switch {
+	case err == io.EOF && b.resp.ContentLength >= 0 && b.n < b.resp.ContentLength && !b.resp.Uncompressed:
+		// Decompressed bodies don't match the Content-Length.
+		return n, fmt.Errorf("%w: got %d of %d bytes", errTruncated, b.n, b.resp.ContentLength)
+	case err != nil && err != io.EOF:
+		return n, fmt.Errorf("%w after %d bytes: %v", errTruncated, b.n, err)
+	}
+	return n, err
+}
+
+// parseLocation parses the Location header of a redirect from `u`. Relative
+// locations, which some servers send, are resolved against `u`.
+func parseLocation(u url.URL, loc string) (*url.URL, error) {
+	if loc == "" {
+		return nil, errors.New("redirect has no Location header")
+	}
+	l, err := url.Parse(strings.TrimSpace(loc))
+	if err != nil {
+		return nil, err
+	}
+	if !isWebScheme(l) {
+		return nil, fmt.Errorf("redirect to unsupported scheme %q", l.Scheme)
+	}
+	return u.ResolveReference(l), nil
+}
+
+func (c *Crawler) maxRedirects() int {
+	if c.MaxRedirects <= 0 {
+		return MAX_REDIRECTS
+	}
+	return c.MaxRedirects
+}
+
+// followRedirects follows and saves a chain of redirects, starting at u
+// whether or not it was seen before, but stopping at any target which was.
 // If a non-redirect response is received from a local URL, the response
 // is returned. In this case the caller MUST close the response body.
 func (c *Crawler) followRedirects(u url.URL) (*url.URL, *http.Response) {
 	redirCount := 0
+	maxRedirects := c.maxRedirects()
+	// URLs visited in this redirect chain, to detect loops.
+	chain := map[string]struct{}{}
 	for {
-		sortQueryValues(&u)
-		if c.isSeen(u) {
+		u = c.canonicalize(u)
+		if redirCount > 0 && c.isSeen(u) {
 			return nil, nil
 		}
+		chain[u.String()] = struct{}{}
 		resp, err := c.httpClient.Get(u.String())
 		if err != nil {
 			fmt.Printf("Error fetching URL %q: %v\n", u.String(), err)
@@ -356,25 +1182,32 @@ func (c *Crawler) followRedirects(u url.URL) (*url.URL, *http.Response) {
 		case 301, 302, 303, 307, 308:
 			resp.Body.Close()
 			loc := resp.Header.Get("Location")
-			if redirCount > MAX_REDIRECTS {
+			if redirCount >= maxRedirects {
 				log.Printf("Too many redirects, last was %q to %q.\n", &u, loc)
 				return nil, nil
 			}
-			l, err := url.ParseRequestURI(loc)
+			l, err := parseLocation(u, loc)
 			if err != nil {
-				log.Printf("Redirect from %q to invalid url %q: %v\n", &u, l, err)
+				log.Printf("Redirect from %q to invalid url %q: %v\n", &u, loc, err)
+				return nil, nil
+			}
+			next := c.canonicalize(*l)
+			if _, ok := chain[next.String()]; ok {
+				log.Printf("Redirect loop detected: %q redirects back to %q.\n", &u, l)
 				return nil, nil
 			}
 			if c.isLocal(*l) {
 				log.Printf("Saving redirect from %q to %q\n", &u, l)
-				if err := c.db.Write(rootRelativeURL(u), &resource.Resource{Redirect: rootRelativeURL(*l)}); err != nil {
+				if err := c.write(c.storageKey(u), &resource.Resource{Redirect: c.redirectLocation(*l), SourceUrl: u.String()}); err != nil {
 					log.Printf("Error saving redirect from %q to %q: %v\n", &u, loc, err)
+					c.errs.Add(c.storageKey(u), err)
 					return nil, nil
 				}
 			} else {
 				log.Printf("Saving redirect from %q to off-site url %q\n", &u, l)
-				if err := c.db.Write(rootRelativeURL(u), &resource.Resource{Redirect: loc}); err != nil {
+				if err := c.write(c.storageKey(u), &resource.Resource{Redirect: l.String(), SourceUrl: u.String()}); err != nil {
 					log.Printf("Error saving redirect from %q to %q: %v\n", &u, loc, err)
+					c.errs.Add(c.storageKey(u), err)
 					return nil, nil
 				}
 				return l, nil
@@ -387,44 +1220,169 @@ func (c *Crawler) followRedirects(u url.URL) (*url.URL, *http.Response) {
 	}
 }
 
-// saveRaw saves the contents fetched from a URL without any processing.
-// Use this for grabbing static contents of dynamically-generated non-HTML.
-func (c *Crawler) saveRaw(u url.URL) {
+// saveOriginal stores the unmodified HTML of a page under ORIGINAL_KEY_PREFIX.
+// Failures are recorded but don't stop the page itself being stored.
+func (c *Crawler) saveOriginal(u url.URL, contentType string, body []byte) {
+	k := ORIGINAL_KEY_PREFIX + c.storageKey(u)
+	err := c.write(k, &resource.Resource{ContentType: contentType, Content: body, SourceUrl: u.String()})
+	if err != nil {
+		log.Printf("Could not save original HTML for %q: %v\n", &u, err)
+		c.errs.Add(k, err)
+	}
+}
+
+// write stamps a resource with the crawl time and saves it to storage. With a
+// Manifest, resources unchanged since they were last written are skipped.
+func (c *Crawler) write(k string, r *resource.Resource) error {
+	if c.Manifest == nil {
+		r.CrawledAt = timestamppb.Now()
+		return c.db.Write(k, r)
+	}
+	r.CrawledAt = nil
+	h := resourceHash(r)
+	if c.Manifest.unchanged(k, h) {
+		// Much cheaper than a write, e.g. a HEAD rather than a PUT on S3.
+		if stored, err := storage.ReadMetadata(c.db, k); err == nil && !c.isStale(stored) {
+			log.Printf("Skipping write of unchanged %q\n", k)
+			return nil
+		}
+	}
+	r.CrawledAt = timestamppb.Now()
+	if err := c.db.Write(k, r); err != nil {
+		return err
+	}
+	c.Manifest.set(k, h)
+	return nil
+}
+
+// isStale reports whether a stored asset is older than MaxAssetAge, so that
+// it must be written again, even if unchanged, to refresh its CrawledAt.
+// Otherwise it would be fetched again on every crawl.
+func (c *Crawler) isStale(r *resource.Resource) bool {
+	if c.MaxAssetAge <= 0 || r.GetRedirect() != "" || isHTMLContentType(r.GetContentType()) || r.GetCrawledAt() == nil {
+		return false
+	}
+	return time.Since(r.GetCrawledAt().AsTime()) >= c.MaxAssetAge
+}
+
+// streams reports whether the body of resp, with the given content type,
+// is to be streamed straight to storage rather than read into memory. Only
+// large bodies of known size are, and only if nothing needs the content first:
+// rewriting it, or hashing it for the Manifest.
+func (c *Crawler) streams(resp *http.Response, contentType string, u url.URL) bool {
+	if _, ok := c.db.(storage.StreamWriter); !ok || c.Manifest != nil {
+		return false
+	}
+	return resp.ContentLength >= STREAM_MIN_SIZE && !c.rewritesRaw(contentType, u)
+}
+
+// rewritesRaw reports whether non-HTML content of a type is rewritten before
+// it is stored.
+func (c *Crawler) rewritesRaw(contentType string, u url.URL) bool {
+	return isFeedContentType(contentType) ||
+		(c.RewriteCSS && isCSSContentType(contentType)) ||
+		(c.CaptureIcons && isManifest(contentType, u))
+}
+
+// writeStream stamps a resource with the crawl time and saves it to storage
+// with the body of resp as its content, which must be allowed by streams.
+func (c *Crawler) writeStream(k string, r *resource.Resource, resp *http.Response) error {
+	r.CrawledAt = timestamppb.Now()
+	return c.db.(storage.StreamWriter).WriteStream(k, r, &bodyReader{resp: resp})
+}
+
+// saveRaw saves the contents fetched from a URL without any processing,
+// other than by `rewrite`, if set. Use this for grabbing static contents of
+// dynamically-generated non-HTML. Returns the response headers, or nil if
+// nothing was fetched, e.g. because the URL was already seen.
+func (c *Crawler) saveRaw(u url.URL, rewrite func([]byte) ([]byte, error)) (http.Header, error) {
 	log.Printf("    Attempting to save raw content of %q.\n", &u)
 	l, resp := c.followRedirects(u)
 	if resp == nil {
 		// No content found
 		log.Printf("Could not fech non-HTML dynamic content from %q.\n", &u)
-		return
+		return nil, nil
 	}
 	defer resp.Body.Close()
 
-	relativize(l)
-	sortQueryValues(l)
-	if c.isSeen(*l) {
-		return
+	c.markSeen(*l)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %q: HTTP %d", l, resp.StatusCode)
 	}
 
 	rs := &resource.Resource{
-		ContentType: resp.Header.Get("Content-Type"),
+		ContentType: inferContentType(resp.Header.Get("Content-Type"), *l),
+		SourceUrl:   l.String(),
+	}
+	if !c.storesContentType(rs.ContentType) {
+		log.Printf("    Skipping raw content of %q with type %q.\n", l, rs.ContentType)
+		return resp.Header, nil
 	}
-	content, err := io.ReadAll(resp.Body)
+	if rewrite == nil && c.streams(resp, rs.ContentType, *l) {
+		if err := c.writeStream(c.storageKey(*l), rs, resp); err != nil {
+			return nil, fmt.Errorf("saving raw content for %q: %w", l, err)
+		}
+		return resp.Header, nil
+	}
+	content, err := readBody(resp)
 	if err != nil {
-		fmt.Printf("Error reading response body from URL %q: %v\n", &u, err)
-		return
+		return nil, fmt.Errorf("reading %q: %w", l, err)
+	}
+	if rewrite != nil {
+		if rewritten, err := rewrite(content); err != nil {
+			log.Printf("Error rewriting %q, storing as is: %v\n", l, err)
+		} else {
+			content = rewritten
+		}
 	}
 	rs.Content = content
-	// url.URL.String() outputs querystrings in key-sorted order.
-	if err := c.db.Write(l.String(), rs); err != nil {
-		// TODO: Graceful error handling.
-		log.Fatalf("Could not save raw content for %q: %v", l, err)
+	if err := c.write(c.storageKey(*l), rs); err != nil {
+		return nil, fmt.Errorf("saving raw content for %q: %w", l, err)
 	}
+	return resp.Header, nil
+}
+
+// CrawlOne fetches, staticates and stores exactly one URL. Links found in it
+// are discarded, and a redirect is stored without being followed.
+func (c *Crawler) CrawlOne(ctx context.Context, u url.URL) error {
+	u = c.canonicalize(u)
+	c.markSeen(u)
+	res, _, err := c.processURL(ctx, u)
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		// Already streamed to storage, not to be stored, or still fresh.
+		return nil
+	}
+	return c.write(c.storageKey(u), res)
+}
+
+// Fetch fetches and staticates exactly one URL, as a crawl would, and returns
+// the resource which would be stored, if any, and the links found in it,
+// without storing anything. The crawler needs no storage for this, e.g.
+// New(origin, aliases, nil), in which case large non-HTML content is read in
+// full rather than streamed to storage.
+func (c *Crawler) Fetch(ctx context.Context, u url.URL) (*resource.Resource, []url.URL, error) {
+	return c.processURL(ctx, c.canonicalize(u))
+}
+
+// CrawlStats summarizes the outcome of a crawl.
+type CrawlStats struct {
+	Visited    []string     // Page keys (before any KeyTransform) of all URLs fetched or attempted, sorted.
+	Unvisited  []string     // Page keys of local links found but not fetched due to limits, sorted.
+	Fetched    int          // Number of URLs fetched or attempted.
+	Errors     int          // Number of URLs which could not be fetched, processed or stored.
+	ErrorKinds []ErrorGroup // Errors grouped by cause, most frequent first, with samples.
+	Stopped    bool         // The crawl was cut short by its context being cancelled.
 }
 
 // CrawlP starts at a URL `u` and fetches up to `fetchLimit` URLs
 // found by following links in each downloaded HTML page.
 // Up to `maxP` page fetches are run concurrently.
-func (c *Crawler) CrawlP(u url.URL, fetchLimit int, maxP int) {
+// If `ctx` is cancelled, queued URLs are dropped and CrawlP returns once any
+// in-flight fetches have been stored.
+func (c *Crawler) CrawlP(ctx context.Context, u url.URL, fetchLimit int, maxP int) *CrawlStats {
 
 	type result struct {
 		key      string             // The site-relative URL fetched.
@@ -433,20 +1391,17 @@ func (c *Crawler) CrawlP(u url.URL, fetchLimit int, maxP int) {
 		err      error              // Any error seen during fetching or parsing.
 	}
 
-	// The job queue
+	// The job queue, and all crawl state below which is shared between
+	// goroutines, is guarded by toDoCond.L.
 	toDoCond := sync.NewCond(&sync.Mutex{})
-	toDo := []url.URL{}
+	toDo := newURLQueue(c.MaxQueue)
+	defer toDo.Close()
 	// Increment any time something is added to toDo
-	// TODO: Wrap all this in a function.
 	fetched := 0
 
-	// Close this to shut down any goroutines when the craw is finished.
-	done := make(chan struct{})
-
-	// WaitGroup for pending unprocessed URLs. Incremented before URLs are
-	// added to `toDo` so that the crawl doesn't stop prematurely during a
-	// moment of idleness.
-	wg := sync.WaitGroup{}
+	// Number of URLs queued or being processed. The crawl is finished when
+	// this drops to zero, which only happens in finishJob().
+	pending := 0
 
 	// Results coming back from workers.
 	results := make(chan result)
@@ -454,39 +1409,128 @@ func (c *Crawler) CrawlP(u url.URL, fetchLimit int, maxP int) {
 	// Links we found, but which exceeded fetchLimit, in string format. For tracking only.
 	extraLinks := map[string]struct{}{}
 
+	// Count of URLs fetched from each host, for enforcing MaxPagesPerHost.
+	hostFetched := map[string]int{}
+
+	// Keys of URLs fetched or attempted. Only touched by the result processor.
+	visited := map[string]struct{}{}
+
+	// Count of URLs which failed to fetch or process. Only touched by the result processor.
+	errCount := 0
+
+	// Keys of URLs which failed to fetch, process or store, to be retried by a
+	// later run. Only touched by the result processor.
+	failed := map[string]struct{}{}
+
+	// Set when queued or found URLs are dropped due to cancellation.
+	stopped := false
+
+	// Number of redirects followed to reach each queued redirect target, by
+	// page key, for enforcing MaxRedirects. Unset for links.
+	redirectHops := map[string]int{}
+
+	// enqueue adds a URL to the job queue. toDoCond.L must be held.
+	enqueue := func(u url.URL, priority float64, hops int) {
+		if hops > 0 {
+			redirectHops[c.pageKey(u)] = hops
+		}
+		c.markSeen(u)
+		toDo.PushPriority(u, priority)
+		pending++
+		fetched++
+		hostFetched[u.Hostname()]++
+		c.progress.update(func(p *CrawlProgress) { p.Queued++ })
+	}
+
+	// finishJob marks one queued URL as done, after first queueing any new
+	// URLs found while processing it, each reached by `hops` redirects. This
+	// is the only place that `pending` is decremented, so the crawl can't
+	// finish while there is work left.
+	finishJob := func(found []url.URL, hops int) {
+		toDoCond.L.Lock()
+		defer toDoCond.L.Unlock()
+		for _, u := range found {
+			u = c.canonicalize(u)
+
+			// Check if it's a viable candidate
+			if (!c.isLocal(u) && !c.isOneHop(u)) || c.isSeen(u) {
+				continue
+			}
+
+			// Check if we exceeded the provided limits
+			if ctx.Err() != nil {
+				extraLinks[c.pageKey(u)] = struct{}{}
+				stopped = true
+				continue
+			}
+			if fetched >= fetchLimit {
+				extraLinks[c.pageKey(u)] = struct{}{}
+				continue
+			}
+			if c.MaxPagesPerHost > 0 && hostFetched[u.Hostname()] >= c.MaxPagesPerHost {
+				extraLinks[c.pageKey(u)] = struct{}{}
+				continue
+			}
+
+			// Create a job to scrape this URL
+			enqueue(u, 0, hops)
+		}
+		pending--
+		// Let the dispatcher know there is new work, or that we're finished.
+		toDoCond.Broadcast()
+	}
+
 	// The dispatcher takes URLs from the toDo queue and starts workers to process them.
-	// Only `maxP` workers are run concurrently.
+	// Only `maxP` workers are run concurrently. It returns once the crawl is finished.
 	dispatcher := func() {
 		// A semaphore to control the concurrecy level.
 		// TODO: Investigate whether it works better to control concurrency level
 		//       only on HTTP fetches (or have a different concurrency level for each)
 		sem := make(chan struct{}, maxP)
 		for {
-			select {
-			case <-done:
+			toDoCond.L.Lock()
+			for toDo.Len() == 0 && pending > 0 {
+				toDoCond.Wait()
+			}
+			if pending == 0 {
+				toDoCond.L.Unlock()
 				log.Println("Dispatcher: shutting down")
 				return
-			default:
-				toDoCond.L.Lock()
-				for len(toDo) == 0 {
-					toDoCond.Wait()
-				}
-				// There's work to do!
-				u := toDo[0]
-				toDo = toDo[1:]
-				toDoCond.L.Unlock()
-				log.Printf("Dispatcher: attempting to start worker for %q", u.String())
-				// Wait until we have enough parallel capaicty to do the work.
-				sem <- struct{}{}
-				go func(u url.URL) {
-					log.Printf("Worker: Processing %q", u.String())
-					res, links, err := c.processURL(u)
-					log.Printf("Worker: Returning results for %q", u.String())
-					results <- result{key: u.String(), resource: res, links: links, err: err}
-					log.Printf("Worker: Results for %q returned", u.String())
-					<-sem // Release semaphore
-				}(u)
 			}
+			// There's work to do!
+			u := toDo.Pop()
+			cancelled := ctx.Err() != nil
+			if cancelled {
+				// Crawl cancelled. Drop the job.
+				extraLinks[c.pageKey(u)] = struct{}{}
+				fetched--
+				stopped = true
+			}
+			toDoCond.L.Unlock()
+			if cancelled {
+				c.progress.update(func(p *CrawlProgress) { p.Queued-- })
+				finishJob(nil, 0)
+				continue
+			}
+			log.Printf("Dispatcher: attempting to start worker for %q", u.String())
+			// Wait until we have enough parallel capaicty to do the work.
+			sem <- struct{}{}
+			c.progress.update(func(p *CrawlProgress) {
+				p.Queued--
+				p.InFlight++
+			})
+			go func(u url.URL) {
+				log.Printf("Worker: Processing %q", u.String())
+				res, links, err := c.processURL(ctx, u)
+				if !c.isLocal(u) {
+					// Only one hop into external hosts.
+					links = nil
+				}
+				log.Printf("Worker: Returning results for %q", u.String())
+				results <- result{key: c.pageKey(u), resource: res, links: links, err: err}
+				log.Printf("Worker: Results for %q returned", u.String())
+				<-sem // Release semaphore
+			}(u)
 		}
 	}
 
@@ -494,133 +1538,181 @@ func (c *Crawler) CrawlP(u url.URL, fetchLimit int, maxP int) {
 	resultProcessor := func() {
 		for resp := range results {
 			log.Printf("Picking up response for %q", resp.key)
+			if resp.err != nil && ctx.Err() != nil && errors.Is(resp.err, ctx.Err()) {
+				// Cut short by cancellation, so neither fetched nor an error.
+				toDoCond.L.Lock()
+				extraLinks[resp.key] = struct{}{}
+				stopped = true
+				toDoCond.L.Unlock()
+				c.progress.update(func(p *CrawlProgress) { p.InFlight-- })
+				finishJob(nil, 0)
+				continue
+			}
+			visited[resp.key] = struct{}{}
 			if resp.err != nil {
 				log.Printf("Error processing URL %q: %v\n", resp.key, resp.err)
+				c.errs.Add(resp.key, resp.err)
+				errCount++
+				failed[resp.key] = struct{}{}
+				c.progress.update(func(p *CrawlProgress) {
+					p.InFlight--
+					p.Fetched++
+					p.Errors++
+				})
 				// TODO: Put back on the processing queue and keep a retry count to
 				//       deal with transient errors.
-				wg.Done()
+				finishJob(nil, 0)
 				continue
 			}
 
-			// Add any unique new URLs, up to fetchLimit
-			toDoCond.L.Lock()
-			for _, u := range resp.links {
-				// Normalize
-				if u.Path == "" {
-					u.Path = "/"
-				}
-				u.Fragment = ""
-
-				// Check if it's a viable candidate
-				if !c.isLocal(u) || c.isSeen(u) {
-					continue
-				}
-
-				// Check if we exceeded the provided limit
-				if fetched >= fetchLimit {
-					extraLinks[u.String()] = struct{}{}
-					continue
+			// Count this redirect, and drop it if it ends a chain which is
+			// too long, as followRedirects does.
+			hops := 0
+			if resp.resource.GetRedirect() != "" {
+				toDoCond.L.Lock()
+				hops = redirectHops[resp.key] + 1
+				toDoCond.L.Unlock()
+				if hops > c.maxRedirects() {
+					log.Printf("Too many redirects, last was %q to %q.\n", resp.key, resp.resource.GetRedirect())
+					resp.resource, resp.links = nil, nil
 				}
-
-				// Create a job to scrape this URL
-				wg.Add(1)
-				c.markSeen(u)
-				toDo = append(toDo, u)
-				fetched++
 			}
-			toDoCond.L.Unlock()
-			// Let the dispatcher know there is new work.
-			toDoCond.Broadcast()
 
 			// Write content to DB
-			if err := c.db.Write(resp.key, resp.resource); err != nil {
-				// TODO: Graceful error handling.
-				log.Fatalf("Could not save HTML content for %q: %v", u.Path, err)
+			var writeErr error
+			if resp.resource != nil { // Otherwise already streamed, not to be stored, or still fresh.
+				writeErr = c.write(c.transformKey(resp.key), resp.resource)
+			}
+			if writeErr != nil {
+				log.Printf("Could not save content for %q: %v\n", resp.key, writeErr)
+				c.errs.Add(resp.key, writeErr)
+				errCount++
+				failed[resp.key] = struct{}{}
 			}
+			c.progress.update(func(p *CrawlProgress) {
+				p.InFlight--
+				p.Fetched++
+				if writeErr != nil {
+					p.Errors++
+				}
+			})
 
-			// Mark one response as done.
-			wg.Done()
+			// Add any unique new URLs, up to fetchLimit
+			finishJob(resp.links, hops)
 		}
 	}
 
-	enqueueUrl := func(u url.URL) {
-		toDoCond.L.Lock()
-		wg.Add(1)
-		c.markSeen(u)
-		toDo = append(toDo, u)
-		fetched++
-		toDoCond.L.Unlock()
-		toDoCond.Signal()
+	c.errs.Reset()
+	c.fitIdleConns(maxP)
+	c.progress.update(func(p *CrawlProgress) { *p = CrawlProgress{Running: true} })
+	defer c.progress.update(func(p *CrawlProgress) { p.Running = false })
+
+	// Start the initial fetch before the dispatcher, so it has work to do.
+	// URLs left over from a previous run are queued too, if within the limits.
+	toDoCond.L.Lock()
+	enqueue(c.canonicalize(u), 0, 0)
+	// Under a tight fetchLimit, only the most important seeds are queued.
+	seeds := slices.Clone(c.Seeds)
+	slices.SortStableFunc(seeds, func(a, b Seed) int { return cmp.Compare(b.Priority, a.Priority) })
+	for _, s := range seeds {
+		s.URL = c.canonicalize(s.URL)
+		if c.isSeen(s.URL) {
+			continue
+		}
+		if fetched >= fetchLimit {
+			extraLinks[c.pageKey(s.URL)] = struct{}{}
+			continue
+		}
+		enqueue(s.URL, s.Priority, 0)
+	}
+	for _, r := range c.resumeURLs(u) {
+		r = c.canonicalize(r)
+		if c.isSeen(r) {
+			continue
+		}
+		if fetched >= fetchLimit {
+			extraLinks[c.pageKey(r)] = struct{}{}
+			continue
+		}
+		enqueue(r, 0, 0)
 	}
+	if c.CaptureIcons {
+		for _, i := range iconURLs(c.canonicalize(u)) {
+			if !c.isSeen(i) && fetched < fetchLimit {
+				enqueue(i, 0, 0)
+			}
+		}
+	}
+	toDoCond.L.Unlock()
 
 	// Start up our async workers
-	go dispatcher()
+	dispatcherDone := make(chan struct{})
+	go func() {
+		dispatcher()
+		close(dispatcherDone)
+	}()
 	go resultProcessor()
 
-	// Start the initial fetch.
-	if u.Path == "" {
-		u.Path = "/"
-	}
-	enqueueUrl(u)
-
-	// URLs found during the crawll cause wg.Add(1) to be called.
-	// Done() is called after processing, and only after any new URLs have been
-	// added to the queue. This prevents premature shutdown on a temporarily
-	// empty processing queue.
-	wg.Wait()
-	close(done)
+	// The dispatcher returns once nothing is queued or being processed, at
+	// which point every worker has delivered its result.
+	<-dispatcherDone
 	close(results)
 
-	visited := make([]string, len(c.seen))
-	i := 0
-	for u := range c.seen {
-		visited[i] = u
-		i++
-	}
-
-	log.Printf("Visited [%d]: %s\n", len(visited), visited)
-	log.Printf("Found but unvisited [%d]\n", len(extraLinks))
-}
-
-func (c *Crawler) CrawlNewResource(u *url.URL, conf *site.Config, fetchLimit int) error {
-	// Set up
-	var startHost string
-	for _, d := range conf.Domains {
-		if d == u.Hostname() {
-			startHost = d
-			continue
+	// Everything written must survive the process exiting straight after.
+	if s, ok := c.db.(storage.Syncer); ok {
+		if err := s.Sync(); err != nil {
+			log.Printf("Could not sync storage: %v\n", err)
+			c.errs.Add("", fmt.Errorf("syncing storage: %w", err))
+			errCount++
 		}
 	}
 
-	if startHost == "" {
-		return fmt.Errorf("resource %q is not in the domain list of the site config: %v", u.Hostname(), conf.Domains)
+	toDoCond.L.Lock()
+	defer toDoCond.L.Unlock()
+	stats := &CrawlStats{
+		Visited:    sortedKeys(visited),
+		Unvisited:  sortedKeys(extraLinks),
+		Fetched:    len(visited),
+		Errors:     errCount,
+		ErrorKinds: c.errs.Groups(),
+		Stopped:    stopped,
 	}
 
-	if u.Path == "" {
-		u.Path = "/"
+	// URLs which weren't fetched and stored, including any dropped when the
+	// crawl was stopped, are left for a later run to retry.
+	c.muSeen.Lock()
+	for k := range failed {
+		extraLinks[k] = struct{}{}
 	}
+	if c.bloom == nil {
+		for k := range extraLinks {
+			delete(c.seen, k)
+		}
+	}
+	c.unvisited = sortedKeys(extraLinks)
+	c.muSeen.Unlock()
 
-	var rType string
-	for _, r := range conf.Resources {
-		re := regexp.MustCompile(r.Path)
-
-		matches := re.FindStringSubmatch(u.Path)
-		if matches == nil {
-			continue
+	log.Printf("Visited [%d]: %s\n", len(stats.Visited), stats.Visited)
+	log.Printf("Found but unvisited [%d]\n", len(stats.Unvisited))
+	log.Printf("Errors [%d]\n", stats.Errors)
+	for _, g := range stats.ErrorKinds {
+		log.Printf("  %d x %s\n", g.Count, g.Kind)
+		for _, s := range g.Samples {
+			log.Printf("    %q: %v\n", s.Key, s.Err)
 		}
-		rType = r.Name
-		log.Printf("Resource is of type: %s\n", rType)
-		// TODO: Parse out the named capture groups into variables.
-		break
 	}
-	if rType == "" {
-		return fmt.Errorf("could not identify resource type from url: %s", u)
+	if stats.Stopped {
+		log.Printf("Crawl stopped early: %v\n", context.Cause(ctx))
 	}
+	return stats
+}
 
-	// visited := map[string]struct{}{}
-	// toVisit := []*url.URL{u}
-
-	log.Println("Crawling resource: ", u)
-
-	return errors.New("CrawlNewResource not fully implemented")
+// sortedKeys returns the keys of a string set in sorted order.
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,1020 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/TheSnook/polyester/storage"
+	"golang.org/x/net/html"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// benchPage returns a WordPress-like archive page with n posts, each with
+// local and external links and images.
+func benchPage(n int) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html><html><head><title>Archive</title>`)
+	b.WriteString(`<link rel="stylesheet" href="https://example.com/wp-content/themes/t/style.css?ver=1.2"></head><body>`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `<article class="post"><h2><a href="https://example.com/%d/post-%d/">Post %d</a></h2>`, 2000+i%20, i, i)
+		fmt.Fprintf(&b, `<img src="https://example.com/wp-content/uploads/%d.jpg" srcset="https://example.com/wp-content/uploads/%d-300.jpg 300w, https://example.com/wp-content/uploads/%d.jpg 1024w">`, i, i, i)
+		fmt.Fprintf(&b, `<p>Text with <a href="https://other.example/%d">an external link</a> and <em>markup</em>.</p><!-- post %d --></article>`, i, i)
+	}
+	b.WriteString(`</body></html>`)
+	return b.String()
+}
+
+func BenchmarkStaticateDoc(b *testing.B) {
+	c := New("example.com", nil, nil)
+	c.quiet = true
+	page := benchPage(500)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		doc, err := html.Parse(strings.NewReader(page))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		c.staticateDoc(doc, "example.com")
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://example.com", "https://example.com/"},
+		{"https://example.com/a/#section", "https://example.com/a/"},
+		{"https://example.com/a/?", "https://example.com/a/"},
+		{"https://EXAMPLE.com/a/", "https://example.com/a/"},
+		{"https://example.com:443/a/", "https://example.com/a/"},
+		{"http://example.com:80/a/", "http://example.com/a/"},
+		{"https://example.com:8443/a/", "https://example.com:8443/a/"},
+		{"http://example.com:443/a/", "http://example.com:443/a/"},
+		{"https://example.com/?b=2&a=1", "https://example.com/?a=1&b=2"},
+		{"https://example.com/?a=2&a=1", "https://example.com/?a=1&a=2"},
+		{"https://example.com/?utm_source=x&p=1&fbclid=y", "https://example.com/?p=1"},
+		{"https://example.com/?utm_source=x", "https://example.com/"},
+		{"https://example.com/a%2Fb?q=a+b", "https://example.com/a%2Fb?q=a+b"},
+		{"/relative/?b=1&a=2#top", "/relative/?a=2&b=1"},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parsing %q: %v", tc.in, err)
+		}
+		got := canonicalURL(*u, true)
+		if got.String() != tc.want {
+			t.Errorf("canonicalURL(%q) = %q, want %q", tc.in, got.String(), tc.want)
+		}
+		// Canonicalizing again changes nothing.
+		if again := canonicalURL(got, true); again.String() != got.String() {
+			t.Errorf("canonicalURL(canonicalURL(%q)) = %q, want %q", tc.in, again.String(), got.String())
+		}
+	}
+}
+
+func TestPageKeySpellings(t *testing.T) {
+	c := New("example.com", []string{"www.example.com"}, nil)
+	spellings := []string{
+		"https://example.com/a/?y=2&x=1",
+		"https://example.com:443/a/?x=1&y=2#frag",
+		"https://EXAMPLE.COM/a/?x=1&utm_medium=email&y=2",
+		"https://www.example.com/a/?x=1&y=2",
+	}
+	for _, s := range spellings {
+		u, _ := url.Parse(s)
+		if got, want := c.pageKey(*u), "/a/?x=1&y=2"; got != want {
+			t.Errorf("pageKey(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+// abort closes the connection without a response, which the crawler sees as
+// a fetch error.
+func abort(w http.ResponseWriter, r *http.Request) {
+	panic(http.ErrAbortHandler)
+}
+
+func TestCrawlStats(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":   `<a href="/a/">A</a> <a href="/b/">B</a>`,
+		"/a/": `<a href="/c/">C</a>`,
+		"/b/": `<a href="/">Home</a> <a href="/a/">A</a>`,
+	})
+	site.handle("/c/", abort)
+	c, db := newTestCrawler(site)
+	stats := c.CrawlP(context.Background(), site.u("/"), 10, 2)
+
+	want := &CrawlStats{Visited: []string{"/", "/a/", "/b/", "/c/"}, Fetched: 4, Errors: 1}
+	if !slices.Equal(stats.Visited, want.Visited) || stats.Fetched != want.Fetched || stats.Errors != want.Errors || len(stats.Unvisited) != 0 || stats.Stopped {
+		t.Errorf("CrawlP() = %+v, want %+v", stats, want)
+	}
+	if len(stats.ErrorKinds) != 1 || stats.ErrorKinds[0].Count != 1 {
+		t.Errorf("ErrorKinds = %+v, want one error", stats.ErrorKinds)
+	}
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/", "/a/", "/b/"}) {
+		t.Errorf("Stored %q", keys)
+	}
+
+	// With a lower limit, links found but not fetched are reported.
+	c, _ = newTestCrawler(site)
+	stats = c.CrawlP(context.Background(), site.u("/"), 1, 1)
+	if stats.Fetched != 1 || !slices.Equal(stats.Unvisited, []string{"/a/", "/b/"}) {
+		t.Errorf("CrawlP() with limit 1 = %+v, want 1 fetched and /a/, /b/ unvisited", stats)
+	}
+}
+
+func TestMaxPagesPerHost(t *testing.T) {
+	other := newTestSite(t, map[string]string{"/b1/": "B1", "/b2/": "B2", "/b3/": "B3"})
+	// Served under a different hostname from the origin, as an alias.
+	o, _ := url.Parse(other.URL)
+	otherHost := "localhost:" + o.Port()
+	otherURL := "http://" + otherHost
+	site := newTestSite(t, map[string]string{
+		"/": `<a href="/a1/">1</a> <a href="/a2/">2</a> <a href="/a3/">3</a>` +
+			`<a href="` + otherURL + `/b1/">1</a> <a href="` + otherURL + `/b2/">2</a> <a href="` + otherURL + `/b3/">3</a>`,
+		"/a1/": "A1", "/a2/": "A2", "/a3/": "A3",
+	})
+	c, _ := newTestCrawler(site)
+	c.aliases = []string{otherHost}
+	c.MaxPagesPerHost = 2
+	stats := c.CrawlP(context.Background(), site.u("/"), 100, 1)
+	if got := site.total(); got != 2 {
+		t.Errorf("Fetched %d pages from the origin, want 2", got)
+	}
+	if got := other.total(); got != 2 {
+		t.Errorf("Fetched %d pages from the alias host, want 2", got)
+	}
+	if stats.Fetched != 4 || len(stats.Unvisited) != 3 {
+		t.Errorf("CrawlP() = %+v, want 4 fetched and 3 unvisited", stats)
+	}
+}
+
+func TestCrawledAt(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<p>Home</p>`})
+	site.file("/style.css", "text/css", "p{}")
+	c, db := newTestCrawler(site)
+	before := time.Now()
+	c.CrawlP(context.Background(), site.u("/"), 1, 1)
+	c.CrawlOne(context.Background(), site.u("/style.css"))
+	for _, k := range []string{"/", "/style.css"} {
+		r, err := db.Read(k)
+		if err != nil {
+			t.Fatalf("Read(%q): %v", k, err)
+		}
+		if at := r.GetCrawledAt().AsTime(); at.Before(before.Truncate(time.Second)) || at.After(time.Now()) {
+			t.Errorf("%q crawled at %v, want during the crawl", k, at)
+		}
+	}
+}
+
+func TestSourceURL(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/a/?b=2&a=1">A</a> <a href="/old/">Old</a>`, "/a/": "A"})
+	site.redirect("/old/", "/a/")
+	c, db := newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	for k, want := range map[string]string{
+		"/":           site.URL + "/",
+		"/a/?a=1&b=2": site.URL + "/a/?a=1&b=2",
+		"/a/":         site.URL + "/a/",
+		"/old/":       site.URL + "/old/",
+	} {
+		r, err := db.Read(k)
+		if err != nil {
+			t.Errorf("Read(%q): %v", k, err)
+			continue
+		}
+		if got := r.GetSourceUrl(); got != want {
+			t.Errorf("%q has source URL %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestOneHopExternal(t *testing.T) {
+	ext := newTestSite(t, map[string]string{
+		"/post/":  `<a href="/other/">Other</a>`,
+		"/other/": "Other",
+	})
+	e, _ := url.Parse(ext.URL)
+	extURL := "http://localhost:" + e.Port()
+	site := newTestSite(t, map[string]string{"/": `<a href="` + extURL + `/post/">External post</a>`})
+	c, db := newTestCrawler(site)
+	c.OneHopHosts = []string{"localhost"}
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	if ext.fetches("/post/") != 1 || ext.total() != 1 {
+		t.Errorf("Got %d fetches from the external host, want just /post/", ext.total())
+	}
+	want := EXTERNAL_KEY_PREFIX + "localhost:" + e.Port() + "/post/"
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/", want}) {
+		t.Errorf("Stored %q, want / and %q", keys, want)
+	}
+}
+
+func TestNonWebLinks(t *testing.T) {
+	c := New("example.com", nil, nil)
+	c.quiet = true
+	in := `<a href="mailto:me@example.com">Mail</a>` +
+		`<a href="tel:+15555550100">Call</a>` +
+		`<a href="https://example.com/files/report" download>Report</a>` +
+		`<a href="https://example.com/about/">About</a>`
+	page, links := staticate(t, c, in)
+	for _, s := range []string{`href="mailto:me@example.com"`, `href="tel:+15555550100"`, `href="/files/report"`} {
+		if !strings.Contains(page, s) {
+			t.Errorf("Staticated page lacks %s:\n%s", s, page)
+		}
+	}
+	if want := []string{"https://example.com/about/"}; !slices.Equal(links, want) {
+		t.Errorf("Links = %q, want %q", links, want)
+	}
+}
+
+func TestVersionedAsset(t *testing.T) {
+	site := newTestSite(t, nil)
+	site.file("/style.css", "text/css", "p{}")
+	c, db := newTestCrawler(site)
+	u := site.u("/style.css")
+	u.RawQuery = "ver=6.4&b=1"
+	c.CrawlOne(context.Background(), u)
+	key := CanonicalKey(u, true)
+	if key != "/style.css?b=1&ver=6.4" {
+		t.Errorf("CanonicalKey(%q) = %q, want the sorted query", u.String(), key)
+	}
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{key}) {
+		t.Errorf("Stored %q, want %q", keys, key)
+	}
+
+	// Relativized asset URLs keep their query strings.
+	c = New("example.com", nil, nil)
+	c.quiet = true
+	page, _ := staticate(t, c, `<link rel="preload" as="style" href="https://example.com/style.css?ver=6.4">`+
+		`<img src="https://example.com/a.png?ver=2" srcset="https://example.com/a.png?ver=2 2x">`)
+	for _, s := range []string{`href="/style.css?ver=6.4"`, `src="/a.png?ver=2"`, `srcset="/a.png?ver=2 2x"`} {
+		if !strings.Contains(page, s) {
+			t.Errorf("Staticated page lacks %s:\n%s", s, page)
+		}
+	}
+}
+
+func TestCrawlDeadline(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":   `<a href="/slow/">Slow</a> <a href="/b/">B</a>`,
+		"/b/": `<a href="/c/">C</a>`,
+		"/c/": "C",
+	})
+	site.handle("/slow/", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	c, db := newTestCrawler(site)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	stats := c.CrawlP(ctx, site.u("/"), 10, 1)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("CrawlP() took %v after a 100ms deadline", d)
+	}
+	if !stats.Stopped {
+		t.Errorf("CrawlP() = %+v, want Stopped", stats)
+	}
+	if keys := storedKeys(t, db); !slices.Contains(keys, "/") || slices.Contains(keys, "/slow/") {
+		t.Errorf("Stored %q, want / but not /slow/", keys)
+	}
+	if site.fetches("/c/") != 0 {
+		t.Errorf("Fetched /c/ after the deadline")
+	}
+}
+
+func TestCrawlErrorPaths(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/": `<a href="/abort/">1</a> <a href="/unwritable/">2</a> <a href="/noindex/">3</a>` +
+			`<a href="/missing/">4</a> <a href="/ok/">5</a>`,
+		"/unwritable/": `<a href="/behind/">Behind a failed write</a>`,
+		"/ok/":         "OK",
+		"/behind/":     "Behind",
+	})
+	site.handle("/abort/", abort)
+	site.handle("/noindex/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Robots-Tag", "noindex")
+		fmt.Fprint(w, `<a href="/ok/">OK</a>`)
+	})
+	for _, parallel := range []int{1, 3} {
+		c, mem := newTestCrawler(site)
+		c.RespectRobotsTag = true
+		c.db = &failingStorage{mem, func(k string) bool { return k == "/unwritable/" }}
+		done := make(chan *CrawlStats)
+		go func() { done <- c.CrawlP(context.Background(), site.u("/"), 100, parallel) }()
+		var stats *CrawlStats
+		select {
+		case stats = <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("CrawlP() with %d in parallel did not finish", parallel)
+		}
+		want := []string{"/", "/abort/", "/behind/", "/missing/", "/noindex/", "/ok/", "/unwritable/"}
+		if !slices.Equal(stats.Visited, want) || stats.Errors != 2 {
+			t.Errorf("CrawlP() with %d in parallel = %+v, want %q visited with 2 errors", parallel, stats, want)
+		}
+	}
+}
+
+func TestTruncatedPage(t *testing.T) {
+	site := newTestSite(t, nil)
+	full := `<html><body><p>The whole page.</p><a href="/next/">Next</a></body></html>`
+	// cutOff closes the connection mid-body the first `times` times a path is
+	// requested, with or without a Content-Length.
+	cutOff := func(path string, times int, chunked bool) {
+		site.handle(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			if !chunked {
+				w.Header().Set("Content-Length", strconv.Itoa(len(full)))
+			}
+			if site.fetches(path) > times {
+				io.WriteString(w, full)
+				return
+			}
+			io.WriteString(w, full[:20])
+			w.(http.Flusher).Flush()
+			abort(w, r)
+		})
+	}
+	cutOff("/once/", 1, false)
+	cutOff("/chunked/", 1, true)
+	cutOff("/always/", 100, false)
+
+	c, db := newTestCrawler(site)
+	for _, p := range []string{"/once/", "/chunked/"} {
+		if err := c.CrawlOne(context.Background(), site.u(p)); err != nil {
+			t.Errorf("CrawlOne(%s) = %v", p, err)
+		}
+		if got := site.fetches(p); got != 2 {
+			t.Errorf("Fetched %s %d times, want 2", p, got)
+		}
+		if r, err := db.Read(p); err != nil || !strings.Contains(string(r.GetContent()), "The whole page.") {
+			t.Errorf("Read(%s) = %v, %v, want the whole page", p, r, err)
+		}
+	}
+
+	err := c.CrawlOne(context.Background(), site.u("/always/"))
+	if !errors.Is(err, errTruncated) {
+		t.Errorf("CrawlOne(/always/) = %v, want errTruncated", err)
+	}
+	if got, want := site.fetches("/always/"), MAX_TRUNCATED_RETRIES+1; got != want {
+		t.Errorf("Fetched /always/ %d times, want %d", got, want)
+	}
+	if ok, _ := db.Exists("/always/"); ok {
+		t.Errorf("Stored a truncated page")
+	}
+}
+
+func TestRedirectToBareRoot(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/old/">Old</a>`})
+	site.redirect("/old/", site.URL)
+	c, db := newTestCrawler(site)
+	bare := site.u("")
+	c.CrawlP(context.Background(), bare, 10, 1)
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/", "/old/"}) {
+		t.Errorf("Stored %q, want / and /old/", keys)
+	}
+	if r, err := db.Read("/old/"); err != nil || r.GetRedirect() != "/" {
+		t.Errorf("Read(/old/) = %v, %v, want a redirect to /", r, err)
+	}
+	if n := site.fetches("/"); n != 1 {
+		t.Errorf("Fetched / %d times, want 1", n)
+	}
+
+	// Raw content is fetched through redirects too.
+	c, db = newTestCrawler(site)
+	if _, err := c.saveRaw(site.u("/old/"), nil); err != nil {
+		t.Fatalf("saveRaw() = %v", err)
+	}
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/", "/old/"}) {
+		t.Errorf("saveRaw() stored %q, want / and /old/", keys)
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/old/?p=1")
+	for _, tc := range []struct{ loc, want string }{
+		{"https://example.com/new/", "https://example.com/new/"},
+		{"/new-path", "https://example.com/new-path"},
+		{"newer/", "https://example.com/blog/old/newer/"},
+		{"//cdn.example.net/x", "https://cdn.example.net/x"},
+		{" /padded/ ", "https://example.com/padded/"},
+	} {
+		got, err := parseLocation(*base, tc.loc)
+		if err != nil || got.String() != tc.want {
+			t.Errorf("parseLocation(%q) = %v, %v, want %q", tc.loc, got, err, tc.want)
+		}
+	}
+	for _, loc := range []string{"", "http://[::1", "mailto:me@example.com"} {
+		if got, err := parseLocation(*base, loc); err == nil {
+			t.Errorf("parseLocation(%q) = %v, want an error", loc, got)
+		}
+	}
+}
+
+func TestRelativeLocation(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":     `<a href="/abs/">Abs</a> <a href="/rel/">Rel</a>`,
+		"/new/": "New", "/newer/": "Newer",
+	})
+	site.redirect("/abs/", site.URL+"/new/")
+	site.redirect("/rel/", "/newer/")
+	c, db := newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	for k, want := range map[string]string{"/abs/": "/new/", "/rel/": "/newer/"} {
+		if r, err := db.Read(k); err != nil || r.GetRedirect() != want {
+			t.Errorf("Read(%q) = %v, %v, want a redirect to %q", k, r, err, want)
+		}
+		if _, err := db.Read(want); err != nil {
+			t.Errorf("Redirect target %q not stored: %v", want, err)
+		}
+	}
+}
+
+func TestOffsiteRedirectLocation(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/cdn/">CDN</a> <a href="/spaced/">Spaced</a>`})
+	for path, loc := range map[string]string{"/cdn/": "//cdn.example.com/x", "/spaced/": " https://example.net/y "} {
+		site.handle(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header()["Location"] = []string{loc}
+			w.WriteHeader(http.StatusMovedPermanently)
+		})
+	}
+	c, db := newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	// Stored resolved against the page, as absolute web URLs.
+	for k, want := range map[string]string{"/cdn/": "http://cdn.example.com/x", "/spaced/": "https://example.net/y"} {
+		if r, err := db.Read(k); err != nil || r.GetRedirect() != want {
+			t.Errorf("Read(%q) = %v, %v, want a redirect to %q", k, r, err, want)
+		}
+	}
+}
+
+func TestRedirectLoop(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/a/">A</a>`})
+	site.redirect("/a/", "/b/")
+	site.redirect("/b/", "/a/")
+	c, _ := newTestCrawler(site)
+	if l, resp := c.followRedirects(site.u("/a/")); l != nil || resp != nil {
+		t.Errorf("followRedirects() = %v, %v, want nothing", l, resp)
+	}
+	if a, b := site.fetches("/a/"), site.fetches("/b/"); a != 1 || b != 1 {
+		t.Errorf("Fetched /a/ %d times and /b/ %d times, want once each", a, b)
+	}
+
+	// A crawl fetches each once too.
+	c, _ = newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/"), 100, 1)
+	if a, b := site.fetches("/a/"), site.fetches("/b/"); a != 2 || b != 2 {
+		t.Errorf("Crawl fetched /a/ %d times and /b/ %d times, want once each", a-1, b-1)
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/r0/">Start</a>`, "/end/": "End"})
+	for i := 0; i < 5; i++ {
+		site.redirect(fmt.Sprintf("/r%d/", i), fmt.Sprintf("/r%d/", i+1))
+	}
+	site.redirect("/r5/", "/end/")
+
+	// followRedirects gives up after the 3rd redirect.
+	c, db := newTestCrawler(site)
+	c.MaxRedirects = 3
+	if l, resp := c.followRedirects(site.u("/r0/")); l != nil || resp != nil {
+		t.Errorf("followRedirects() = %v, %v, want nothing", l, resp)
+	}
+	if got, want := storedKeys(t, db), []string{"/r0/", "/r1/", "/r2/"}; !slices.Equal(got, want) {
+		t.Errorf("followRedirects() stored %q, want %q", got, want)
+	}
+	if n := site.fetches("/r4/"); n != 0 {
+		t.Errorf("Fetched /r4/ %d times", n)
+	}
+
+	// CrawlP counts the redirects it queues in the same way.
+	c, db = newTestCrawler(site)
+	c.MaxRedirects = 3
+	c.CrawlP(context.Background(), site.u("/"), 100, 1)
+	if got, want := storedKeys(t, db), []string{"/", "/r0/", "/r1/", "/r2/"}; !slices.Equal(got, want) {
+		t.Errorf("CrawlP() stored %q, want %q", got, want)
+	}
+	if n := site.fetches("/r4/"); n != 0 {
+		t.Errorf("CrawlP() fetched /r4/ %d times", n)
+	}
+
+	// With the default limit, the chain is followed to its end.
+	c, db = newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/"), 100, 1)
+	if ok, _ := db.Exists("/end/"); !ok {
+		t.Errorf("CrawlP() did not follow the chain to /end/ with the default limit")
+	}
+}
+
+func TestBasePathRedirects(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/old/">Old</a>`, "/new/": "New"})
+	site.redirect("/old/", "/new/")
+	site.redirect("/gone/", "https://example.net/")
+
+	// Stored redirects point at their targets under the BasePath, whether
+	// queued by a crawl or followed directly.
+	c, db := newTestCrawler(site)
+	c.BasePath = "/mirror"
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	if r, err := db.Read("/old/"); err != nil || r.GetRedirect() != "/mirror/new/" {
+		t.Errorf("CrawlP() stored redirect %v, %v, want one to /mirror/new/", r, err)
+	}
+
+	c, db = newTestCrawler(site)
+	c.BasePath = "/mirror"
+	l, resp := c.followRedirects(site.u("/old/"))
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if l == nil || l.Path != "/new/" {
+		t.Errorf("followRedirects() = %v, want the target /new/", l)
+	}
+	if r, err := db.Read("/old/"); err != nil || r.GetRedirect() != "/mirror/new/" {
+		t.Errorf("followRedirects() stored redirect %v, %v, want one to /mirror/new/", r, err)
+	}
+	// Off-site targets are left as they are.
+	c.followRedirects(site.u("/gone/"))
+	if r, err := db.Read("/gone/"); err != nil || r.GetRedirect() != "https://example.net/" {
+		t.Errorf("followRedirects() stored redirect %v, %v, want one to https://example.net/", r, err)
+	}
+}
+
+func TestFormActions(t *testing.T) {
+	in := `<form action="https://example.com/search/?lang=en" method="get"></form>` +
+		`<form action="https://forms.example.net/subscribe"></form>`
+	for _, tc := range []struct {
+		mode FormAction
+		want string
+	}{
+		{DefangForms, `<form action="#" method="get">`},
+		{RelativizeForms, `<form action="/search/?lang=en" method="get">`},
+	} {
+		c := New("example.com", nil, nil)
+		c.quiet = true
+		c.FormActions = tc.mode
+		page, _ := staticate(t, c, in)
+		if !strings.Contains(page, tc.want) {
+			t.Errorf("FormActions %v: page lacks %s:\n%s", tc.mode, tc.want, page)
+		}
+		if !strings.Contains(page, `<form action="https://forms.example.net/subscribe">`) {
+			t.Errorf("FormActions %v: off-site form action changed:\n%s", tc.mode, page)
+		}
+	}
+}
+
+func TestNoQuerySort(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/api/?sig=abc&amp;b=2&amp;a=1&amp;a=0">API</a>`})
+	site.file("/api/", "application/json", "{}")
+	c, db := newTestCrawler(site)
+	c.NoQuerySort = true
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	want := "/api/?sig=abc&b=2&a=1&a=0"
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/", want}) {
+		t.Errorf("Stored %q, want / and %q", keys, want)
+	}
+	if site.fetches(want) != 1 {
+		t.Errorf("Did not fetch %q in its original order", want)
+	}
+	if u, _ := url.Parse(site.URL + want); !c.isSeen(*u) {
+		t.Errorf("%q not seen", want)
+	}
+	if u, _ := url.Parse(site.URL + "/api/?a=0&a=1&b=2&sig=abc"); c.isSeen(*u) {
+		t.Errorf("Reordered query is seen as the same URL")
+	}
+	if got := CanonicalKey(site.u(want), false); got != want {
+		t.Errorf("CanonicalKey(%q, false) = %q", want, got)
+	}
+}
+
+func TestPreloadLinks(t *testing.T) {
+	c := New("example.com", nil, nil)
+	c.quiet = true
+	in := `<link rel="preload" href="https://example.com/fonts/a.woff2" as="font" type="font/woff2" crossorigin>` +
+		`<link rel="modulepreload" href="https://example.com/js/app.mjs">` +
+		`<link rel="prefetch" href="https://example.com/next/">` +
+		`<link rel="preconnect" href="https://fonts.example.net">` +
+		`<link rel="dns-prefetch" href="https://example.com">` +
+		`<link rel="preload" href="https://cdn.example.net/x.js" as="script">`
+	page, links := staticate(t, c, in)
+	for _, s := range []string{
+		`<link rel="preload" href="/fonts/a.woff2" as="font" type="font/woff2" crossorigin=""/>`,
+		`<link rel="modulepreload" href="/js/app.mjs"/>`,
+		`<link rel="prefetch" href="/next/"/>`,
+		`<link rel="preconnect" href="https://fonts.example.net"/>`,
+		`<link rel="dns-prefetch" href="https://example.com"/>`,
+		`<link rel="preload" href="https://cdn.example.net/x.js" as="script"/>`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("Staticated page lacks %s:\n%s", s, page)
+		}
+	}
+	if len(links) != 0 {
+		t.Errorf("Links = %q, want none without CapturePreloads", links)
+	}
+
+	c.CapturePreloads = true
+	_, links = staticate(t, c, in)
+	want := []string{"https://example.com/fonts/a.woff2", "https://example.com/js/app.mjs", "https://example.com/next/"}
+	if !slices.Equal(links, want) {
+		t.Errorf("Links = %q, want %q", links, want)
+	}
+}
+
+func TestCrawlOne(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/landing/": `<a href="/other/">Other</a><img src="/logo.png">`,
+		"/other/":   "<p>Other</p>",
+	})
+	site.file("/logo.png", "image/png", "PNG")
+	c, db := newTestCrawler(site)
+	if err := c.CrawlOne(context.Background(), site.u("/landing/")); err != nil {
+		t.Fatal(err)
+	}
+	if site.total() != 1 || site.fetches("/landing/") != 1 {
+		t.Errorf("Made %d requests, want just one of /landing/", site.total())
+	}
+	keys := storedKeys(t, db)
+	if len(keys) != 1 || keys[0] != "/landing/" {
+		t.Fatalf("Stored %q, want just /landing/", keys)
+	}
+	r, _ := db.Read("/landing/")
+	// Links are still relativized, as in any crawl.
+	if !strings.Contains(string(r.Content), `<a href="/other/">`) {
+		t.Errorf("Stored page = %s", r.Content)
+	}
+
+	// A redirect is stored, not followed.
+	site.redirect("/moved/", "/landing/")
+	if err := c.CrawlOne(context.Background(), site.u("/moved/")); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := db.Read("/moved/"); err != nil || r.GetRedirect() != "/landing/" {
+		t.Errorf("Stored /moved/ = %v, %v, want a redirect to /landing/", r, err)
+	}
+	if site.fetches("/landing/") != 1 {
+		t.Errorf("Redirect target fetched again")
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	site := newTestSite(t, nil)
+	origin := site.u("/")
+	alias := "localhost:" + origin.Port()
+	site.page("/", `<a href="http://`+alias+`/a/">A</a><a href="`+site.URL+`/a/">A again</a><a href="http://`+alias+`/b/">B</a>`)
+	site.page("/a/", "<p>A</p>")
+	site.page("/b/", "<p>B</p>")
+	db := storage.NewMem()
+	c := New(origin.Hostname(), []string{alias}, db)
+	c.CanonicalHost = origin.Host
+
+	start := site.u("/")
+	start.Host = alias
+	c.CrawlP(context.Background(), start, 10, 1)
+	for _, p := range []string{"/", "/a/", "/b/"} {
+		if n := site.fetches(p); n != 1 {
+			t.Errorf("%s fetched %d times, want once", p, n)
+		}
+		r, err := db.Read(p)
+		if err != nil {
+			t.Errorf("Reading %s: %v", p, err)
+			continue
+		}
+		// Fetched from the canonical host, even when linked from the alias.
+		if want := site.URL + p; r.SourceUrl != want {
+			t.Errorf("%s source = %q, want %q", p, r.SourceUrl, want)
+		}
+	}
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/", "/a/", "/b/"}) {
+		t.Errorf("Stored %q, want one key per path", keys)
+	}
+}
+
+func TestKeepAbsoluteHosts(t *testing.T) {
+	c := New("example.com", []string{"app.example.com"}, nil)
+	c.quiet = true
+	c.KeepAbsoluteHosts = []string{"app.example.com"}
+	page, links := staticate(t, c, `<a href="https://example.com/about/">About</a>`+
+		`<a href="https://app.example.com/login/">Log in</a>`+
+		`<a href="https://APP.example.com/signup/">Sign up</a>`+
+		`<img src="https://app.example.com/avatar.png">`)
+	for _, s := range []string{
+		`<a href="/about/">`,
+		`<a href="https://app.example.com/login/">`,
+		`<a href="https://APP.example.com/signup/">`,
+		`<img src="https://app.example.com/avatar.png"/>`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("Staticated page lacks %s:\n%s", s, page)
+		}
+	}
+	// Nor are they crawled.
+	if want := []string{"https://example.com/about/"}; !slices.Equal(links, want) {
+		t.Errorf("Links = %q, want %q", links, want)
+	}
+}
+
+func TestCaptureAMP(t *testing.T) {
+	site := newTestSite(t, nil)
+	site.page("/post/", `<html><head><link rel="amphtml" href="`+site.URL+`/post/amp/"></head><body><p>Post</p></body></html>`)
+	site.page("/post/amp/", `<html amp><head><link rel="canonical" href="/post/"></head><body><p>AMP post</p></body></html>`)
+
+	c, db := newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/post/"), 10, 1)
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/post/"}) {
+		t.Errorf("Stored %q without CaptureAMP, want just the post", keys)
+	}
+
+	c, db = newTestCrawler(site)
+	c.CaptureAMP = true
+	c.CrawlP(context.Background(), site.u("/post/"), 10, 1)
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/post/", "/post/amp/"}) {
+		t.Errorf("Stored %q with CaptureAMP, want the post and its AMP variant", keys)
+	}
+	r, _ := db.Read("/post/")
+	if !strings.Contains(string(r.Content), `<link rel="amphtml" href="/post/amp/"/>`) {
+		t.Errorf("AMP link not relativized:\n%s", r.Content)
+	}
+}
+
+func TestStoreOriginal(t *testing.T) {
+	site := newTestSite(t, nil)
+	orig := `<html><body><a href="` + site.URL + `/about/">About</a></body></html>`
+	site.page("/", orig)
+	site.page("/about/", "<p>"+strings.Repeat("About us. ", 100)+"</p>")
+	site.file("/logo.png", "image/png", "PNG")
+	c, db := newTestCrawler(site)
+	c.StoreOriginal = true
+	c.MaxOriginalSize = 500
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	if err := c.CrawlOne(context.Background(), site.u("/logo.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only pages are kept, and only those within MaxOriginalSize.
+	want := []string{"/", ORIGINAL_KEY_PREFIX + "/", "/about/", "/logo.png"}
+	if got := storedKeys(t, db); !slices.Equal(got, want) {
+		t.Errorf("Stored %q, want %q", got, want)
+	}
+	o, err := db.Read(ORIGINAL_KEY_PREFIX + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := db.Read("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(o.GetContent()) != orig {
+		t.Errorf("Stored original %s, want %s", o.GetContent(), orig)
+	}
+	if string(o.GetContent()) == string(p.GetContent()) || !strings.Contains(string(p.GetContent()), `href="/about/"`) {
+		t.Errorf("Stored page is not the staticated original:\n%s", p.GetContent())
+	}
+}
+
+func TestMaxAssetAge(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": "<p>Home</p>"})
+	for _, p := range []string{"/fresh.png", "/old.png", "/new.png"} {
+		site.file(p, "image/png", "PNG")
+	}
+	c, db := newTestCrawler(site)
+	c.MaxAssetAge = 24 * time.Hour
+	now := time.Now()
+	for k, at := range map[string]time.Time{"/fresh.png": now.Add(-time.Hour), "/old.png": now.Add(-48 * time.Hour)} {
+		if err := db.Write(k, &resource.Resource{ContentType: "image/png", Content: []byte("PNG"), CrawledAt: timestamppb.New(at)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Write("/", &resource.Resource{ContentType: "text/html", Content: []byte("<p>Home</p>"), CrawledAt: timestamppb.New(now)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/", "/fresh.png", "/old.png", "/new.png"} {
+		if err := c.CrawlOne(context.Background(), site.u(p)); err != nil {
+			t.Fatalf("CrawlOne(%s) = %v", p, err)
+		}
+	}
+	// Pages are always fetched, as are assets stored too long ago or not at all.
+	for p, want := range map[string]int{"/": 1, "/fresh.png": 0, "/old.png": 1, "/new.png": 1} {
+		if got := site.fetches(p); got != want {
+			t.Errorf("Fetched %s %d times, want %d", p, got, want)
+		}
+	}
+	r, err := db.Read("/old.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if age := time.Since(r.GetCrawledAt().AsTime()); age > time.Hour {
+		t.Errorf("Refetched /old.png is stored as %v old", age)
+	}
+}
+
+// metadataStorage is a MemStorage which can read metadata alone, and counts
+// full reads and metadata reads by key.
+type metadataStorage struct {
+	*storage.MemStorage
+	reads, metadataReads map[string]int
+}
+
+func (s *metadataStorage) Read(k string) (*resource.Resource, error) {
+	s.reads[k]++
+	return s.MemStorage.Read(k)
+}
+
+func (s *metadataStorage) ReadMetadata(k string) (*resource.Resource, error) {
+	s.metadataReads[k]++
+	r, err := s.MemStorage.Read(k)
+	if r != nil {
+		r.Content = nil
+	}
+	return r, err
+}
+
+func TestMaxAssetAgeMetadata(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/page/": "<p>Page</p>"})
+	site.file("/logo.png", "image/png", "PNG")
+	db := &metadataStorage{MemStorage: storage.NewMem(), reads: map[string]int{}, metadataReads: map[string]int{}}
+	u := site.u("/")
+	c := New(u.Hostname(), nil, db)
+	c.MaxAssetAge = 24 * time.Hour
+	c.Manifest = NewContentManifest()
+	for _, p := range []string{"/page/", "/logo.png", "/page/", "/logo.png"} {
+		if err := c.CrawlOne(context.Background(), site.u(p)); err != nil {
+			t.Fatalf("CrawlOne(%s) = %v", p, err)
+		}
+	}
+	if n := site.fetches("/logo.png"); n != 1 {
+		t.Errorf("Fetched /logo.png %d times, want once", n)
+	}
+	// Pages aren't looked up, and assets' content isn't read.
+	if n := db.reads["/logo.png"] + db.reads["/page/"]; n != 0 {
+		t.Errorf("Read stored content %d times, want none", n)
+	}
+	if n := db.metadataReads["/logo.png"]; n == 0 {
+		t.Errorf("Did not read the metadata of /logo.png")
+	}
+
+	// Once the stored asset is too old, it is fetched again. Though it is
+	// unchanged, it is written again to refresh its crawl time, so it isn't
+	// fetched on every later crawl.
+	r, _ := db.MemStorage.Read("/logo.png")
+	r.CrawledAt = timestamppb.New(time.Now().Add(-48 * time.Hour))
+	if err := db.MemStorage.Write("/logo.png", r); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := c.CrawlOne(context.Background(), site.u("/logo.png")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := site.fetches("/logo.png"); n != 2 {
+		t.Errorf("Fetched /logo.png %d times, want twice", n)
+	}
+	if r, err := db.MemStorage.Read("/logo.png"); err != nil || time.Since(r.GetCrawledAt().AsTime()) > time.Hour {
+		t.Errorf("Refetched /logo.png stored as %v, %v, want it crawled just now", r, err)
+	}
+}
+
+func TestSamePageHrefs(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/page/": `<a href="">Here</a> <a href="#">Top</a> <a href="#section">Section</a>` +
+			`<a href="?q=1#frag">Query</a> <a href="/page/#section">Again</a>`,
+	})
+	c, db := newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/page/"), 10, 1)
+	if got, want := storedKeys(t, db), []string{"/page/", "/page/?q=1"}; !slices.Equal(got, want) {
+		t.Errorf("Stored %q, want %q", got, want)
+	}
+	if n := site.fetches("/page/"); n != 1 {
+		t.Errorf("Fetched /page/ %d times, want once", n)
+	}
+	r, err := db.Read("/page/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`href=""`, `href="#"`, `href="#section"`, `href="?q=1#frag"`, `href="/page/#section"`} {
+		if !strings.Contains(string(r.GetContent()), want) {
+			t.Errorf("Stored page lacks %s:\n%s", want, r.GetContent())
+		}
+	}
+}
+
+func TestFragmentLinks(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":      `<a href="/page#section">Section</a> <a href="/page">Page</a> <a href="/other#top">Other</a>`,
+		"/page":  "Page",
+		"/other": "Other",
+	})
+	c, db := newTestCrawler(site)
+	page, links := staticate(t, c, `<a href="/page#section">Section</a>`)
+	if !strings.Contains(page, `href="/page#section"`) || !slices.Equal(links, []string{"/page"}) {
+		t.Errorf("staticate() = %q, %q, want the fragment kept in the page but not the link", page, links)
+	}
+
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	if got, want := storedKeys(t, db), []string{"/", "/other", "/page"}; !slices.Equal(got, want) {
+		t.Errorf("Stored %q, want %q", got, want)
+	}
+	if n := site.fetches("/page"); n != 1 {
+		t.Errorf("Fetched /page %d times, want once", n)
+	}
+	r, err := db.Read("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`href="/page#section"`, `href="/page"`, `href="/other#top"`} {
+		if !strings.Contains(string(r.GetContent()), want) {
+			t.Errorf("Stored page lacks %s:\n%s", want, r.GetContent())
+		}
+	}
+}
+
+func TestElementURLAttrs(t *testing.T) {
+	in := `<amp-img src="https://example.com/a.jpg" srcset="https://example.com/a-300.jpg 300w, https://example.com/a.jpg 1024w" width="300" height="200"></amp-img>` +
+		`<my-player url="https://example.com/v.mp4" poster="https://example.com/v.jpg" title="https://example.com/"></my-player>` +
+		`<amp-img src="https://cdn.example.net/b.jpg"></amp-img>` +
+		`<other-element url="https://example.com/x/"></other-element>`
+	c := New("example.com", nil, nil)
+	c.quiet = true
+	page, _ := staticate(t, c, in)
+	if !strings.Contains(page, `<amp-img src="https://example.com/a.jpg"`) {
+		t.Errorf("Relativized <amp-img> without ElementURLAttrs:\n%s", page)
+	}
+
+	attrs, err := ParseElementURLAttrs("My-Player:URL, my-player:poster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ElementURLAttrs = attrs
+	page, _ = staticate(t, c, in)
+	for _, want := range []string{
+		`<amp-img src="/a.jpg" srcset="/a-300.jpg 300w,/a.jpg 1024w" width="300" height="200">`,
+		// Only the listed attributes are URLs.
+		`<my-player url="/v.mp4" poster="/v.jpg" title="https://example.com/">`,
+		`<amp-img src="https://cdn.example.net/b.jpg">`,
+		`<other-element url="https://example.com/x/">`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Staticated page lacks %s:\n%s", want, page)
+		}
+	}
+	if _, err := ParseElementURLAttrs("my-player"); err == nil {
+		t.Errorf("ParseElementURLAttrs() without an attribute succeeded")
+	}
+}
+
+// syncingStorage is a MemStorage which counts syncs, failing them with err.
+type syncingStorage struct {
+	*storage.MemStorage
+	syncs int
+	err   error
+}
+
+func (s *syncingStorage) Sync() error {
+	s.syncs++
+	return s.err
+}
+
+func TestSyncOnCompletion(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/a/">A</a>`, "/a/": "A"})
+	u := site.u("/")
+
+	// Written to bbolt, and readable as soon as the crawl returns.
+	path := filepath.Join(t.TempDir(), "site.db")
+	db := storage.New("bbolt:" + path + ":polyester")
+	New(u.Hostname(), nil, db).CrawlP(context.Background(), u, 10, 2)
+	db.Close()
+	ro := storage.New("bbolt:" + path + ":polyester:ro")
+	defer ro.Close()
+	if got := storedKeys(t, ro); !slices.Equal(got, []string{"/", "/a/"}) {
+		t.Errorf("Reopened database holds %q", got)
+	}
+
+	// Including when stopped early, e.g. by a signal.
+	s := &syncingStorage{MemStorage: storage.NewMem()}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	New(u.Hostname(), nil, s).CrawlP(ctx, u, 10, 1)
+	if s.syncs != 1 {
+		t.Errorf("Synced %d times after a cancelled crawl, want once", s.syncs)
+	}
+
+	// A failed sync is a crawl error.
+	s = &syncingStorage{MemStorage: storage.NewMem(), err: errDiskFull}
+	stats := New(u.Hostname(), nil, s).CrawlP(context.Background(), u, 10, 1)
+	if s.syncs != 1 || stats.Errors != 1 {
+		t.Errorf("CrawlP() with a failing sync = %+v after %d syncs, want 1 error", stats, s.syncs)
+	}
+}
@@ -0,0 +1,126 @@
+package crawler
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Matches a CSS url() reference, quoted or not.
+var cssURLRE = regexp.MustCompile(`url\(\s*("[^"]*"|'[^']*'|[^)\s]*)\s*\)`)
+
+// Matches the start of an image-set(), whose candidates may be plain strings
+// rather than url()s.
+var cssImageSetRE = regexp.MustCompile(`(?i)(?:-webkit-)?image-set\(`)
+
+// Matches a candidate in the body of an image-set(): a url() or a plain
+// string, along with any type() it is the argument of, which is not a URL.
+var cssImageSetURLRE = regexp.MustCompile(`(type\(\s*)?(url\(\s*(?:"[^"]*"|'[^']*'|[^)\s]*)\s*\)|"[^"]*"|'[^']*')`)
+
+func isCSSContentType(s string) bool {
+	t, _, _ := strings.Cut(s, ";")
+	return strings.TrimSpace(t) == "text/css"
+}
+
+// cssLocalURL relativizes a single URL from a stylesheet, which may be
+// quoted, if it is absolute and local. With a BasePath, root-relative URLs
+// are prefixed too. CSS in a page is relativized along with the page, so not
+// until it is served with DeferRelativize.
+func (c *Crawler) cssLocalURL(s string, inPage bool) string {
+	quote := ""
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') {
+		quote, s = s[:1], s[1:len(s)-1]
+	}
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Host == "" && !c.needsBasePath(u)) || !isWebScheme(u) || !c.isLocal(*u) {
+		return quote + s + quote
+	}
+	if inPage {
+		c.relativizeLocal(u)
+	} else {
+		// Stylesheets aren't relativized when served.
+		relativize(u)
+		c.addBasePath(u)
+	}
+	return quote + u.String() + quote
+}
+
+// cssURLFunc returns a function relativizing the URL in a url() reference.
+func (c *Crawler) cssURLFunc(inPage bool) func(string) string {
+	return func(ref string) string {
+		m := cssURLRE.FindStringSubmatchIndex(ref)
+		return ref[:m[2]] + c.cssLocalURL(ref[m[2]:m[3]], inPage) + ref[m[3]:]
+	}
+}
+
+// rewriteCSS relativizes absolute local URLs in a stylesheet, or in CSS in a
+// page if inPage: url() references, and the candidates of image-set() and
+// -webkit-image-set(), keeping their resolution and type() descriptors.
+func (c *Crawler) rewriteCSS(css string, inPage bool) string {
+	urlFunc := c.cssURLFunc(inPage)
+	var b strings.Builder
+	for {
+		loc := cssImageSetRE.FindStringIndex(css)
+		if loc == nil {
+			break
+		}
+		end := matchingParen(css, loc[1])
+		b.WriteString(cssURLRE.ReplaceAllStringFunc(css[:loc[1]], urlFunc))
+		body := cssImageSetURLRE.ReplaceAllStringFunc(css[loc[1]:end], func(cand string) string {
+			if strings.HasPrefix(cand, "type(") {
+				return cand
+			}
+			if strings.HasPrefix(cand, "url(") {
+				return urlFunc(cand)
+			}
+			return c.cssLocalURL(cand, inPage)
+		})
+		b.WriteString(body)
+		css = css[end:]
+	}
+	b.WriteString(cssURLRE.ReplaceAllStringFunc(css, urlFunc))
+	return b.String()
+}
+
+// matchingParen returns the index of the ")" closing the parenthesis opened
+// just before css[start], skipping nested parentheses and quoted strings, or
+// len(css) if there is none.
+func matchingParen(css string, start int) int {
+	depth := 1
+	var quote byte
+	for i := start; i < len(css); i++ {
+		switch ch := css[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+// rewriteStyle rewrites the CSS in a <style> element or a style attribute.
+func (c *Crawler) rewriteStyle(n *html.Node) {
+	if a := getAttr(n, "style"); a != nil {
+		a.Val = c.rewriteCSS(a.Val, true)
+	}
+	if n.Type != html.ElementNode || n.Data != "style" {
+		return
+	}
+	for x := n.FirstChild; x != nil; x = x.NextSibling {
+		if x.Type == html.TextNode {
+			x.Data = c.rewriteCSS(x.Data, true)
+		}
+	}
+}
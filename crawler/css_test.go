@@ -0,0 +1,86 @@
+package crawler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/TheSnook/polyester/storage"
+)
+
+func TestRewriteCSSImageSet(t *testing.T) {
+	c := New("example.com", nil, nil)
+	for _, tc := range []struct{ in, want string }{
+		{
+			`.hero { background-image: image-set(url("https://example.com/a.png") 1x, url(https://example.com/a@2x.png) 2x); }`,
+			`.hero { background-image: image-set(url("/a.png") 1x, url(/a@2x.png) 2x); }`,
+		},
+		{
+			`.hero { background-image: -webkit-image-set("https://example.com/a.png" 1x, 'https://example.com/a@2x.png' 2x); }`,
+			`.hero { background-image: -webkit-image-set("/a.png" 1x, '/a@2x.png' 2x); }`,
+		},
+		{
+			// type() arguments are not URLs, and off-site candidates are kept.
+			`a { b: image-set("https://example.com/a.avif" type("image/avif") 1x, url(https://cdn.example.net/a.png) 2dppx) url(https://example.com/c.png); }`,
+			`a { b: image-set("/a.avif" type("image/avif") 1x, url(https://cdn.example.net/a.png) 2dppx) url(/c.png); }`,
+		},
+	} {
+		if got := c.rewriteCSS(tc.in, false); got != tc.want {
+			t.Errorf("rewriteCSS(%s)\n = %s\nwant %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestRewriteLargeStylesheet(t *testing.T) {
+	site := newTestSite(t, nil)
+	rule := `.hero { background: image-set(url("` + site.URL + `/a.png") 1x, url("` + site.URL + `/a@2x.png") 2x); }` + "\n"
+	css := strings.Repeat("/* padding */\n", STREAM_MIN_SIZE/14) + rule
+	site.file("/style.css", "text/css", css)
+	// Large enough to be streamed, were it not rewritten.
+	u := site.u("/")
+	db := &streamingStorage{MemStorage: storage.NewMem()}
+	c := New(u.Hostname(), nil, db)
+	c.RewriteCSS = true
+	if err := c.CrawlOne(context.Background(), site.u("/style.css")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Read("/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `image-set(url("/a.png") 1x, url("/a@2x.png") 2x)`; !strings.Contains(string(r.GetContent()), want) {
+		t.Errorf("Stored stylesheet does not contain %s", want)
+	}
+}
+
+func TestRewriteCSSDeferred(t *testing.T) {
+	site := newTestSite(t, nil)
+	site.file("/style.css", "text/css", `.a { background: url(`+site.URL+`/a.png); }`)
+	site.page("/", `<html><head><style>.b { background: url(`+site.URL+`/b.png); }</style></head>`+
+		`<body><p style="background: url('`+site.URL+`/c.png')">Hi</p></body></html>`)
+	u := site.u("/")
+	db := storage.NewMem()
+	c := New(u.Hostname(), nil, db)
+	c.quiet = true
+	c.RewriteCSS = true
+	c.DeferRelativize = true
+	for _, p := range []string{"/style.css", "/"} {
+		if err := c.CrawlOne(context.Background(), site.u(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stylesheets aren't relativized when served, so can't wait for it.
+	if r, err := db.Read("/style.css"); err != nil || !strings.Contains(string(r.GetContent()), "url(/a.png)") {
+		t.Errorf("Stylesheet not relativized: %v\n%s", err, r.GetContent())
+	}
+	// CSS in a page is left for serving, like the rest of it.
+	r, err := db.Read("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"url(" + site.URL + "/b.png)", "url(&#39;" + site.URL + "/c.png&#39;)"} {
+		if !strings.Contains(string(r.GetContent()), want) {
+			t.Errorf("Stored page does not contain %s:\n%s", want, r.GetContent())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/net/html"
+)
+
+// RelativizeOptions are the crawl options which RelativizePage applies, as
+// they were when the page was crawled.
+type RelativizeOptions struct {
+	Aliases           []string // Hosts which are local, besides the page's own.
+	PublishDomain     string   // Replaces the origin in URLs which must stay absolute.
+	KeepAbsoluteHosts []string // Hosts never treated as local.
+	StripIntegrity    bool     // Remove integrity attributes of local resources.
+	RewriteCSS        bool     // Relativize URLs in <style> elements and style attributes.
+	// URL attributes of AMP and custom elements, as for the crawler's
+	// ElementURLAttrs.
+	ElementURLAttrs map[string][]string
+}
+
+// RelativizePage relativizes local URLs in the HTML of a page crawled with
+// DeferRelativize, and points those which must stay absolute at the
+// PublishDomain, if set, as the crawler would otherwise have done. URLs on
+// the host of the page's source URL, or on any of the aliases, are local.
+func RelativizePage(content []byte, sourceURL string, opts RelativizeOptions) ([]byte, error) {
+	u, err := url.Parse(sourceURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("page has no usable source URL %q", sourceURL)
+	}
+	c := &Crawler{
+		origin:            u.Hostname(),
+		aliases:           opts.Aliases,
+		PublishDomain:     opts.PublishDomain,
+		KeepAbsoluteHosts: opts.KeepAbsoluteHosts,
+		StripIntegrity:    opts.StripIntegrity,
+		RewriteCSS:        opts.RewriteCSS,
+		ElementURLAttrs:   opts.ElementURLAttrs,
+		// Local forms which were to be defanged already have been.
+		FormActions: RelativizeForms,
+		quiet:       true,
+	}
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	c.staticateDoc(doc, u.Hostname())
+	out := new(bytes.Buffer)
+	if err := html.Render(out, doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
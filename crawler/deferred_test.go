@@ -0,0 +1,78 @@
+package crawler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelativizePage(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "staticate", "*.html"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("No fixtures: %v", err)
+	}
+	opts := RelativizeOptions{
+		Aliases:           []string{"old.example.net", "shop.example.com"},
+		PublishDomain:     "example.org",
+		KeepAbsoluteHosts: []string{"shop.example.com"},
+		StripIntegrity:    true,
+		RewriteCSS:        true,
+	}
+	opts.ElementURLAttrs, err = ParseElementURLAttrs("my-player:url,my-player:poster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup := func(c *Crawler) {
+		c.quiet = true
+		c.KeepAbsoluteHosts = opts.KeepAbsoluteHosts
+		c.StripIntegrity = opts.StripIntegrity
+		c.RewriteCSS = opts.RewriteCSS
+		c.ElementURLAttrs = opts.ElementURLAttrs
+	}
+	now := New("example.com", opts.Aliases, nil)
+	setup(now)
+	now.PublishDomain = opts.PublishDomain
+	later := New("example.com", opts.Aliases, nil)
+	setup(later)
+	later.DeferRelativize = true
+
+	// Along with the fixtures, a page using the options above.
+	pages := map[string]string{"options": `<html><head>` +
+		`<link rel="stylesheet" href="https://example.com/style.css" integrity="sha384-abc" crossorigin="anonymous">` +
+		`<link rel="canonical" href="https://example.com/page/">` +
+		`<style>.hero { background: url(https://example.com/hero.png); }</style></head><body>` +
+		`<p style="background: url('https://old.example.net/bg.png')">Hi</p>` +
+		`<a href="https://old.example.net/about/">About</a> <a href="https://shop.example.com/cart/">Cart</a>` +
+		`<img src="https://shop.example.com/logo.png">` +
+		`<amp-img src="https://example.com/a.jpg" srcset="https://example.com/a-300.jpg 300w, https://example.com/a.jpg 1024w"></amp-img>` +
+		`<my-player url="https://example.com/v.mp4" poster="https://old.example.net/v.jpg"></my-player></body></html>`}
+	for _, f := range fixtures {
+		in, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages[f] = string(in)
+	}
+	for f, in := range pages {
+		want, _ := staticate(t, now, in)
+		stored, _ := staticate(t, later, in)
+		got, err := RelativizePage([]byte(stored), "https://example.com/page/", opts)
+		if err != nil {
+			t.Fatalf("%s: RelativizePage() = %v", f, err)
+		}
+		if f == "options" && (strings.Contains(want, `"https://example.com/a.jpg"`) || strings.Contains(want, `"https://example.com/v.mp4"`)) {
+			t.Errorf("Element URL attributes not relativized when crawled:\n%s", want)
+		}
+		if f == "options" && !strings.Contains(stored, "https://example.com/hero.png") {
+			t.Errorf("CSS relativized when crawled with DeferRelativize:\n%s", stored)
+		}
+		if string(got) != want {
+			t.Errorf("%s: relativized when served as\n%s\nwant as when crawled\n%s", f, got, want)
+		}
+	}
+
+	if _, err := RelativizePage([]byte("<p>Hi</p>"), "/page/", opts); err == nil {
+		t.Errorf("RelativizePage() without a source host succeeded")
+	}
+}
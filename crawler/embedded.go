@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Matches the target of a CSS @import rule, with or without url().
+var cssImportRE = regexp.MustCompile(`@import\s+(?:url\(\s*)?["']?([^"')\s;]+)`)
+
+// nodeText returns the concatenated text children of a node, e.g. the body of
+// a <script> or <style> element.
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	for x := n.FirstChild; x != nil; x = x.NextSibling {
+		if x.Type == html.TextNode {
+			b.WriteString(x.Data)
+		}
+	}
+	return b.String()
+}
+
+// cssImportLinks extracts local URLs from @import rules in a stylesheet.
+func (c *Crawler) cssImportLinks(css string) []url.URL {
+	var links []url.URL
+	for _, m := range cssImportRE.FindAllStringSubmatch(css, -1) {
+		u, err := url.Parse(m[1])
+		if err != nil || !isWebScheme(u) || !c.isLocal(*u) {
+			continue
+		}
+		links = append(links, *u)
+	}
+	return links
+}
+
+// jsonLinks extracts local, crawlable URLs from string values anywhere in a
+// JSON document.
+func (c *Crawler) jsonLinks(data string) []url.URL {
+	var v any
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return nil
+	}
+	var links []url.URL
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for _, x := range v {
+				walk(x)
+			}
+		case []any:
+			for _, x := range v {
+				walk(x)
+			}
+		case string:
+			// Only absolute URLs, to avoid mistaking arbitrary strings for paths.
+			if !strings.HasPrefix(v, "http://") && !strings.HasPrefix(v, "https://") {
+				return
+			}
+			u, err := url.Parse(v)
+			if err != nil || !c.isLocal(*u) || !isDynamicPage(u) {
+				return
+			}
+			links = append(links, *u)
+		}
+	}
+	walk(v)
+	return links
+}
@@ -0,0 +1,25 @@
+package crawler
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEmbeddedLinks(t *testing.T) {
+	c := New("example.com", nil, nil)
+	c.quiet = true
+	in := `<script type="application/json" id="wp-api-settings">` +
+		`{"root":"https:\/\/example.com\/wp-json\/","nonce":"abc123","versionString":"wp\/v2\/",` +
+		`"logo":"https://example.com/logo.png","other":"https://other.example.net/page/"}</script>` +
+		`<style>@import url("https://example.com/theme/extra.css"); @import "https://cdn.example.net/x.css";</style>`
+
+	if _, links := staticate(t, c, in); len(links) != 0 {
+		t.Errorf("Links = %q without ExtractEmbeddedLinks, want none", links)
+	}
+	c.ExtractEmbeddedLinks = true
+	_, links := staticate(t, c, in)
+	want := []string{"https://example.com/wp-json/", "https://example.com/theme/extra.css"}
+	if !slices.Equal(links, want) {
+		t.Errorf("Links = %q, want %q", links, want)
+	}
+}
@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// Max example errors kept for each kind of error.
+	MAX_ERROR_SAMPLES = 5
+	// Max distinct kinds of error tracked. Any more are counted as "other".
+	MAX_ERROR_KINDS = 50
+)
+
+// ErrorSample is an error seen while crawling the URL stored under Key.
+type ErrorSample struct {
+	Key string
+	Err error
+}
+
+// ErrorGroup counts errors of one kind, with a few samples.
+type ErrorGroup struct {
+	Kind    string
+	Count   int
+	Samples []ErrorSample
+}
+
+// errorCollector aggregates fetch and storage errors from concurrent workers.
+// Memory use is bounded however many errors there are. The zero value is
+// ready to use.
+type errorCollector struct {
+	mu     sync.Mutex
+	groups map[string]*ErrorGroup
+}
+
+// errorKind classifies an error by its root cause, so that e.g. every
+// "connection refused" is grouped together, whatever the URL.
+func errorKind(err error) string {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			break
+		}
+		err = next
+	}
+	return fmt.Sprintf("%T: %v", err, err)
+}
+
+func (e *errorCollector) Add(key string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.groups == nil {
+		e.groups = map[string]*ErrorGroup{}
+	}
+	kind := errorKind(err)
+	g, ok := e.groups[kind]
+	if !ok && len(e.groups) >= MAX_ERROR_KINDS {
+		kind = "other"
+		g, ok = e.groups[kind]
+	}
+	if !ok {
+		g = &ErrorGroup{Kind: kind}
+		e.groups[kind] = g
+	}
+	g.Count++
+	if len(g.Samples) < MAX_ERROR_SAMPLES {
+		g.Samples = append(g.Samples, ErrorSample{Key: key, Err: err})
+	}
+}
+
+// Groups returns a copy of the errors collected, most frequent first.
+func (e *errorCollector) Groups() []ErrorGroup {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	gs := make([]ErrorGroup, 0, len(e.groups))
+	for _, g := range e.groups {
+		c := *g
+		c.Samples = append([]ErrorSample(nil), g.Samples...)
+		gs = append(gs, c)
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		if gs[i].Count != gs[j].Count {
+			return gs[i].Count > gs[j].Count
+		}
+		return gs[i].Kind < gs[j].Kind
+	})
+	return gs
+}
+
+func (e *errorCollector) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.groups = nil
+}
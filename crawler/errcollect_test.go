@@ -0,0 +1,89 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestErrorCollector(t *testing.T) {
+	var e errorCollector
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Add(fmt.Sprintf("/%d/", i), fmt.Errorf("writing: %w", errDiskFull))
+			e.Add(fmt.Sprintf("/%d/", i), errors.New(fmt.Sprint("kind ", i)))
+		}()
+	}
+	wg.Wait()
+
+	gs := e.Groups()
+	if len(gs) != 21 {
+		t.Fatalf("Got %d kinds of error, want 21", len(gs))
+	}
+	if g := gs[0]; g.Count != 20 || !strings.Contains(g.Kind, "disk full") || len(g.Samples) != MAX_ERROR_SAMPLES {
+		t.Errorf("Most frequent error = %q x %d with %d samples, want disk full x 20 with %d", g.Kind, g.Count, len(g.Samples), MAX_ERROR_SAMPLES)
+	}
+
+	// Past MAX_ERROR_KINDS, new kinds are lumped together.
+	for i := range MAX_ERROR_KINDS {
+		e.Add("", errors.New(fmt.Sprint("more ", i)))
+	}
+	gs = e.Groups()
+	if len(gs) != MAX_ERROR_KINDS+1 {
+		t.Errorf("Got %d kinds of error, want %d", len(gs), MAX_ERROR_KINDS+1)
+	}
+	var other int
+	for _, g := range gs {
+		if g.Kind == "other" {
+			other = g.Count
+		}
+	}
+	// With 21 kinds already, all but the first MAX_ERROR_KINDS-21 new ones.
+	if want := 21; other != want {
+		t.Errorf("Got %d other errors, want %d", other, want)
+	}
+
+	e.Reset()
+	if gs := e.Groups(); len(gs) != 0 {
+		t.Errorf("Groups() after Reset() = %v, want none", gs)
+	}
+}
+
+// TestConcurrentWriteErrors is meant for -race: many workers failing writes at
+// once must neither race nor stop the crawl.
+func TestConcurrentWriteErrors(t *testing.T) {
+	const n = 40
+	var links strings.Builder
+	pages := map[string]string{}
+	for i := range n {
+		fmt.Fprintf(&links, `<a href="/p%d/">%d</a>`, i, i)
+		pages[fmt.Sprintf("/p%d/", i)] = "<p>Page</p>"
+	}
+	pages["/"] = links.String()
+	site := newTestSite(t, pages)
+	c, mem := newTestCrawler(site)
+	c.db = &failingStorage{mem, func(k string) bool { return strings.HasPrefix(k, "/p") }}
+
+	stats := c.CrawlP(context.Background(), site.u("/"), 1000, 16)
+	if stats.Fetched != n+1 {
+		t.Errorf("Fetched = %d, want %d", stats.Fetched, n+1)
+	}
+	if len(stats.ErrorKinds) != 1 {
+		t.Fatalf("ErrorKinds = %+v, want one kind", stats.ErrorKinds)
+	}
+	g := stats.ErrorKinds[0]
+	if g.Count != n || !strings.Contains(g.Kind, "disk full") || len(g.Samples) != MAX_ERROR_SAMPLES {
+		t.Errorf("Errors = %q x %d with %d samples, want disk full x %d with %d", g.Kind, g.Count, len(g.Samples), n, MAX_ERROR_SAMPLES)
+	}
+	for _, s := range g.Samples {
+		if !strings.HasPrefix(s.Key, "/p") || !errors.Is(s.Err, errDiskFull) {
+			t.Errorf("Sample %q: %v, want a failed page write", s.Key, s.Err)
+		}
+	}
+}
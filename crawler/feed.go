@@ -0,0 +1,181 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Elements of RSS 2.0 and Atom feeds whose text is a URL.
+var feedURLElements = map[string]bool{
+	"comments":   true, // RSS
+	"commentRss": true, // RSS wfw: extension
+	"guid":       true, // RSS
+	"icon":       true, // Atom
+	"id":         true, // Atom
+	"link":       true, // RSS
+	"logo":       true, // Atom
+	"uri":        true, // Atom
+	"url":        true, // RSS <image>
+}
+
+// Attributes of feed elements which are URLs, e.g. Atom <link href=...> and
+// RSS <enclosure url=...>.
+var feedURLAttrs = map[string]bool{
+	"href": true,
+	"url":  true,
+}
+
+func isFeedContentType(s string) bool {
+	t, _, _ := strings.Cut(s, ";")
+	t = strings.TrimSpace(t)
+	return t == "application/rss+xml" || t == "application/atom+xml"
+}
+
+// feedURL rewrites a local URL in a feed to point at the publish domain, or
+// failing that, relativizes it.
+func (c *Crawler) feedURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || !isWebScheme(u) || !c.isLocal(*u) {
+		return s
+	}
+	if c.PublishDomain != "" {
+		u.Host = c.PublishDomain
+	} else {
+		relativize(u)
+	}
+	c.addBasePath(u)
+	return u.String()
+}
+
+// feedEdit replaces [start, end) of a token's source with s.
+type feedEdit struct {
+	start, end int
+	s          string
+}
+
+// rewriteFeed rewrites local URLs in an RSS or Atom feed. The feed is edited
+// in place rather than re-encoded, so that namespaces and formatting survive.
+func (c *Crawler) rewriteFeed(body []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	// Only ASCII URLs are rewritten, so other encodings can be read as is.
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+
+	var out bytes.Buffer
+	var stack []string
+	last := 0
+	for {
+		start := int(dec.InputOffset())
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		end := int(dec.InputOffset())
+		seg := string(body[start:end])
+		// Replacements within seg, which may hold several rewritten attributes.
+		var edits []feedEdit
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			pos := 0
+			for _, a := range t.Attr {
+				vs, ve, ok := attrValueSpan(seg, pos, a.Name)
+				if !ok {
+					break
+				}
+				pos = ve
+				if !feedURLAttrs[a.Name.Local] {
+					continue
+				}
+				if rewritten := c.feedURL(a.Value); rewritten != a.Value {
+					edits = append(edits, feedEdit{vs, ve, escapeXML(rewritten)})
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) == 0 || !feedURLElements[stack[len(stack)-1]] {
+				break
+			}
+			old := strings.TrimSpace(string(t))
+			rewritten := c.feedURL(old)
+			if rewritten == old {
+				break
+			}
+			if i := strings.Index(seg, escapeXML(old)); i >= 0 {
+				edits = append(edits, feedEdit{i, i + len(escapeXML(old)), escapeXML(rewritten)})
+			} else if i := strings.Index(seg, old); i >= 0 {
+				// E.g. in a CDATA section.
+				edits = append(edits, feedEdit{i, i + len(old), rewritten})
+			}
+		}
+		if len(edits) == 0 {
+			continue
+		}
+		slices.SortFunc(edits, func(a, b feedEdit) int { return a.start - b.start })
+		out.Write(body[last:start])
+		pos := 0
+		for _, e := range edits {
+			out.WriteString(seg[pos:e.start])
+			out.WriteString(e.s)
+			pos = e.end
+		}
+		out.WriteString(seg[pos:])
+		last = end
+	}
+	out.Write(body[last:])
+	return out.Bytes(), nil
+}
+
+// attrValueSpan returns the span of the value of attribute name in the raw
+// start tag seg, looking from offset pos on, excluding the quotes.
+func attrValueSpan(seg string, pos int, name xml.Name) (start, end int, ok bool) {
+	n := name.Local
+	if name.Space != "" {
+		n = name.Space + ":" + n
+	}
+	for {
+		i := strings.Index(seg[pos:], n)
+		if i < 0 {
+			return 0, 0, false
+		}
+		i += pos
+		pos = i + len(n)
+		if i == 0 || !isXMLSpace(seg[i-1]) {
+			continue
+		}
+		rest := strings.TrimLeftFunc(seg[pos:], unicode.IsSpace)
+		if !strings.HasPrefix(rest, "=") {
+			continue
+		}
+		rest = strings.TrimLeftFunc(rest[1:], unicode.IsSpace)
+		if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+			continue
+		}
+		start = len(seg) - len(rest) + 1
+		end = strings.IndexByte(seg[start:], rest[0])
+		if end < 0 {
+			return 0, 0, false
+		}
+		return start, start + end, true
+	}
+}
+
+func isXMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRewriteRSSFeed(t *testing.T) {
+	c := New("example.com", nil, nil)
+	in := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+  <title>Example</title>
+  <link>https://example.com/</link>
+  <image><url>https://www.example.com/logo.png</url></image>
+  <item>
+    <title>Post &amp; more</title>
+    <link>https://example.com/post/?a=1&amp;b=2</link>
+    <guid isPermaLink="true"><![CDATA[https://example.com/post/]]></guid>
+    <comments>https://elsewhere.org/post/</comments>
+    <enclosure url="https://example.com/a.mp3" length="1" type="audio/mpeg"/>
+    <media:content url="https://example.com/a.jpg" href='https://example.com/b.jpg' medium="image"/>
+  </item>
+</channel>
+</rss>`
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+  <title>Example</title>
+  <link>/</link>
+  <image><url>/logo.png</url></image>
+  <item>
+    <title>Post &amp; more</title>
+    <link>/post/?a=1&amp;b=2</link>
+    <guid isPermaLink="true"><![CDATA[/post/]]></guid>
+    <comments>https://elsewhere.org/post/</comments>
+    <enclosure url="/a.mp3" length="1" type="audio/mpeg"/>
+    <media:content url="/a.jpg" href='/b.jpg' medium="image"/>
+  </item>
+</channel>
+</rss>`
+	got, err := c.rewriteFeed([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("rewriteFeed() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRewriteAtomFeed(t *testing.T) {
+	c := New("example.com", nil, nil)
+	c.PublishDomain = "mirror.example.org"
+	in := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <id>https://example.com/</id>
+  <link rel="self" href="https://example.com/feed/atom/"/>
+  <icon>https://example.com/favicon.ico</icon>
+  <entry>
+    <id>tag:example.com,2024:post</id>
+    <link rel="alternate" type="text/html" href="https://example.com/post/"/>
+    <author><name>A</name><uri>https://example.com/author/a/</uri></author>
+    <content type="html">&lt;a href="https://example.com/post/"&gt;</content>
+  </entry>
+</feed>`
+	want := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <id>https://mirror.example.org/</id>
+  <link rel="self" href="https://mirror.example.org/feed/atom/"/>
+  <icon>https://mirror.example.org/favicon.ico</icon>
+  <entry>
+    <id>tag:example.com,2024:post</id>
+    <link rel="alternate" type="text/html" href="https://mirror.example.org/post/"/>
+    <author><name>A</name><uri>https://mirror.example.org/author/a/</uri></author>
+    <content type="html">&lt;a href="https://example.com/post/"&gt;</content>
+  </entry>
+</feed>`
+	got, err := c.rewriteFeed([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("rewriteFeed() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCrawlFeed(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/": `<a href="/feed/">RSS</a>`,
+	})
+	site.file("/feed/", "application/rss+xml; charset=UTF-8",
+		`<rss version="2.0"><channel><link>`+site.URL+`/</link></channel></rss>`)
+	c, db := newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	r, err := db.Read(c.storageKey(site.u("/feed/")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<rss version="2.0"><channel><link>/</link></channel></rss>`; string(r.Content) != want {
+		t.Errorf("Stored feed = %s, want %s", r.Content, want)
+	}
+}
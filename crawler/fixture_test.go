@@ -0,0 +1,105 @@
+package crawler
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+var update = flag.Bool("update", false, "Rewrite golden files with the current output.")
+
+// linksHeader separates the rendered page from the links found in it in a
+// golden file.
+const linksHeader = "\n<!-- links -->\n"
+
+// staticate parses an HTML fixture, rewrites it as the crawler would on
+// fetching it from its origin, and returns the rendered result and the URLs
+// of the links it would follow.
+func staticate(t *testing.T, c *Crawler, content string) (string, []string) {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Parsing fixture: %v", err)
+	}
+	var links []string
+	for _, u := range c.staticateDoc(doc, c.origin) {
+		links = append(links, u.String())
+	}
+	out := new(bytes.Buffer)
+	if err := html.Render(out, doc); err != nil {
+		t.Fatalf("Rendering fixture: %v", err)
+	}
+	return out.String(), links
+}
+
+// checkGolden staticates each HTML fixture in dir, e.g. "page.html", and
+// compares the page and links with those in its golden file, "page.golden".
+// With -update, golden files are rewritten instead.
+func checkGolden(t *testing.T, c *Crawler, dir string) {
+	t.Helper()
+	fixtures, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("No fixtures in %q: %v", dir, err)
+	}
+	for _, f := range fixtures {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			in, err := os.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, links := staticate(t, c, string(in))
+			got := page + linksHeader + strings.Join(links, "\n") + "\n"
+			golden := strings.TrimSuffix(f, ".html") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("Output differs from %s:\n%s", golden, firstDiff(got, string(want)))
+			}
+		})
+	}
+}
+
+// firstDiff describes the first line at which got and want differ.
+func firstDiff(got, want string) string {
+	g, w := strings.Split(got, "\n"), strings.Split(want, "\n")
+	for i := 0; i < len(g) || i < len(w); i++ {
+		var gl, wl string
+		if i < len(g) {
+			gl = g[i]
+		}
+		if i < len(w) {
+			wl = w[i]
+		}
+		if gl != wl {
+			return fmt.Sprintf("line %d:\n  got:  %q\n  want: %q", i+1, gl, wl)
+		}
+	}
+	return "no difference"
+}
+
+func TestStaticateGolden(t *testing.T) {
+	c := New("example.com", []string{"old.example.net"}, nil)
+	c.quiet = true
+	checkGolden(t, c, "testdata/staticate")
+}
+
+func TestStaticateBasePathGolden(t *testing.T) {
+	c := New("example.com", nil, nil)
+	c.BasePath = "/mirror"
+	c.quiet = true
+	checkGolden(t, c, "testdata/staticate_base_path")
+}
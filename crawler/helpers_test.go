@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/TheSnook/polyester/storage"
+)
+
+func TestMain(m *testing.M) {
+	// Crawls log every URL.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testSite is an origin server for crawls in tests. Paths are served from a
+// map of handlers, and others are 404s. It counts requests by path and
+// query.
+type testSite struct {
+	*httptest.Server
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	requests map[string]int
+}
+
+// newTestSite starts a testSite serving each of pages as HTML.
+func newTestSite(t *testing.T, pages map[string]string) *testSite {
+	t.Helper()
+	s := &testSite{handlers: map[string]http.HandlerFunc{}, requests: map[string]int{}}
+	for p, body := range pages {
+		s.page(p, body)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testSite) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.RequestURI()]++
+	h, ok := s.handlers[r.URL.Path]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h(w, r)
+}
+
+// handle serves a path with a handler. Safe to call while serving.
+func (s *testSite) handle(path string, h http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[path] = h
+}
+
+// page serves a path as HTML.
+func (s *testSite) page(path, body string) {
+	s.file(path, "text/html; charset=utf-8", body)
+}
+
+// file serves a path with a content type.
+func (s *testSite) file(path, contentType, body string) {
+	s.handle(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, body)
+	})
+}
+
+// redirect serves a path as a 301 redirect to loc.
+func (s *testSite) redirect(path, loc string) {
+	s.handle(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", loc)
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+}
+
+// fetches returns how many times a path (with any query) was requested.
+func (s *testSite) fetches(uri string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[uri]
+}
+
+// total returns the number of requests made to the site.
+func (s *testSite) total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.requests {
+		n += c
+	}
+	return n
+}
+
+// u returns the absolute URL of a path on the site.
+func (s *testSite) u(path string) url.URL {
+	u, err := url.Parse(s.URL + path)
+	if err != nil {
+		panic(err)
+	}
+	return *u
+}
+
+// newTestCrawler returns a crawler of a testSite, storing in memory.
+func newTestCrawler(s *testSite) (*Crawler, *storage.MemStorage) {
+	db := storage.NewMem()
+	u := s.u("/")
+	return New(u.Hostname(), nil, db), db
+}
+
+// storedKeys returns the keys in db.
+func storedKeys(t *testing.T, db storage.Storage) []string {
+	t.Helper()
+	keys, err := db.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+var errDiskFull = errors.New("disk full")
+
+// failingStorage is a MemStorage whose writes fail for keys matching fail.
+type failingStorage struct {
+	*storage.MemStorage
+	fail func(k string) bool
+}
+
+func (s *failingStorage) Write(k string, r *resource.Resource) error {
+	if s.fail(k) {
+		return fmt.Errorf("writing %q: %w", k, errDiskFull)
+	}
+	return s.MemStorage.Write(k, r)
+}
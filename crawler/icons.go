@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/url"
+	"path"
+)
+
+// Icons which browsers and other clients request from the root of a site
+// without them necessarily being linked from any page.
+var wellKnownIcons = []string{
+	"/favicon.ico",
+	"/apple-touch-icon.png",
+}
+
+// iconURLs returns the well-known icon URLs of the crawl's start host.
+func iconURLs(start url.URL) []url.URL {
+	urls := make([]url.URL, 0, len(wellKnownIcons))
+	for _, p := range wellKnownIcons {
+		urls = append(urls, *start.ResolveReference(&url.URL{Path: p}))
+	}
+	return urls
+}
+
+func isWellKnownIcon(u url.URL) bool {
+	for _, p := range wellKnownIcons {
+		if u.Path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// isIconRel reports whether a <link> rel attribute value names an icon or a
+// web app manifest, e.g. "icon", "shortcut icon" or "apple-touch-icon".
+func isIconRel(rel string) bool {
+	return hasRel(rel, "icon") || hasRel(rel, "apple-touch-icon") ||
+		hasRel(rel, "apple-touch-icon-precomposed") || hasRel(rel, "mask-icon") ||
+		hasRel(rel, "manifest")
+}
+
+// isManifest reports whether a resource is a web app manifest. They are often
+// served as plain JSON, so the file name is also checked.
+func isManifest(contentType string, u url.URL) bool {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil && t == "application/manifest+json" {
+		return true
+	}
+	base := path.Base(u.Path)
+	return path.Ext(base) == ".webmanifest" || base == "manifest.json"
+}
+
+// webManifest holds the parts of a web app manifest which name images.
+type webManifest struct {
+	Icons       []manifestImage `json:"icons"`
+	Screenshots []manifestImage `json:"screenshots"`
+	Shortcuts   []struct {
+		Icons []manifestImage `json:"icons"`
+	} `json:"shortcuts"`
+}
+
+type manifestImage struct {
+	Src string `json:"src"`
+}
+
+// rewriteManifest relativizes local image URLs in a web app manifest at u,
+// returning the rewritten manifest and the local images to fetch. Like feeds,
+// the manifest is edited in place rather than re-encoded, to keep its layout.
+func (c *Crawler) rewriteManifest(body []byte, u url.URL) ([]byte, []url.URL, error) {
+	var m webManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return body, nil, err
+	}
+	images := append(m.Icons, m.Screenshots...)
+	for _, s := range m.Shortcuts {
+		images = append(images, s.Icons...)
+	}
+	var links []url.URL
+	for _, img := range images {
+		src, err := url.Parse(img.Src)
+		if err != nil {
+			continue
+		}
+		abs := u.ResolveReference(src)
+		if !isWebScheme(abs) || !c.isLocal(*abs) {
+			continue
+		}
+		links = append(links, *abs)
+		if src.Host == "" && !c.needsBasePath(src) {
+			continue
+		}
+		rel := *src
+		relativize(&rel)
+		c.addBasePath(&rel)
+		o, _ := json.Marshal(img.Src)
+		n, _ := json.Marshal(rel.String())
+		body = bytes.ReplaceAll(body, o, n)
+		// JSON may escape slashes, e.g. as PHP's json_encode does.
+		body = bytes.ReplaceAll(body, escapeSlashes(o), escapeSlashes(n))
+	}
+	return body, links, nil
+}
+
+// escapeSlashes escapes the slashes in a JSON string as "\/".
+func escapeSlashes(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte("/"), []byte(`\/`))
+}
@@ -0,0 +1,51 @@
+package crawler
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCaptureIcons(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/": `<link rel="manifest" href="/site.webmanifest"><p>No icons linked here.</p>`,
+	})
+	site.file("/favicon.ico", "image/x-icon", "ICO")
+	site.file("/icon-192.png", "image/png", "PNG")
+	site.file("/icon-512.png", "image/png", "PNG")
+	// As PHP's json_encode writes it, with escaped slashes.
+	site.file("/site.webmanifest", "application/manifest+json",
+		`{"icons": [{"src": "`+strings.ReplaceAll(site.URL, "/", `\/`)+`\/icon-192.png"}, {"src": "/icon-512.png"}]}`)
+	c, db := newTestCrawler(site)
+	c.CaptureIcons = true
+	stats := c.CrawlP(context.Background(), site.u("/"), 10, 1)
+
+	// The missing /apple-touch-icon.png is neither stored nor an error.
+	if stats.Errors != 0 {
+		t.Errorf("CrawlP() = %+v, want no errors", stats)
+	}
+	if site.fetches("/apple-touch-icon.png") != 1 {
+		t.Errorf("Did not request /apple-touch-icon.png")
+	}
+	want := []string{"/", "/favicon.ico", "/icon-192.png", "/icon-512.png", "/site.webmanifest"}
+	if got := storedKeys(t, db); !slices.Equal(got, want) {
+		t.Errorf("Stored %q, want %q", got, want)
+	}
+	r, err := db.Read("/site.webmanifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(r.GetContent()), `{"icons": [{"src": "\/icon-192.png"}, {"src": "/icon-512.png"}]}`; got != want {
+		t.Errorf("Stored manifest %s, want %s", got, want)
+	}
+
+	// Without CaptureIcons, only the page and what it links are fetched.
+	site2 := newTestSite(t, map[string]string{"/": "<p>Hello</p>"})
+	site2.file("/favicon.ico", "image/x-icon", "ICO")
+	c, db = newTestCrawler(site2)
+	c.CrawlP(context.Background(), site2.u("/"), 10, 1)
+	if got := storedKeys(t, db); !slices.Equal(got, []string{"/"}) {
+		t.Errorf("Stored %q without CaptureIcons, want only /", got)
+	}
+}
@@ -0,0 +1,78 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"golang.org/x/net/html"
+)
+
+// importKey maps the path of a file, relative to the root of a mirror
+// directory, to the key its content is stored under. Directory index files
+// map to the directory, e.g. "foo/index.html" to "/foo/".
+func importKey(rel string) string {
+	key := "/" + filepath.ToSlash(rel)
+	if path.Base(key) == "index.html" || path.Base(key) == "index.htm" {
+		key = path.Dir(key)
+		if key != "/" {
+			key += "/"
+		}
+	}
+	return key
+}
+
+// ImportDir stores the content of every file under `dir`, e.g. a mirror made
+// by another tool, keyed by its path relative to `dir`. Content types are
+// inferred from file extensions. If `staticate` is set, HTML files get the
+// same link relativization as crawled pages. Returns the number of files stored.
+func (c *Crawler) ImportDir(dir string, staticate bool) (int, error) {
+	count := 0
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		r := &resource.Resource{
+			Content:     content,
+			ContentType: mime.TypeByExtension(filepath.Ext(p)),
+			SourceUrl:   "file://" + filepath.ToSlash(p),
+		}
+		if r.ContentType == "" {
+			r.ContentType = "application/octet-stream"
+		}
+		if staticate && isHTMLContentType(r.ContentType) {
+			doc, err := html.Parse(bytes.NewReader(content))
+			if err != nil {
+				return fmt.Errorf("parsing %q: %w", p, err)
+			}
+			c.staticateDoc(doc, c.origin)
+			b := new(bytes.Buffer)
+			html.Render(b, doc)
+			r.Content = b.Bytes()
+		}
+
+		key := importKey(rel)
+		log.Printf("Importing %q as %q\n", p, key)
+		if err := c.write(c.transformKey(key), r); err != nil {
+			return fmt.Errorf("saving %q: %w", key, err)
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,54 @@
+package crawler
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/TheSnook/polyester/storage"
+)
+
+func TestImportDir(t *testing.T) {
+	dir := t.TempDir()
+	for p, content := range map[string]string{
+		"index.html":             `<a href="https://example.com/about/">About</a>`,
+		"about/index.html":       "<p>About</p>",
+		"2024/post.html":         "<p>Post</p>",
+		"wp-content/style.css":   "p{}",
+		"wp-content/logo.png":    "PNG",
+		"downloads/report.xyz42": "?",
+	} {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := storage.NewMem()
+	c := New("example.com", nil, db)
+	n, err := c.ImportDir(dir, true)
+	if err != nil || n != 6 {
+		t.Fatalf("ImportDir() = %d, %v, want 6 files", n, err)
+	}
+	want := []string{"/", "/2024/post.html", "/about/", "/downloads/report.xyz42", "/wp-content/logo.png", "/wp-content/style.css"}
+	if keys := storedKeys(t, db); !slices.Equal(keys, want) {
+		t.Errorf("Stored %q, want %q", keys, want)
+	}
+	for k, want := range map[string]string{
+		"/":                       "text/html; charset=utf-8",
+		"/wp-content/style.css":   "text/css; charset=utf-8",
+		"/wp-content/logo.png":    "image/png",
+		"/downloads/report.xyz42": "application/octet-stream",
+	} {
+		if r, _ := db.Read(k); r.GetContentType() != want {
+			t.Errorf("%q has content type %q, want %q", k, r.GetContentType(), want)
+		}
+	}
+	if r, _ := db.Read("/"); !strings.Contains(string(r.GetContent()), `href="/about/"`) {
+		t.Errorf("Imported home page not staticated: %s", r.GetContent())
+	}
+}
@@ -0,0 +1,44 @@
+package crawler
+
+import (
+	"net/url"
+	"slices"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// removeAttr deletes a named attribute from an HTML node.
+func removeAttr(n *html.Node, name string) {
+	n.Attr = slices.DeleteFunc(n.Attr, func(a html.Attribute) bool { return a.Key == name })
+}
+
+// stripIntegrity removes subresource integrity hashes from <link> and
+// <script> elements with local URLs, which point at the mirror (now, or once
+// relativized when served), since the mirrored bytes may not match. Their
+// crossorigin attributes are dropped too, as the resources are now
+// same-origin, except on preloads, where crossorigin must match that of the
+// eventual request (e.g. for fonts).
+func (c *Crawler) stripIntegrity(n *html.Node) {
+	var attr string
+	switch n.DataAtom {
+	case atom.Link:
+		attr = "href"
+	case atom.Script:
+		attr = "src"
+	default:
+		return
+	}
+	a := getAttr(n, attr)
+	if a == nil || a.Val == "" {
+		return
+	}
+	u, err := url.Parse(a.Val)
+	if err != nil || !isWebScheme(u) || !c.isLocal(*u) {
+		return
+	}
+	removeAttr(n, "integrity")
+	if rel := getAttr(n, "rel"); rel == nil || !isPreloadRel(rel.Val) {
+		removeAttr(n, "crossorigin")
+	}
+}
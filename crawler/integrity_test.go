@@ -0,0 +1,61 @@
+package crawler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripIntegrity(t *testing.T) {
+	in := `<link rel="stylesheet" href="https://example.com/style.css" integrity="sha384-abc" crossorigin="anonymous">` +
+		`<link rel="preload" as="font" href="/font.woff2" integrity="sha384-def" crossorigin="">` +
+		`<link rel="stylesheet" href="https://cdn.example.org/lib.css" integrity="sha384-ghi" crossorigin="anonymous">` +
+		`<script src="//cdn.example.org/lib.js" integrity="sha384-jkl" crossorigin="anonymous"></script>` +
+		`<script src="https://www.example.com/app.js" integrity="sha384-mno" crossorigin="anonymous"></script>`
+	for _, tc := range []struct {
+		name  string
+		setup func(c *Crawler)
+		want  []string
+	}{{
+		name: "default",
+		setup: func(c *Crawler) {
+			c.StripIntegrity = true
+		},
+		want: []string{
+			`<link rel="stylesheet" href="/style.css"/>`,
+			`<link rel="preload" as="font" href="/font.woff2" crossorigin=""/>`,
+			`<link rel="stylesheet" href="https://cdn.example.org/lib.css" integrity="sha384-ghi" crossorigin="anonymous"/>`,
+			`<script src="//cdn.example.org/lib.js" integrity="sha384-jkl" crossorigin="anonymous">`,
+			`<script src="https://www.example.com/app.js">`,
+		},
+	}, {
+		// URLs are left absolute, to be relativized when served.
+		name: "deferred relativization",
+		setup: func(c *Crawler) {
+			c.StripIntegrity = true
+			c.DeferRelativize = true
+		},
+		want: []string{
+			`<link rel="stylesheet" href="https://example.com/style.css"/>`,
+			`<link rel="preload" as="font" href="/font.woff2" crossorigin=""/>`,
+			`<script src="https://www.example.com/app.js">`,
+		},
+	}, {
+		name:  "off",
+		setup: func(c *Crawler) {},
+		want: []string{
+			`integrity="sha384-abc" crossorigin="anonymous"`,
+			`integrity="sha384-def"`,
+			`integrity="sha384-mno"`,
+		},
+	}} {
+		c := New("example.com", nil, nil)
+		c.quiet = true
+		tc.setup(c)
+		page, _ := staticate(t, c, in)
+		for _, s := range tc.want {
+			if !strings.Contains(page, s) {
+				t.Errorf("%s: staticated page lacks %s:\n%s", tc.name, s, page)
+			}
+		}
+	}
+}
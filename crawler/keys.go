@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// KeyTransform maps the root-relative key of a page, including any query
+// string, to the key it is stored under.
+type KeyTransform func(key string) string
+
+// mapPath applies f to the path part of a key, leaving any query string.
+func mapPath(key string, f func(p string) string) string {
+	p, q, ok := strings.Cut(key, "?")
+	if ok {
+		return f(p) + "?" + q
+	}
+	return f(p)
+}
+
+// HTMLSuffixKeys stores directory-like pages as index.html files, and other
+// pages without a file extension as .html files, e.g. "/a/" as
+// "/a/index.html" and "/a" as "/a.html". Keys of assets are unchanged.
+func HTMLSuffixKeys(key string) string {
+	return mapPath(key, func(p string) string {
+		switch {
+		case strings.HasSuffix(p, "/"):
+			return p + "index.html"
+		case path.Ext(p) == "":
+			return p + ".html"
+		}
+		return p
+	})
+}
+
+// NoTrailingSlashKeys removes the trailing slash from keys other than "/",
+// e.g. "/a/" becomes "/a".
+func NoTrailingSlashKeys(key string) string {
+	return mapPath(key, func(p string) string {
+		if p == "/" {
+			return p
+		}
+		return strings.TrimSuffix(p, "/")
+	})
+}
+
+// PrefixKeys returns a transform which prepends a prefix (e.g. "/site") to
+// every key.
+func PrefixKeys(prefix string) KeyTransform {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(key string) string {
+		return prefix + key
+	}
+}
+
+// ChainKeyTransforms returns a transform applying each of ts in order.
+func ChainKeyTransforms(ts ...KeyTransform) KeyTransform {
+	return func(key string) string {
+		for _, t := range ts {
+			key = t(key)
+		}
+		return key
+	}
+}
+
+// ParseKeyTransform parses a comma-separated list of key transforms, applied
+// in order: "html_suffix", "no_trailing_slash" or "prefix=/some/prefix".
+// An empty spec gives a nil transform, which leaves keys unchanged.
+func ParseKeyTransform(spec string) (KeyTransform, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var ts []KeyTransform
+	for _, s := range strings.Split(spec, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(s), "=")
+		switch name {
+		case "html_suffix":
+			ts = append(ts, HTMLSuffixKeys)
+		case "no_trailing_slash":
+			ts = append(ts, NoTrailingSlashKeys)
+		case "prefix":
+			if !strings.HasPrefix(arg, "/") {
+				return nil, fmt.Errorf("key prefix %q must start with \"/\"", arg)
+			}
+			ts = append(ts, PrefixKeys(arg))
+		default:
+			return nil, fmt.Errorf("unknown key transform %q", s)
+		}
+	}
+	return ChainKeyTransforms(ts...), nil
+}
@@ -0,0 +1,67 @@
+package crawler
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestKeyTransforms(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		in   []string
+		want []string
+	}{
+		{"", []string{"/a/"}, []string{"/a/"}},
+		{"html_suffix", []string{"/", "/a/", "/a", "/a.css", "/feed/?page=2"}, []string{"/index.html", "/a/index.html", "/a.html", "/a.css", "/feed/index.html?page=2"}},
+		{"no_trailing_slash", []string{"/", "/a/", "/a/?x=1", "/a.css"}, []string{"/", "/a", "/a?x=1", "/a.css"}},
+		{"prefix=/site/", []string{"/", "/a/"}, []string{"/site/", "/site/a/"}},
+		{"prefix=/site, html_suffix", []string{"/a/"}, []string{"/site/a/index.html"}},
+	} {
+		kt, err := ParseKeyTransform(tc.spec)
+		if err != nil {
+			t.Errorf("ParseKeyTransform(%q) = %v", tc.spec, err)
+			continue
+		}
+		for i, k := range tc.in {
+			got := k
+			if kt != nil {
+				got = kt(k)
+			}
+			if got != tc.want[i] {
+				t.Errorf("%q: %q became %q, want %q", tc.spec, k, got, tc.want[i])
+			}
+		}
+	}
+	for _, spec := range []string{"prefix=site", "uppercase"} {
+		if _, err := ParseKeyTransform(spec); err == nil {
+			t.Errorf("ParseKeyTransform(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestKeyTransformCrawl(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/": `<a href="/a/">A</a>`, "/a/": "A"})
+	site.file("/a.css", "text/css", "a{}")
+	for _, tc := range []struct {
+		kt   KeyTransform
+		want []string
+	}{
+		{HTMLSuffixKeys, []string{"/a.css", "/a/index.html", "/index.html"}},
+		{PrefixKeys("/site"), []string{"/site/", "/site/a.css", "/site/a/"}},
+	} {
+		c, db := newTestCrawler(site)
+		c.KeyTransform = tc.kt
+		stats := c.CrawlP(context.Background(), site.u("/"), 10, 1)
+		if err := c.CrawlOne(context.Background(), site.u("/a.css")); err != nil {
+			t.Fatal(err)
+		}
+		if got := storedKeys(t, db); !slices.Equal(got, tc.want) {
+			t.Errorf("Stored %q, want %q", got, tc.want)
+		}
+		// Stats report the keys of pages, which links refer to.
+		if want := []string{"/", "/a/"}; !slices.Equal(stats.Visited, want) {
+			t.Errorf("Visited %q, want %q", stats.Visited, want)
+		}
+	}
+}
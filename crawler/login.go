@@ -0,0 +1,109 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// LoginConfig describes a login form to submit before crawling.
+type LoginConfig struct {
+	URL    url.URL           // Page containing the login form.
+	Fields map[string]string // Form fields to fill in, e.g. username and password.
+}
+
+// Login submits a login form, so that the session cookie it sets is sent with
+// all later requests. Hidden fields in the form, such as CSRF tokens, are
+// submitted along with the configured fields.
+func (c *Crawler) Login(ctx context.Context, conf LoginConfig) error {
+	if c.httpClient.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		c.httpClient.Jar = jar
+	}
+
+	// Fetch the login page, for any hidden fields and cookies it sets.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, conf.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching login page: %w", err)
+	}
+	doc, err := html.Parse(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("parsing login page: %w", err)
+	}
+	form := findLoginForm(doc)
+	if form == nil {
+		return fmt.Errorf("no login form found at %q", &conf.URL)
+	}
+
+	action := conf.URL
+	if _, a := getURLAttr(form, "action"); a != nil {
+		action = *conf.URL.ResolveReference(a)
+	}
+	values := url.Values{}
+	for n := range form.Descendants() {
+		if n.Type != html.ElementNode || n.DataAtom != atom.Input {
+			continue
+		}
+		t, name, v := getAttr(n, "type"), getAttr(n, "name"), getAttr(n, "value")
+		if t != nil && strings.EqualFold(t.Val, "hidden") && name != nil && v != nil {
+			values.Set(name.Val, v.Val)
+		}
+	}
+	for k, v := range conf.Fields {
+		values.Set(k, v)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, action.String(), strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err = c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("submitting login form: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("login to %q failed with status %s", &action, resp.Status)
+	}
+	if len(c.httpClient.Jar.Cookies(&action)) == 0 {
+		return fmt.Errorf("login to %q set no session cookie", &action)
+	}
+	log.Printf("Logged in at %q\n", &action)
+	return nil
+}
+
+// findLoginForm returns the first form with a password field, or failing
+// that, the first form in a document.
+func findLoginForm(doc *html.Node) *html.Node {
+	var first *html.Node
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode || n.DataAtom != atom.Form {
+			continue
+		}
+		if first == nil {
+			first = n
+		}
+		for x := range n.Descendants() {
+			if t := getAttr(x, "type"); x.DataAtom == atom.Input && t != nil && strings.EqualFold(t.Val, "password") {
+				return n
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,55 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	site := newTestSite(t, nil)
+	site.handle("/login/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `<form><input name="q"></form>`+
+				`<form action="/login/?submit=1" method="post"><input type="hidden" name="csrf" value="token123">`+
+				`<input name="log"><input type="password" name="pwd"></form>`)
+			return
+		}
+		if r.FormValue("csrf") != "token123" || r.FormValue("log") != "admin" || r.FormValue("pwd") != "secret" {
+			http.Error(w, "Bad login", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	private := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if c, err := r.Cookie("session"); err != nil || c.Value != "ok" {
+				http.Error(w, "Log in first", http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, body)
+		}
+	}
+	site.handle("/", private(`<a href="/members/">Members</a>`))
+	site.handle("/members/", private("<p>Members only</p>"))
+
+	c, db := newTestCrawler(site)
+	bad := LoginConfig{URL: site.u("/login/"), Fields: map[string]string{"log": "admin", "pwd": "wrong"}}
+	if err := c.Login(context.Background(), bad); err == nil {
+		t.Errorf("Login() with a wrong password succeeded")
+	}
+	good := LoginConfig{URL: site.u("/login/"), Fields: map[string]string{"log": "admin", "pwd": "secret"}}
+	if err := c.Login(context.Background(), good); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+	stats := c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	if stats.Errors != 0 || !slices.Equal(storedKeys(t, db), []string{"/", "/members/"}) {
+		t.Errorf("CrawlP() = %+v, stored %q, want / and /members/", stats, storedKeys(t, db))
+	}
+	if r, _ := db.Read("/members/"); string(r.GetContent()) != "<html><head></head><body><p>Members only</p></body></html>" {
+		t.Errorf("Stored /members/ = %q", r.GetContent())
+	}
+}
@@ -0,0 +1,70 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentManifest records a hash of each resource written, so that a later
+// crawl can skip writing resources which haven't changed, e.g. to save S3
+// PUTs. Resources missing from storage are always written. It is safe for
+// concurrent use.
+type ContentManifest struct {
+	mu     sync.Mutex
+	hashes map[string]string // Storage key to content hash.
+}
+
+func NewContentManifest() *ContentManifest {
+	return &ContentManifest{hashes: map[string]string{}}
+}
+
+// LoadContentManifest reads a manifest written by Save.
+func LoadContentManifest(r io.Reader) (*ContentManifest, error) {
+	m := NewContentManifest()
+	if err := json.NewDecoder(r).Decode(&m.hashes); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Save writes the manifest as JSON.
+func (m *ContentManifest) Save(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(m.hashes)
+}
+
+// unchanged reports whether the resource last written under key k had hash h.
+func (m *ContentManifest) unchanged(k, h string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hashes[k] == h
+}
+
+func (m *ContentManifest) set(k, h string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hashes[k] = h
+}
+
+func (m *ContentManifest) remove(k string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.hashes, k)
+}
+
+// resourceHash hashes everything stored for a resource except its crawl
+// time, which must not yet be set.
+func resourceHash(r *resource.Resource) string {
+	b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(r)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/TheSnook/polyester/storage"
+)
+
+// countingStorage is a MemStorage which counts writes by key.
+type countingStorage struct {
+	*storage.MemStorage
+	mu     sync.Mutex
+	writes map[string]int
+}
+
+func (s *countingStorage) Write(k string, r *resource.Resource) error {
+	s.mu.Lock()
+	s.writes[k]++
+	s.mu.Unlock()
+	return s.MemStorage.Write(k, r)
+}
+
+func TestContentManifest(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":         `<a href="/same/">Same</a> <a href="/changed/">Changed</a> <a href="/deleted/">Deleted</a>`,
+		"/same/":    "Same",
+		"/changed/": "Before",
+		"/deleted/": "Deleted",
+	})
+	u := site.u("/")
+	db := &countingStorage{MemStorage: storage.NewMem(), writes: map[string]int{}}
+	c := New(u.Hostname(), nil, db)
+	c.Manifest = NewContentManifest()
+	c.CrawlP(context.Background(), u, 10, 1)
+
+	// As if crawling again later, from the saved manifest.
+	var saved bytes.Buffer
+	if err := c.Manifest.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadContentManifest(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := db.Read("/same/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	site.page("/changed/", "After")
+	if err := db.Delete("/deleted/"); err != nil {
+		t.Fatal(err)
+	}
+	c = New(u.Hostname(), nil, db)
+	c.Manifest = m
+	c.CrawlP(context.Background(), u, 10, 1)
+
+	for k, want := range map[string]int{"/": 1, "/same/": 1, "/changed/": 2, "/deleted/": 2} {
+		if got := db.writes[k]; got != want {
+			t.Errorf("Wrote %s %d times, want %d", k, got, want)
+		}
+	}
+	// An unchanged page keeps its original crawl time.
+	after, err := db.Read("/same/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.GetCrawledAt().AsTime().Equal(before.GetCrawledAt().AsTime()) {
+		t.Errorf("CrawledAt of an unchanged page changed from %v to %v", before.GetCrawledAt().AsTime(), after.GetCrawledAt().AsTime())
+	}
+}
@@ -0,0 +1,50 @@
+package crawler
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Matches runs of HTML whitespace.
+var whitespaceRE = regexp.MustCompile(`[ \t\n\f\r]+`)
+
+// preservesWhitespace reports whether whitespace within an element is significant.
+func preservesWhitespace(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Pre, atom.Textarea, atom.Script, atom.Style:
+		return true
+	}
+	return false
+}
+
+// minifyDoc shrinks a document by collapsing runs of whitespace in text to a
+// single space, except within elements where whitespace is significant.
+// If `stripComments` is set, comments are removed too, except for IE
+// conditional comments.
+func minifyDoc(root *html.Node, stripComments bool) {
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for x := n.FirstChild; x != nil; {
+			next := x.NextSibling
+			switch x.Type {
+			case html.TextNode:
+				x.Data = whitespaceRE.ReplaceAllString(x.Data, " ")
+			case html.CommentNode:
+				if stripComments && !strings.HasPrefix(x.Data, "[if") {
+					n.RemoveChild(x)
+				}
+			case html.ElementNode:
+				if !preservesWhitespace(x) {
+					walk(x)
+				}
+			default:
+				walk(x)
+			}
+			x = next
+		}
+	}
+	walk(root)
+}
@@ -0,0 +1,43 @@
+package crawler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func minify(t *testing.T, in string, stripComments bool) string {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	minifyDoc(doc, stripComments)
+	out := new(bytes.Buffer)
+	html.Render(out, doc)
+	return out.String()
+}
+
+func TestMinify(t *testing.T) {
+	pre := "<pre>  line 1\n\n    indented\t<b> bold </b>\n</pre>"
+	textarea := "<textarea>  keep\n   this </textarea>"
+	script := "<script>\n  var  x = \"a   b\";\n</script>"
+	in := "<html>\n  <head>\n    <title>  Hi  </title>\n" + script + "\n  </head>\n" +
+		"  <body>\n    <!-- note -->\n    <p>Some   <em>spaced</em>\n\n   text</p>\n" +
+		pre + "\n" + textarea + "\n  </body>\n</html>"
+
+	out := minify(t, in, false)
+	for _, s := range []string{"<p>Some <em>spaced</em> text</p>", pre, textarea, script, "<!-- note -->"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Minified page lacks %q:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "\n    <p>") {
+		t.Errorf("Whitespace between elements not collapsed:\n%s", out)
+	}
+	if out := minify(t, in, true); strings.Contains(out, "note") {
+		t.Errorf("Comment not stripped:\n%s", out)
+	}
+}
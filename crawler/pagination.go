@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/url"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// nextPageURL finds the link to the next page of a paginated listing in a
+// document at u: a <link> or <a> with rel=next, or else the first link in
+// (or on) an element matching one of the crawler's pagination selectors.
+func (c *Crawler) nextPageURL(doc *html.Node, u url.URL) *url.URL {
+	var next string
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode || (n.DataAtom != atom.Link && n.DataAtom != atom.A) {
+			continue
+		}
+		if rel, href := getAttr(n, "rel"), getAttr(n, "href"); rel != nil && href != nil && hasRel(rel.Val, "next") {
+			next = href.Val
+			break
+		}
+	}
+	if next == "" {
+	search:
+		for n := range doc.Descendants() {
+			for _, s := range c.PaginationSelectors {
+				if !s.Match(n) {
+					continue
+				}
+				if href := firstHref(n); href != "" {
+					next = href
+					break search
+				}
+			}
+		}
+	}
+	if next == "" {
+		return nil
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return nil
+	}
+	return u.ResolveReference(ref)
+}
+
+// firstHref returns the href of n, if it is a link, or of its first
+// descendant link.
+func firstHref(n *html.Node) string {
+	if n.DataAtom == atom.A {
+		if href := getAttr(n, "href"); href != nil {
+			return href.Val
+		}
+	}
+	for d := range n.Descendants() {
+		if d.Type != html.ElementNode || d.DataAtom != atom.A {
+			continue
+		}
+		if href := getAttr(d, "href"); href != nil {
+			return href.Val
+		}
+	}
+	return ""
+}
+
+// CrawlPages fetches and stores a paginated listing, e.g. a blog archive,
+// starting at u and following its next-page links for up to maxPages pages
+// in total. Other links are not followed. It returns the number of pages
+// stored. Next-page links are looked for in the stored page, so must not be
+// removed by ExcludeSelectors.
+func (c *Crawler) CrawlPages(ctx context.Context, u url.URL, maxPages int) (int, error) {
+	next := c.canonicalize(u)
+	pages := 0
+	for pages < maxPages {
+		if err := ctx.Err(); err != nil {
+			return pages, err
+		}
+		u := next
+		c.markSeen(u)
+		log.Printf("Crawling page %d: %q\n", pages+1, &u)
+		res, _, err := c.processURL(ctx, u)
+		if err != nil {
+			return pages, err
+		}
+		if res == nil {
+			// Streamed to storage, or noindex.
+			return pages + 1, nil
+		}
+		if err := c.write(c.storageKey(u), res); err != nil {
+			return pages, err
+		}
+		pages++
+		if res.GetRedirect() != "" || !isHTMLContentType(res.GetContentType()) {
+			break
+		}
+		doc, err := html.Parse(bytes.NewReader(res.GetContent()))
+		if err != nil {
+			break
+		}
+		n := c.nextPageURL(doc, u)
+		if n == nil {
+			break
+		}
+		next = c.canonicalize(*n)
+		if !c.isLocal(next) || c.isSeen(next) {
+			break
+		}
+	}
+	if pages == maxPages {
+		log.Printf("Page limit of %d reached.\n", maxPages)
+	}
+	return pages, nil
+}
@@ -0,0 +1,50 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestCrawlPages(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/archive/page/3/": `<p>Last</p>`,
+		"/post/":           `<p>Post</p>`,
+	})
+	// The second page is only marked up with a theme's navigation class.
+	site.handle("/archive/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Query().Get("paged") == "2" {
+			io.WriteString(w, `<div class="nav-next"><a href="/archive/page/3/">Older</a></div>`)
+			return
+		}
+		io.WriteString(w, `<link rel="next" href="/archive/?paged=2"><a href="/post/">Post</a>`)
+	})
+	sel, err := ParseSelector(".nav-next")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		limit int
+		want  []string
+	}{
+		{2, []string{"/archive/", "/archive/?paged=2"}},
+		{10, []string{"/archive/", "/archive/?paged=2", "/archive/page/3/"}},
+	} {
+		c, db := newTestCrawler(site)
+		c.PaginationSelectors = []Selector{sel}
+		n, err := c.CrawlPages(context.Background(), site.u("/archive/"), tc.limit)
+		if err != nil || n != len(tc.want) {
+			t.Errorf("CrawlPages(limit %d) = %d, %v, want %d pages", tc.limit, n, err, len(tc.want))
+		}
+		if got := storedKeys(t, db); !slices.Equal(got, tc.want) {
+			t.Errorf("CrawlPages(limit %d) stored %q, want %q", tc.limit, got, tc.want)
+		}
+	}
+	if site.fetches("/post/") != 0 {
+		t.Errorf("Followed a link other than to the next page")
+	}
+}
@@ -0,0 +1,40 @@
+package crawler
+
+import "sync"
+
+// CrawlProgress is a snapshot of the state of a crawl in progress.
+type CrawlProgress struct {
+	Running  bool // A crawl is in progress.
+	Fetched  int  // Number of URLs fetched or attempted so far.
+	InFlight int  // Number of URLs being fetched or processed.
+	Queued   int  // Number of URLs waiting to be fetched.
+	Errors   int  // Number of URLs which could not be fetched, processed or stored.
+}
+
+// progressTracker keeps a CrawlProgress which can be read while the crawl
+// updates it. It has its own lock, so reading it never blocks the crawl for
+// long.
+type progressTracker struct {
+	mu sync.Mutex
+	p  CrawlProgress
+}
+
+// update applies fn to the progress under the lock, so that related counters
+// change together.
+func (t *progressTracker) update(fn func(p *CrawlProgress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&t.p)
+}
+
+func (t *progressTracker) get() CrawlProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.p
+}
+
+// Progress returns a consistent snapshot of the current (or last) crawl's
+// progress. It is safe to call from any goroutine while CrawlP runs.
+func (c *Crawler) Progress() CrawlProgress {
+	return c.progress.get()
+}
@@ -0,0 +1,74 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestProgressDuringCrawl is meant for -race: Progress is polled while a
+// parallel crawl updates it.
+func TestProgressDuringCrawl(t *testing.T) {
+	const n, maxP = 30, 4
+	site := newTestSite(t, nil)
+	var links strings.Builder
+	for i := range n {
+		fmt.Fprintf(&links, `<a href="/p%d/">%d</a>`, i, i)
+		site.handle(fmt.Sprintf("/p%d/", i), func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond)
+			if i%10 == 0 {
+				abort(w, r)
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>Page</p>"))
+		})
+	}
+	site.page("/", links.String())
+	c, _ := newTestCrawler(site)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var snapshots []CrawlProgress
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			snapshots = append(snapshots, c.Progress())
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	stats := c.CrawlP(context.Background(), site.u("/"), 1000, maxP)
+	close(done)
+	wg.Wait()
+
+	last := CrawlProgress{}
+	for _, p := range snapshots {
+		if p.Fetched < last.Fetched || p.Errors < last.Errors {
+			t.Errorf("Progress went backwards, from %+v to %+v", last, p)
+		}
+		// Up to maxP URLs are being fetched, and one more may be being stored.
+		if p.InFlight < 0 || p.InFlight > maxP+1 || p.Queued < 0 || p.Errors > p.Fetched {
+			t.Errorf("Inconsistent progress %+v", p)
+		}
+		last = p
+	}
+	if len(snapshots) < 2 {
+		t.Errorf("Only %d progress snapshots taken", len(snapshots))
+	}
+	want := CrawlProgress{Fetched: stats.Fetched, Errors: stats.Errors}
+	if got := c.Progress(); got != want {
+		t.Errorf("Final Progress() = %+v, want %+v", got, want)
+	}
+	if stats.Fetched != n+1 || stats.Errors != 3 {
+		t.Errorf("Fetched %d with %d errors, want %d with 3", stats.Fetched, stats.Errors, n+1)
+	}
+}
@@ -0,0 +1,53 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Prune deletes stored resources which were not seen by the crawler, e.g.
+// pages removed from the site since an earlier crawl. It should only be run
+// after a complete crawl, or it will delete pages which still exist. With
+// dryRun set, nothing is deleted. Returns the keys of the stale resources.
+func (c *Crawler) Prune(dryRun bool) ([]string, error) {
+	keys, err := c.db.Keys()
+	if err != nil {
+		return nil, err
+	}
+	c.muSeen.Lock()
+	if c.bloom != nil {
+		c.muSeen.Unlock()
+		return nil, errBloomSeen
+	}
+	seen := make(map[string]struct{}, len(c.seen))
+	for k := range c.seen {
+		seen[c.transformKey(k)] = struct{}{}
+	}
+	c.muSeen.Unlock()
+	var stale []string
+	for _, k := range keys {
+		// Original HTML is kept as long as its page is.
+		page, _ := strings.CutPrefix(k, ORIGINAL_KEY_PREFIX)
+		if _, ok := seen[page]; !ok {
+			stale = append(stale, k)
+		}
+	}
+
+	if dryRun {
+		return stale, nil
+	}
+	var errs []error
+	for _, k := range stale {
+		log.Printf("Pruning %q\n", k)
+		if err := c.db.Delete(k); err != nil {
+			errs = append(errs, fmt.Errorf("deleting %q: %w", k, err))
+			continue
+		}
+		if c.Manifest != nil {
+			c.Manifest.remove(k)
+		}
+	}
+	return stale, errors.Join(errs...)
+}
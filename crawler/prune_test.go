@@ -0,0 +1,49 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+)
+
+func TestPrune(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":   `<a href="/a/">A</a>`,
+		"/a/": "<p>A</p>",
+	})
+	c, db := newTestCrawler(site)
+	// Left over from an earlier crawl of the site.
+	for _, k := range []string{"/", "/a/", "/gone/", ORIGINAL_KEY_PREFIX + "/a/", ORIGINAL_KEY_PREFIX + "/gone/"} {
+		db.Write(k, &resource.Resource{ContentType: "text/html", Content: []byte("<p>Old</p>")})
+	}
+	if stats := c.CrawlP(context.Background(), site.u("/"), 10, 1); stats.Errors > 0 || len(stats.Unvisited) > 0 {
+		t.Fatalf("Incomplete crawl: %+v", stats)
+	}
+
+	wantStale := []string{ORIGINAL_KEY_PREFIX + "/gone/", "/gone/"}
+	stale, err := c.Prune(true)
+	if err != nil || !slices.Equal(stale, wantStale) {
+		t.Errorf("Prune(dry run) = %q, %v, want %q", stale, err, wantStale)
+	}
+	if keys := storedKeys(t, db); len(keys) != 5 {
+		t.Errorf("Dry run left %q, want all 5 keys", keys)
+	}
+
+	stale, err = c.Prune(false)
+	if err != nil || !slices.Equal(stale, wantStale) {
+		t.Errorf("Prune() = %q, %v, want %q", stale, err, wantStale)
+	}
+	if keys, want := storedKeys(t, db), []string{"/", ORIGINAL_KEY_PREFIX + "/a/", "/a/"}; !slices.Equal(keys, want) {
+		t.Errorf("Prune() left %q, want %q", keys, want)
+	}
+
+	// A bloom filter can't list what was seen.
+	c, _ = newTestCrawler(site)
+	c.UseBloomFilter(100, 0.01)
+	if _, err := c.Prune(true); !errors.Is(err, errBloomSeen) {
+		t.Errorf("Prune() with a bloom filter = %v, want errBloomSeen", err)
+	}
+}
@@ -0,0 +1,40 @@
+package crawler
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// publishURLAttr rewrites a named URL attribute of a node to point at the
+// publish domain, if it is a local URL which must stay absolute.
+func (c *Crawler) publishURLAttr(n *html.Node, name string) {
+	if c.PublishDomain == "" || c.DeferRelativize {
+		return
+	}
+	a, u := getURLAttr(n, name)
+	if a == nil || u == nil || u.Host == "" || !c.isLocal(*u) {
+		return
+	}
+	u.Host = c.PublishDomain
+	c.addBasePath(u)
+	a.Val = u.String()
+}
+
+// publishText rewrites absolute URLs on the origin domain in the text content
+// of a node (e.g. JSON-LD) to point at the publish domain.
+func (c *Crawler) publishText(n *html.Node, origin string) {
+	if c.PublishDomain == "" || c.DeferRelativize {
+		return
+	}
+	base := c.PublishDomain + c.BasePath
+	r := strings.NewReplacer(
+		"//"+origin+"/", "//"+base+"/",
+		`\/\/`+origin+`\/`, `\/\/`+strings.ReplaceAll(base, "/", `\/`)+`\/`,
+	)
+	for x := n.FirstChild; x != nil; x = x.NextSibling {
+		if x.Type == html.TextNode {
+			x.Data = r.Replace(x.Data)
+		}
+	}
+}
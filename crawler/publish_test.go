@@ -0,0 +1,31 @@
+package crawler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPublishDomain(t *testing.T) {
+	c := New("example.com", []string{"www.example.com"}, nil)
+	c.quiet = true
+	c.PublishDomain = "mirror.example.org"
+	in := `<link rel="canonical" href="https://www.example.com/post/?p=1">` +
+		`<meta property="og:url" content="https://example.com/post/">` +
+		`<a href="https://example.com/post/">Post</a>`
+	page, _ := staticate(t, c, in)
+	for _, s := range []string{
+		`<link rel="canonical" href="https://mirror.example.org/post/?p=1"/>`,
+		`<meta property="og:url" content="https://mirror.example.org/post/"/>`,
+		`<a href="/post/">`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("Staticated page lacks %s:\n%s", s, page)
+		}
+	}
+
+	// Without a publish domain, canonical URLs are left as they are.
+	c.PublishDomain = ""
+	if page, _ := staticate(t, c, in); !strings.Contains(page, `href="https://www.example.com/post/?p=1"`) {
+		t.Errorf("Canonical URL changed without a publish domain:\n%s", page)
+	}
+}
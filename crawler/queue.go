@@ -0,0 +1,153 @@
+package crawler
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// queuedURL is a URL waiting in a urlQueue.
+type queuedURL struct {
+	u        url.URL
+	priority float64
+	seq      uint64 // Order of arrival, so equal priorities are first in, first out.
+}
+
+// urlHeap orders queued URLs by descending priority, then arrival.
+type urlHeap []queuedURL
+
+func (h urlHeap) Len() int { return len(h) }
+func (h urlHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h urlHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *urlHeap) Push(x any)   { *h = append(*h, x.(queuedURL)) }
+func (h *urlHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// urlQueue is a queue of URLs to crawl, highest priority first, and otherwise
+// first in, first out. If max > 0, at most max URLs are held in memory, and
+// any more are spilled to a temporary file until there is room for them.
+// Spilled URLs are only ordered by priority once read back. Not safe for
+// concurrent use.
+type urlQueue struct {
+	mem     urlHeap
+	max     int
+	seq     uint64
+	spilled int // URLs written to the spill file but not yet read back.
+	w       *os.File
+	bw      *bufio.Writer
+	rf      *os.File
+	r       *bufio.Reader // Unlike a bufio.Scanner, has no line length limit.
+}
+
+func newURLQueue(max int) *urlQueue {
+	return &urlQueue{max: max}
+}
+
+func (q *urlQueue) Len() int {
+	return len(q.mem) + q.spilled
+}
+
+// Push adds a URL to the queue with the default priority, 0.
+func (q *urlQueue) Push(u url.URL) {
+	q.PushPriority(u, 0)
+}
+
+// PushPriority adds a URL to the queue, to be popped before any URLs of
+// lower priority.
+func (q *urlQueue) PushPriority(u url.URL, priority float64) {
+	q.seq++
+	x := queuedURL{u: u, priority: priority, seq: q.seq}
+	if q.max <= 0 || (len(q.mem) < q.max && q.spilled == 0) {
+		heap.Push(&q.mem, x)
+		return
+	}
+	if err := q.spill(x); err != nil {
+		// Better to use more memory than to lose URLs.
+		log.Printf("Could not spill crawl queue to disk: %v\n", err)
+		heap.Push(&q.mem, x)
+	}
+}
+
+func (q *urlQueue) spill(x queuedURL) error {
+	if q.w == nil {
+		f, err := os.CreateTemp("", "polyester-queue-")
+		if err != nil {
+			return err
+		}
+		r, err := os.Open(f.Name())
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+		q.w, q.bw, q.rf, q.r = f, bufio.NewWriter(f), r, bufio.NewReader(r)
+	}
+	if _, err := fmt.Fprintf(q.bw, "%g %s\n", x.priority, x.u.String()); err != nil {
+		return err
+	}
+	q.spilled++
+	return nil
+}
+
+// Pop removes and returns the URL at the head of the queue, which must not
+// be empty.
+func (q *urlQueue) Pop() url.URL {
+	if len(q.mem) == 0 {
+		q.refill()
+	}
+	u := heap.Pop(&q.mem).(queuedURL).u
+	if len(q.mem) == 0 {
+		// Let go of the backing array.
+		q.mem = nil
+	}
+	return u
+}
+
+// refill reads up to max spilled URLs back into memory. Losing a queued URL
+// would leave the crawl waiting for it forever, so failures are fatal.
+func (q *urlQueue) refill() {
+	if err := q.bw.Flush(); err != nil {
+		log.Fatalf("Could not flush crawl queue spill file: %v", err)
+	}
+	for q.spilled > 0 && len(q.mem) < q.max {
+		line, err := q.r.ReadString('\n')
+		if err != nil {
+			log.Fatalf("Could not read crawl queue spill file: %v", err)
+		}
+		p, s, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		priority, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			log.Fatalf("Unreadable priority %q in crawl queue spill file: %v", p, err)
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			log.Fatalf("Unreadable URL %q in crawl queue spill file: %v", s, err)
+		}
+		q.spilled--
+		q.seq++
+		heap.Push(&q.mem, queuedURL{u: *u, priority: priority, seq: q.seq})
+	}
+}
+
+// Close removes any spill file.
+func (q *urlQueue) Close() {
+	if q.w != nil {
+		q.w.Close()
+		q.rf.Close()
+		os.Remove(q.w.Name())
+	}
+}
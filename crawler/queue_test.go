@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestURLQueueSpill(t *testing.T) {
+	q := newURLQueue(3)
+	defer q.Close()
+	u := func(i int) url.URL {
+		return url.URL{Scheme: "https", Host: "example.com", Path: fmt.Sprintf("/%d/", i)}
+	}
+	long := url.URL{Scheme: "https", Host: "example.com", Path: "/" + strings.Repeat("x", 100000)}
+
+	var want, got []string
+	push := func(u url.URL) {
+		q.Push(u)
+		want = append(want, u.String())
+		if len(q.mem) > 3 {
+			t.Fatalf("%d URLs in memory, want at most 3", len(q.mem))
+		}
+	}
+	pop := func() {
+		u := q.Pop()
+		got = append(got, u.String())
+		if len(q.mem) > 3 {
+			t.Fatalf("%d URLs in memory, want at most 3", len(q.mem))
+		}
+	}
+	for i := range 5 {
+		push(u(i))
+	}
+	push(long)
+	pop()
+	pop()
+	for i := 5; i < 10; i++ {
+		push(u(i))
+	}
+	// Drain, then spill again after the spill file has been read to its end.
+	for q.Len() > 0 {
+		pop()
+	}
+	for i := 10; i < 15; i++ {
+		push(u(i))
+	}
+	for q.Len() > 0 {
+		pop()
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Popped %d URLs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Pop %d = %.40q, want %.40q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMaxQueue(t *testing.T) {
+	// A fan-out site: the home page links to many sections, each linking to
+	// many pages.
+	const n = 15
+	pages := map[string]string{}
+	var home strings.Builder
+	for i := range n {
+		fmt.Fprintf(&home, `<a href="/s%d/">%d</a>`, i, i)
+		var section strings.Builder
+		for j := range n {
+			fmt.Fprintf(&section, `<a href="/s%d/p%d/">%d</a>`, i, j, j)
+			pages[fmt.Sprintf("/s%d/p%d/", i, j)] = "<p>Page</p>"
+		}
+		pages[fmt.Sprintf("/s%d/", i)] = section.String()
+	}
+	pages["/"] = home.String()
+	site := newTestSite(t, pages)
+	c, db := newTestCrawler(site)
+	c.MaxQueue = 4
+
+	stats := c.CrawlP(context.Background(), site.u("/"), 1000, 4)
+	if want := 1 + n + n*n; stats.Fetched != want || len(stats.Unvisited) != 0 {
+		t.Errorf("Fetched %d with %d unvisited, want %d and none", stats.Fetched, len(stats.Unvisited), want)
+	}
+	for p := range pages {
+		if site.fetches(p) != 1 {
+			t.Errorf("%s fetched %d times, want once", p, site.fetches(p))
+		}
+	}
+	if keys := storedKeys(t, db); len(keys) != len(pages) {
+		t.Errorf("Stored %d keys, want %d", len(keys), len(pages))
+	}
+}
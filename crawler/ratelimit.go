@@ -0,0 +1,181 @@
+package crawler
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Max times a URL is retried after a 429 (Too Many Requests) response.
+const MAX_RATE_LIMIT_RETRIES = 3
+
+// Back-off after a 429 response with no usable Retry-After header.
+const DEFAULT_RETRY_AFTER = 30 * time.Second
+
+// Longest Retry-After honored, so a misbehaving origin can't stall a crawl.
+const MAX_RETRY_AFTER = 10 * time.Minute
+
+// retryAfter returns how long to wait according to a Retry-After header value,
+// which is either a number of seconds or an HTTP date.
+func retryAfter(v string, now time.Time) time.Duration {
+	d := DEFAULT_RETRY_AFTER
+	if s, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && s >= 0 {
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	}
+	return max(0, min(d, MAX_RETRY_AFTER))
+}
+
+// backOff stops requests to a host for d, e.g. after it rate limited us.
+func (c *Crawler) backOff(host string, d time.Duration) {
+	c.muLimiters.Lock()
+	defer c.muLimiters.Unlock()
+	if c.hostBackoff == nil {
+		c.hostBackoff = map[string]time.Time{}
+	}
+	if until := time.Now().Add(d); until.After(c.hostBackoff[host]) {
+		c.hostBackoff[host] = until
+	}
+}
+
+// waitBackoff blocks until any back-off for a host has passed, or ctx is done.
+func (c *Crawler) waitBackoff(ctx context.Context, host string) error {
+	c.muLimiters.Lock()
+	d := time.Until(c.hostBackoff[host])
+	c.muLimiters.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimiter spaces out requests to a host evenly, optionally with jitter. It
+// is safe for concurrent use.
+type rateLimiter struct {
+	interval time.Duration
+	jitter   float64 // Fraction by which each interval is randomly varied.
+	minimum  bool    // Whether jitter may only lengthen the interval.
+
+	mu   sync.Mutex
+	next time.Time // When the next token is available.
+}
+
+// nextInterval returns the interval until the following token, varied by up
+// to ±jitter, or by up to +jitter if the interval is a minimum.
+func (l *rateLimiter) nextInterval() time.Duration {
+	if l.jitter <= 0 {
+		return l.interval
+	}
+	if l.minimum {
+		return time.Duration(float64(l.interval) * (1 + l.jitter*rand.Float64()))
+	}
+	return time.Duration(float64(l.interval) * (1 + l.jitter*(2*rand.Float64()-1)))
+}
+
+// Wait blocks until a request may be made, or ctx is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	t := l.next
+	if t.Before(now) {
+		t = now
+	}
+	l.next = t.Add(l.nextInterval())
+	l.mu.Unlock()
+
+	d := t.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimit waits for any back-off from the host of u, and for the
+// crawl-wide MaxRPS limit and the host's CrawlDelay (or robots.txt
+// Crawl-delay, if longer), if any, to allow another request. A robots.txt
+// Crawl-delay is a minimum, so jitter only ever adds to it.
+func (c *Crawler) rateLimit(ctx context.Context, u url.URL) error {
+	if err := c.waitBackoff(ctx, u.Hostname()); err != nil {
+		return err
+	}
+	delay, minimum := c.CrawlDelay, false
+	if c.RespectCrawlDelay {
+		if d := c.robotsCrawlDelay(ctx, u); d > delay {
+			delay, minimum = d, true
+		}
+	}
+	if delay > 0 {
+		if err := c.hostLimiter(u.Hostname(), delay, minimum).Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if c.MaxRPS <= 0 {
+		return nil
+	}
+	c.limiterOnce.Do(func() { c.limiter = rate.NewLimiter(rate.Limit(c.MaxRPS), 1) })
+	return c.limiter.Wait(ctx)
+}
+
+// hostLimiter returns the limiter spacing out requests to a host, creating
+// it with the given interval on first use.
+func (c *Crawler) hostLimiter(host string, interval time.Duration, minimum bool) *rateLimiter {
+	c.muLimiters.Lock()
+	defer c.muLimiters.Unlock()
+	if c.hostLimiters == nil {
+		c.hostLimiters = map[string]*rateLimiter{}
+	}
+	l, ok := c.hostLimiters[host]
+	if !ok {
+		l = &rateLimiter{interval: interval, jitter: c.CrawlDelayJitter, minimum: minimum}
+		c.hostLimiters[host] = l
+	}
+	return l
+}
+
+// acquireHost waits until fewer than MaxConnsPerHost requests to a host are in
+// flight, or ctx is done. The returned function must be called once the
+// request is finished.
+func (c *Crawler) acquireHost(ctx context.Context, host string) (func(), error) {
+	if c.MaxConnsPerHost <= 0 {
+		return func() {}, nil
+	}
+	c.muLimiters.Lock()
+	if c.hostSems == nil {
+		c.hostSems = map[string]chan struct{}{}
+	}
+	sem, ok := c.hostSems[host]
+	if !ok {
+		sem = make(chan struct{}, c.MaxConnsPerHost)
+		c.hostSems[host] = sem
+	}
+	c.muLimiters.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
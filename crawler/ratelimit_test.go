@@ -0,0 +1,215 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fanOutSite serves a home page linking to n-1 others, so that parallel
+// workers are only held back by rate limits. It returns a function listing
+// when each request arrived.
+func fanOutSite(t *testing.T, n int) (*testSite, func() []time.Time) {
+	t.Helper()
+	site := newTestSite(t, nil)
+	var links strings.Builder
+	var mu sync.Mutex
+	var times []time.Time
+	record := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			io.WriteString(w, links.String())
+		}
+	}
+	for i := range n - 1 {
+		fmt.Fprintf(&links, `<a href="/p%d/">p%d</a>`, i, i)
+		site.handle(fmt.Sprintf("/p%d/", i), record)
+	}
+	site.handle("/", record)
+	return site, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(times)
+	}
+}
+
+func TestMaxRPS(t *testing.T) {
+	const n = 10
+	site, requestTimes := fanOutSite(t, n)
+
+	c, _ := newTestCrawler(site)
+	const rps = 20
+	c.MaxRPS = rps
+	start := time.Now()
+	stats := c.CrawlP(context.Background(), site.u("/"), 100, 5)
+	elapsed := time.Since(start)
+	if stats.Fetched != n {
+		t.Fatalf("Fetched = %d, want %d", stats.Fetched, n)
+	}
+	// The bucket holds one token, so the first request is immediate and each
+	// later one waits 1/rps.
+	if min := time.Duration(n-1) * time.Second / rps; elapsed < min {
+		t.Errorf("Crawl of %d pages at %d rps took %v, want at least %v", n, rps, elapsed, min)
+	}
+	times := requestTimes()
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < time.Second/rps/2 {
+			t.Errorf("Request %d came %v after the previous one, want about %v", i, gap, time.Second/rps)
+		}
+	}
+}
+
+func TestCrawlDelayJitterBounds(t *testing.T) {
+	l := &rateLimiter{interval: 100 * time.Millisecond, jitter: 0.2}
+	lo, hi := time.Duration(1<<62), time.Duration(0)
+	for range 1000 {
+		d := l.nextInterval()
+		lo, hi = min(lo, d), max(hi, d)
+	}
+	if lo < 80*time.Millisecond || hi > 120*time.Millisecond {
+		t.Errorf("Intervals from %v to %v, want within 80ms to 120ms", lo, hi)
+	}
+	// Over so many draws, nearly all of the range is covered.
+	if lo > 85*time.Millisecond || hi < 115*time.Millisecond {
+		t.Errorf("Intervals from %v to %v, want them spread across 80ms to 120ms", lo, hi)
+	}
+}
+
+func TestCrawlDelayJitterMinimum(t *testing.T) {
+	l := &rateLimiter{interval: 100 * time.Millisecond, jitter: 0.2, minimum: true}
+	lo, hi := time.Duration(1<<62), time.Duration(0)
+	for range 1000 {
+		d := l.nextInterval()
+		lo, hi = min(lo, d), max(hi, d)
+	}
+	if lo < 100*time.Millisecond || hi > 120*time.Millisecond || hi < 115*time.Millisecond {
+		t.Errorf("Intervals from %v to %v, want them spread across 100ms to 120ms", lo, hi)
+	}
+}
+
+func TestCrawlDelayJitter(t *testing.T) {
+	const n = 20
+	site, requestTimes := fanOutSite(t, n)
+
+	c, _ := newTestCrawler(site)
+	const delay = 20 * time.Millisecond
+	c.CrawlDelay = delay
+	c.CrawlDelayJitter = 0.5
+	if stats := c.CrawlP(context.Background(), site.u("/"), 100, 4); stats.Fetched != n {
+		t.Fatalf("Fetched = %d, want %d", stats.Fetched, n)
+	}
+	// Individual requests may arrive a little early or late relative to each
+	// other, so only the whole crawl is timed; TestCrawlDelayJitterBounds
+	// checks each interval.
+	times := requestTimes()
+	const slop = 10 * time.Millisecond
+	lo, hi := (n-1)*delay/2, (n-1)*delay*3/2
+	if span := times[len(times)-1].Sub(times[0]); span < lo-slop || span > hi+3*slop {
+		t.Errorf("%d requests took %v, want %v to %v", n, span, lo, hi)
+	}
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	const limit = 2
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	site := newTestSite(t, nil)
+	var links strings.Builder
+	const n = 20
+	for i := range n {
+		fmt.Fprintf(&links, `<a href="/p%d/">p%d</a>`, i, i)
+		site.handle(fmt.Sprintf("/p%d/", i), func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight++
+			most = max(most, inFlight)
+			over := inFlight > limit
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}()
+			if over {
+				// A fragile origin, falling over under load.
+				http.Error(w, "Too busy", http.StatusServiceUnavailable)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<p>Page</p>")
+		})
+	}
+	site.page("/", links.String())
+
+	c, db := newTestCrawler(site)
+	c.MaxConnsPerHost = limit
+	stats := c.CrawlP(context.Background(), site.u("/"), 100, 8)
+	if stats.Fetched != n+1 || len(stats.ErrorKinds) != 0 {
+		t.Errorf("Fetched %d with errors %v, want %d without errors", stats.Fetched, stats.ErrorKinds, n+1)
+	}
+	if most > limit {
+		t.Errorf("Up to %d concurrent requests, want at most %d", most, limit)
+	}
+	if keys := storedKeys(t, db); len(keys) != n+1 {
+		t.Errorf("Stored %d pages, want %d", len(keys), n+1)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", DEFAULT_RETRY_AFTER},
+		{"soon", DEFAULT_RETRY_AFTER},
+		{"0", 0},
+		{" 5 ", 5 * time.Second},
+		{"86400", MAX_RETRY_AFTER},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	} {
+		if got := retryAfter(tc.v, now); got != tc.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimitedRetry(t *testing.T) {
+	site := newTestSite(t, map[string]string{"/other/": "Other"})
+	var mu sync.Mutex
+	var times []time.Time
+	site.handle("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.WriteString(w, `<a href="/other/">Other</a>`)
+	})
+	c, db := newTestCrawler(site)
+	stats := c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	if stats.Errors != 0 || !slices.Equal(storedKeys(t, db), []string{"/", "/other/"}) {
+		t.Errorf("CrawlP() = %+v, stored %q, want both pages without errors", stats, storedKeys(t, db))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != 2 {
+		t.Fatalf("Fetched / %d times, want 2", len(times))
+	}
+	if d := times[1].Sub(times[0]); d < time.Second {
+		t.Errorf("Retried after %v, want at least the 1s Retry-After", d)
+	}
+}
@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/TheSnook/polyester/storage"
+)
+
+// Formats of redirect maps written by WriteRedirectMap.
+const (
+	RedirectMapNetlify = "netlify" // A Netlify (or Cloudflare Pages) _redirects file.
+	RedirectMapJSON    = "json"    // A JSON list of {"from", "to", "status"} objects.
+)
+
+// Redirect is a stored redirect, from a storage key to a root-relative or
+// absolute URL.
+type Redirect struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+}
+
+// StoredRedirects returns every redirect in db, sorted by key. They have
+// status 301, as served by the polyester server. With a KeyTransform, the
+// sources are the transformed keys.
+func StoredRedirects(db storage.Storage) ([]Redirect, error) {
+	keys, err := db.Keys()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	var rs []Redirect
+	for _, k := range keys {
+		r, err := db.Read(k)
+		if err != nil {
+			return nil, fmt.Errorf("reading %q: %w", k, err)
+		}
+		if to := r.GetRedirect(); to != "" {
+			rs = append(rs, Redirect{From: k, To: to, Status: 301})
+		}
+	}
+	return rs, nil
+}
+
+// WriteRedirectMap writes redirects to w in a format for configuring a CDN or
+// reverse proxy: RedirectMapNetlify or RedirectMapJSON.
+func WriteRedirectMap(w io.Writer, rs []Redirect, format string) error {
+	switch format {
+	case RedirectMapNetlify:
+		for _, r := range rs {
+			if _, err := fmt.Fprintf(w, "%s %s %d\n", netlifySource(r.From), r.To, r.Status); err != nil {
+				return err
+			}
+		}
+		return nil
+	case RedirectMapJSON:
+		if rs == nil {
+			rs = []Redirect{} // [], not null.
+		}
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.SetEscapeHTML(false)
+		return e.Encode(rs)
+	}
+	return fmt.Errorf("unknown redirect map format %q", format)
+}
+
+// netlifySource returns the source of a _redirects rule for a key. Query
+// parameters are matched by separate "name=value" fields after the path,
+// e.g. "/p?id=1" becomes "/p id=1".
+func netlifySource(k string) string {
+	p, q, ok := strings.Cut(k, "?")
+	if !ok || q == "" {
+		return p
+	}
+	return p + " " + strings.ReplaceAll(q, "&", " ")
+}
@@ -0,0 +1,66 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/TheSnook/polyester/storage"
+)
+
+func TestRedirectMap(t *testing.T) {
+	db := storage.NewMem()
+	for k, r := range map[string]*resource.Resource{
+		"/":           {ContentType: "text/html", Content: []byte("Home")},
+		"/old/":       {Redirect: "/new/"},
+		"/p?id=1&x=2": {Redirect: "/post/"},
+		"/elsewhere/": {Redirect: "https://example.net/"},
+		"/style.css":  {ContentType: "text/css"},
+		"/new/":       {ContentType: "text/html", Content: []byte("New")},
+		"/post/":      {ContentType: "text/html", Content: []byte("Post")},
+	} {
+		if err := db.Write(k, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs, err := StoredRedirects(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Redirect{
+		{From: "/elsewhere/", To: "https://example.net/", Status: 301},
+		{From: "/old/", To: "/new/", Status: 301},
+		{From: "/p?id=1&x=2", To: "/post/", Status: 301},
+	}
+	if !slices.Equal(rs, want) {
+		t.Fatalf("StoredRedirects() = %v, want %v", rs, want)
+	}
+
+	out := new(bytes.Buffer)
+	if err := WriteRedirectMap(out, rs, RedirectMapNetlify); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "/elsewhere/ https://example.net/ 301\n/old/ /new/ 301\n/p id=1 x=2 /post/ 301\n"; got != want {
+		t.Errorf("Netlify map = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := WriteRedirectMap(out, rs, RedirectMapJSON); err != nil {
+		t.Fatal(err)
+	}
+	var got []Redirect
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil || !slices.Equal(got, want) {
+		t.Errorf("JSON map = %s (%v), want %v", out, err, want)
+	}
+
+	// No redirects is an empty list, not null.
+	out.Reset()
+	if err := WriteRedirectMap(out, nil, RedirectMapJSON); err != nil || out.String() != "[]\n" {
+		t.Errorf("Empty JSON map = %q, %v, want []", out, err)
+	}
+	if err := WriteRedirectMap(out, rs, "nginx"); err == nil {
+		t.Errorf("WriteRedirectMap(nginx) succeeded, want an unknown format error")
+	}
+}
@@ -0,0 +1,265 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/TheSnook/polyester/site"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// resourceMatch is a site resource definition matched against a URL.
+type resourceMatch struct {
+	def  *site.Resource
+	vars map[string]string // Values of named capture groups and metadata.
+}
+
+// resourceJob is a page to fetch during a resource crawl.
+type resourceJob struct {
+	u     url.URL
+	def   *site.Resource // Definition whose `Follow` patterns apply to the page.
+	depth int            // Number of `Follow` links from the page matching `def` itself.
+}
+
+// anchoredRegexp compiles a path pattern which must match a whole path.
+func anchoredRegexp(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// expandVars replaces `{VAR}` placeholders in a path or pattern with known
+// variable values, passing each through `quote`.
+func expandVars(s string, vars map[string]string, quote func(string) string) string {
+	for k, v := range vars {
+		s = strings.ReplaceAll(s, "{"+k+"}", quote(v))
+	}
+	return s
+}
+
+// matchResource finds the first resource definition whose path pattern
+// matches `path`, and extracts its named capture groups.
+func matchResource(resources []site.Resource, path string) (*resourceMatch, error) {
+	for i, r := range resources {
+		re, err := anchoredRegexp(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("bad path pattern for resource %q: %w", r.Name, err)
+		}
+		matches := re.FindStringSubmatch(path)
+		if matches == nil {
+			continue
+		}
+		m := &resourceMatch{def: &resources[i], vars: map[string]string{}}
+		for j, name := range re.SubexpNames() {
+			if name != "" {
+				m.vars[name] = matches[j]
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("could not identify resource type from path: %s", path)
+}
+
+// MetadataRule is a site.Metadata value to extract from pages, with its
+// pattern compiled.
+type MetadataRule struct {
+	Var, Property string
+	Pattern       *regexp.Regexp // Optional. Named capture groups become variables.
+}
+
+// MetadataRules compiles the patterns of metadata values from a site config.
+func MetadataRules(ms []site.Metadata) ([]MetadataRule, error) {
+	rules := make([]MetadataRule, len(ms))
+	for i, m := range ms {
+		rules[i] = MetadataRule{Var: m.Var, Property: m.Property}
+		if m.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(m.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern for metadata %q: %w", m.Var, err)
+		}
+		rules[i].Pattern = re
+	}
+	return rules, nil
+}
+
+// pageMetadata returns the values of `<meta property=...>` (or `name=...`)
+// tags in a document for each of the metadata variables requested. The
+// property "title" is the page's `<title>`.
+func pageMetadata(doc *html.Node, want []MetadataRule) map[string]string {
+	vars := map[string]string{}
+	set := func(property, value string) {
+		for _, m := range want {
+			if m.Property != property {
+				continue
+			}
+			vars[m.Var] = value
+			if m.Pattern == nil {
+				continue
+			}
+			re := m.Pattern
+			if matches := re.FindStringSubmatch(value); matches != nil {
+				for i, name := range re.SubexpNames() {
+					if name != "" {
+						vars[name] = matches[i]
+					}
+				}
+			}
+		}
+	}
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		if n.DataAtom == atom.Title {
+			set("title", strings.TrimSpace(nodeText(n)))
+			continue
+		}
+		if n.DataAtom != atom.Meta {
+			continue
+		}
+		p, content := getAttr(n, "property"), getAttr(n, "content")
+		if p == nil {
+			p = getAttr(n, "name")
+		}
+		if p == nil || content == nil {
+			continue
+		}
+		set(p.Val, content.Val)
+	}
+	return vars
+}
+
+// checkResourceDomain verifies that a resource URL is on one of the site's domains.
+func checkResourceDomain(u *url.URL, conf *site.Config) error {
+	for _, d := range conf.Domains {
+		if d == u.Hostname() {
+			return nil
+		}
+	}
+	return fmt.Errorf("resource %q is not in the domain list of the site config: %v", u.Hostname(), conf.Domains)
+}
+
+// CrawlNewResource fetches a newly-created resource and the pages which
+// depend on it, according to the site config.
+func (c *Crawler) CrawlNewResource(ctx context.Context, u *url.URL, conf *site.Config, fetchLimit int) error {
+	return c.crawlResource(ctx, u, conf, fetchLimit, false)
+}
+
+// CrawlUpdateResource re-fetches an updated resource, overwriting its stored
+// copy, along with any pages matching its `Follow` patterns and its `Related`
+// pages (e.g. indexes and feeds), so that they reflect the change. They are
+// written even if the Manifest says they are unchanged, in case the stored
+// copies were edited or lost.
+func (c *Crawler) CrawlUpdateResource(ctx context.Context, u *url.URL, conf *site.Config, fetchLimit int) error {
+	return c.crawlResource(ctx, u, conf, fetchLimit, true)
+}
+
+// crawlResource fetches a resource, pages linked from it which match its
+// `Follow` patterns, and its `Related` pages (and their `Follow` pages).
+// No more than `fetchLimit` pages are fetched in total, and each resource
+// definition's own `FetchLimit` and `MaxDepth` are also respected. With
+// update, each page is dropped from the Manifest before it is fetched.
+func (c *Crawler) crawlResource(ctx context.Context, u *url.URL, conf *site.Config, fetchLimit int, update bool) error {
+	if err := checkResourceDomain(u, conf); err != nil {
+		return err
+	}
+	start := c.canonicalize(*u)
+
+	m, err := matchResource(conf.Resources, start.Path)
+	if err != nil {
+		return err
+	}
+	log.Printf("Resource is of type: %s\n", m.def.Name)
+
+	fetched := 0
+	// Pages fetched per resource definition.
+	defFetched := map[*site.Resource]int{}
+	toDo := []resourceJob{{u: start, def: m.def}}
+	for len(toDo) > 0 && fetched < fetchLimit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		job := toDo[0]
+		toDo = toDo[1:]
+		if c.isSeen(job.u) {
+			continue
+		}
+		if job.def.FetchLimit > 0 && defFetched[job.def] >= job.def.FetchLimit {
+			log.Printf("Skipping %q: fetch limit for resource %q reached\n", &job.u, job.def.Name)
+			continue
+		}
+		c.markSeen(job.u)
+		fetched++
+		defFetched[job.def]++
+
+		if update && c.Manifest != nil {
+			c.Manifest.remove(c.storageKey(job.u))
+		}
+		log.Println("Crawling resource: ", &job.u)
+		res, links, err := c.processURL(ctx, job.u)
+		if err != nil {
+			return fmt.Errorf("fetching %q: %w", &job.u, err)
+		}
+		if res == nil {
+			// Already streamed to storage, or noindex. Its links are not followed.
+			continue
+		}
+		if err := c.write(c.storageKey(job.u), res); err != nil {
+			return fmt.Errorf("saving %q: %w", &job.u, err)
+		}
+
+		if job.u == start {
+			// Metadata of the resource itself may be needed to find related pages.
+			rules, err := MetadataRules(m.def.Metadata)
+			if err != nil {
+				return fmt.Errorf("resource %q: %w", m.def.Name, err)
+			}
+			if doc, err := html.Parse(bytes.NewReader(res.GetContent())); err == nil {
+				for k, v := range pageMetadata(doc, rules) {
+					m.vars[k] = v
+				}
+			}
+			for i, r := range m.def.Related {
+				path := expandVars(r.Path, m.vars, url.PathEscape)
+				if strings.Contains(path, "{") {
+					log.Printf("Skipping related resource %q: unresolved variables in %q\n", r.Name, path)
+					continue
+				}
+				toDo = append(toDo, resourceJob{u: c.canonicalize(*start.ResolveReference(&url.URL{Path: path})), def: &m.def.Related[i]})
+			}
+		}
+
+		if job.def.MaxDepth > 0 && job.depth >= job.def.MaxDepth {
+			continue
+		}
+		follow := make([]*regexp.Regexp, 0, len(job.def.Follow))
+		for _, f := range job.def.Follow {
+			re, err := anchoredRegexp(expandVars(f, m.vars, regexp.QuoteMeta))
+			if err != nil {
+				return fmt.Errorf("bad follow pattern %q for resource %q: %w", f, job.def.Name, err)
+			}
+			follow = append(follow, re)
+		}
+		for _, l := range links {
+			if !c.isLocal(l) {
+				continue
+			}
+			for _, re := range follow {
+				if re.MatchString(l.Path) {
+					toDo = append(toDo, resourceJob{u: c.canonicalize(l), def: job.def, depth: job.depth + 1})
+					break
+				}
+			}
+		}
+	}
+	if len(toDo) > 0 {
+		log.Printf("Fetch limit reached with %d resource pages unvisited.\n", len(toDo))
+	}
+	return nil
+}
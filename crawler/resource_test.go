@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/TheSnook/polyester/site"
+)
+
+func TestCrawlUpdateResource(t *testing.T) {
+	ts := newTestSite(t, map[string]string{
+		"/2024/hello/":    `<p>Updated</p> <a href="/category/news/">News</a> <a href="/about/">About</a>`,
+		"/category/news/": `<p>Updated list</p>`,
+		"/about/":         `<p>About</p>`,
+	})
+	c, db := newTestCrawler(ts)
+	for _, k := range []string{"/2024/hello/", "/category/news/"} {
+		db.Write(k, &resource.Resource{ContentType: "text/html", Content: []byte("<p>Stale</p>")})
+	}
+	conf := &site.Config{
+		Domains: []string{"127.0.0.1"},
+		Resources: []site.Resource{{
+			Name:   "post",
+			Path:   `/\d+/[^/]+/`,
+			Follow: []string{`/category/[^/]+/`},
+		}},
+	}
+	u := ts.u("/2024/hello/")
+	if err := c.CrawlUpdateResource(context.Background(), &u, conf, 10); err != nil {
+		t.Fatalf("CrawlUpdateResource() = %v", err)
+	}
+	for k, want := range map[string]string{"/2024/hello/": "Updated", "/category/news/": "Updated list"} {
+		if ts.fetches(k) != 1 {
+			t.Errorf("Fetched %q %d times, want 1", k, ts.fetches(k))
+		}
+		r, err := db.Read(k)
+		if err != nil || !strings.Contains(string(r.GetContent()), "<p>"+want+"</p>") {
+			t.Errorf("Stored %q = %q, %v, want the updated page", k, r.GetContent(), err)
+		}
+	}
+	if ts.fetches("/about/") != 0 {
+		t.Errorf("Fetched /about/, which matches no Follow pattern")
+	}
+}
+
+func TestCrawlUpdateResourceManifest(t *testing.T) {
+	ts := newTestSite(t, map[string]string{
+		"/2024/hello/":    `<p>Hello</p> <a href="/category/news/">News</a>`,
+		"/category/news/": `<p>List</p>`,
+	})
+	conf := &site.Config{
+		Domains:   []string{"127.0.0.1"},
+		Resources: []site.Resource{{Name: "post", Path: `/\d+/[^/]+/`, Follow: []string{`/category/[^/]+/`}}},
+	}
+	u := ts.u("/2024/hello/")
+	c, db := newTestCrawler(ts)
+	c.Manifest = NewContentManifest()
+	if err := c.CrawlNewResource(context.Background(), &u, conf, 10); err != nil {
+		t.Fatalf("CrawlNewResource() = %v", err)
+	}
+	// The stored copies are edited behind the manifest's back.
+	keys := []string{"/2024/hello/", "/category/news/"}
+	for _, k := range keys {
+		db.Write(k, &resource.Resource{ContentType: "text/html", Content: []byte("<p>Stale</p>")})
+	}
+	again := New(u.Hostname(), nil, db)
+	again.Manifest = c.Manifest
+	if err := again.CrawlUpdateResource(context.Background(), &u, conf, 10); err != nil {
+		t.Fatalf("CrawlUpdateResource() = %v", err)
+	}
+	for _, k := range keys {
+		if r, err := db.Read(k); err != nil || strings.Contains(string(r.GetContent()), "Stale") {
+			t.Errorf("Stored %q = %q, %v, want it rewritten", k, r.GetContent(), err)
+		}
+	}
+}
+
+func TestResourceFetchLimit(t *testing.T) {
+	ts := newTestSite(t, map[string]string{
+		"/2024/hello/": `<a href="/tag/a/">A</a> <a href="/tag/b/">B</a> <a href="/tag/c/">C</a>`,
+		"/tag/a/":      "A", "/tag/b/": "B", "/tag/c/": "C",
+	})
+	c, _ := newTestCrawler(ts)
+	conf := &site.Config{
+		Domains: []string{"127.0.0.1"},
+		Resources: []site.Resource{{
+			Name:       "post",
+			Path:       `/\d+/[^/]+/`,
+			Follow:     []string{`/tag/[^/]+/`},
+			FetchLimit: 3, // The post and two of its tags.
+		}},
+	}
+	u := ts.u("/2024/hello/")
+	if err := c.CrawlNewResource(context.Background(), &u, conf, 100); err != nil {
+		t.Fatalf("CrawlNewResource() = %v", err)
+	}
+	tags := ts.fetches("/tag/a/") + ts.fetches("/tag/b/") + ts.fetches("/tag/c/")
+	if tags != 2 {
+		t.Errorf("Fetched %d follow targets, want 2", tags)
+	}
+}
+
+func TestPageMetadata(t *testing.T) {
+	cfg, err := site.Load([]byte(`
+name: Test
+domains: [example.com]
+metadata:
+  - var: TITLE
+    property: title
+  - var: IMAGE
+    property: "og:image"
+  - var: DATE
+    property: "article:published_time"
+    pattern: '^(?P<YEAR>\d{4})-(?P<MONTH>\d{2})'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := MetadataRules(cfg.Metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestSite(t, map[string]string{
+		"/post/": `<html><head><title> Hello, world </title>
+<meta property="og:image" content="https://cdn.example.org/hello.jpg">
+<meta property="article:published_time" content="2024-03-05T06:07:08Z">
+<meta name="description" content="Not wanted">
+</head><body><p>Hi</p></body></html>`,
+	})
+	c, db := newTestCrawler(ts)
+	c.Metadata = rules
+	if err := c.CrawlOne(context.Background(), ts.u("/post/")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Read("/post/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"TITLE": "Hello, world",
+		"IMAGE": "https://cdn.example.org/hello.jpg",
+		"DATE":  "2024-03-05T06:07:08Z",
+		"YEAR":  "2024",
+		"MONTH": "03",
+	}
+	if !maps.Equal(r.Metadata, want) {
+		t.Errorf("Metadata = %v, want %v", r.Metadata, want)
+	}
+
+	if _, err := MetadataRules([]site.Metadata{{Var: "X", Property: "x", Pattern: "("}}); err == nil {
+		t.Errorf("MetadataRules() accepted a bad pattern")
+	}
+}
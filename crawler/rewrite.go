@@ -0,0 +1,45 @@
+package crawler
+
+import (
+	"net/url"
+
+	"golang.org/x/net/html"
+)
+
+// NodeRewriter applies custom statication rules to HTML nodes, alongside the
+// crawler's built-in rules. Rewrite is called once for every node in a page,
+// after the built-in rules, and may modify the node in place. It returns any
+// further links found which should be crawled.
+type NodeRewriter interface {
+	Rewrite(n *html.Node, ctx RewriteContext) []url.URL
+}
+
+// NodeRewriterFunc adapts an ordinary function to the NodeRewriter interface.
+type NodeRewriterFunc func(n *html.Node, ctx RewriteContext) []url.URL
+
+func (f NodeRewriterFunc) Rewrite(n *html.Node, ctx RewriteContext) []url.URL {
+	return f(n, ctx)
+}
+
+// RewriteContext describes the page being staticated to a NodeRewriter.
+type RewriteContext struct {
+	Origin  string   // Hostname of the page being staticated.
+	Aliases []string // Other hostnames considered local.
+	c       *Crawler
+}
+
+// IsLocal reports whether a URL points at the site being crawled.
+func (rc RewriteContext) IsLocal(u *url.URL) bool {
+	return rc.c.isLocal(*u)
+}
+
+// Relativize turns a fully-qualified URL into a root-relative URL, under any
+// BasePath, unless that is deferred to serving.
+func (rc RewriteContext) Relativize(u *url.URL) {
+	rc.c.relativizeLocal(u)
+}
+
+// GetAttr finds a named attribute of an HTML node and returns a reference to it.
+func (rc RewriteContext) GetAttr(n *html.Node, name string) *html.Attribute {
+	return getAttr(n, name)
+}
@@ -0,0 +1,70 @@
+package crawler
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestNodeRewriter(t *testing.T) {
+	c := New("example.com", nil, nil)
+	c.quiet = true
+	c.NodeRewriters = []NodeRewriter{NodeRewriterFunc(func(n *html.Node, rc RewriteContext) []url.URL {
+		if n.Type != html.ElementNode {
+			return nil
+		}
+		// Strip a plugin's tracking attribute, and follow its lazy-loaded pages.
+		var links []url.URL
+		n.Attr = slices.DeleteFunc(n.Attr, func(a html.Attribute) bool {
+			if a.Key != "data-track" {
+				return false
+			}
+			if u, err := url.Parse(a.Val); err == nil && rc.IsLocal(u) {
+				links = append(links, *u)
+			}
+			return true
+		})
+		return links
+	})}
+	page, links := staticate(t, c, `<div class="x" data-track="https://example.com/more/">Hi</div>`)
+	if strings.Contains(page, "data-track") || !strings.Contains(page, `<div class="x">Hi</div>`) {
+		t.Errorf("Attribute not stripped:\n%s", page)
+	}
+	if want := []string{"https://example.com/more/"}; !slices.Equal(links, want) {
+		t.Errorf("Links = %q, want %q", links, want)
+	}
+}
+
+func TestNodeRewriterDeferred(t *testing.T) {
+	c := New("example.com", nil, nil)
+	c.quiet = true
+	c.DeferRelativize = true
+	// Promote a plugin's lazy link to a real one.
+	c.NodeRewriters = []NodeRewriter{NodeRewriterFunc(func(n *html.Node, rc RewriteContext) []url.URL {
+		a := rc.GetAttr(n, "data-href")
+		if a == nil {
+			return nil
+		}
+		u, err := url.Parse(a.Val)
+		if err != nil {
+			return nil
+		}
+		rc.Relativize(u)
+		a.Key, a.Val = "href", u.String()
+		return nil
+	})}
+	page, _ := staticate(t, c, `<a data-href="https://example.com/more/">More</a>`)
+	if !strings.Contains(page, `href="https://example.com/more/"`) {
+		t.Errorf("Relativized when crawled with DeferRelativize:\n%s", page)
+	}
+	got, err := RelativizePage([]byte(page), "https://example.com/", RelativizeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), `href="/more/"`) {
+		t.Errorf("Not relativized when served:\n%s", got)
+	}
+}
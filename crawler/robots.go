@@ -0,0 +1,159 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Product token by which robots.txt rules may single out the crawler.
+const ROBOTS_USER_AGENT = "polyester"
+
+// Longest robots.txt Crawl-delay honored, so a misconfigured host can't stall
+// a crawl.
+const MAX_ROBOTS_CRAWL_DELAY = time.Minute
+
+// Max size of robots.txt read. Google reads only the first 500KiB.
+const MAX_ROBOTS_SIZE = 500 << 10
+
+// X-Robots-Tag directives which take a value after a colon, so a colon
+// doesn't always introduce a user agent name.
+var robotsValueDirectives = map[string]bool{
+	"unavailable_after": true,
+	"max-snippet":       true,
+	"max-image-preview": true,
+	"max-video-preview": true,
+}
+
+// robotsTag returns the noindex and nofollow directives of a response's
+// X-Robots-Tag headers. Directives for a named user agent, e.g.
+// "googlebot: noindex", are ignored.
+func robotsTag(h http.Header) (noindex, nofollow bool) {
+	for _, v := range h.Values("X-Robots-Tag") {
+		agent := ""
+		for _, d := range strings.Split(v, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if name, rest, ok := strings.Cut(d, ":"); ok && !robotsValueDirectives[name] {
+				agent, d = strings.TrimSpace(name), strings.TrimSpace(rest)
+			}
+			if agent != "" {
+				continue
+			}
+			switch d {
+			case "noindex":
+				noindex = true
+			case "nofollow":
+				nofollow = true
+			case "none":
+				noindex, nofollow = true, true
+			}
+		}
+	}
+	return noindex, nofollow
+}
+
+// parseCrawlDelay returns the Crawl-delay of a robots.txt file for an agent:
+// that of a group naming the agent, if any, or else that of the "*" group.
+// Returns 0 if there is none.
+func parseCrawlDelay(r io.Reader, agent string) time.Duration {
+	var agents []string
+	inGroup := false // Whether the current group's User-agent lines have ended.
+	named := false   // Whether a group names the agent, overriding "*".
+	var own, all time.Duration
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		if k == "user-agent" {
+			if inGroup {
+				agents, inGroup = nil, false
+			}
+			// Compare product tokens, ignoring any version, e.g. "Polyester/1.0".
+			a, _, _ := strings.Cut(strings.ToLower(v), "/")
+			agents = append(agents, a)
+			named = named || a == agent
+			continue
+		}
+		inGroup = true
+		if k != "crawl-delay" {
+			continue
+		}
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(secs >= 0) {
+			continue
+		}
+		d := min(time.Duration(secs*float64(time.Second)), MAX_ROBOTS_CRAWL_DELAY)
+		for _, a := range agents {
+			switch a {
+			case agent:
+				own = d
+			case "*":
+				all = d
+			}
+		}
+	}
+	if named {
+		return own
+	}
+	return all
+}
+
+// robotsDelay is the Crawl-delay of a host, fetched once.
+type robotsDelay struct {
+	once  sync.Once
+	delay time.Duration
+}
+
+// robotsCrawlDelay returns the Crawl-delay set by robots.txt on the host of
+// u, fetching it on first use. Like the limiters, delays are kept by host
+// name, whatever the port.
+func (c *Crawler) robotsCrawlDelay(ctx context.Context, u url.URL) time.Duration {
+	c.muLimiters.Lock()
+	if c.robotsDelays == nil {
+		c.robotsDelays = map[string]*robotsDelay{}
+	}
+	d, ok := c.robotsDelays[u.Hostname()]
+	if !ok {
+		d = &robotsDelay{}
+		c.robotsDelays[u.Hostname()] = d
+	}
+	c.muLimiters.Unlock()
+	d.once.Do(func() { d.delay = c.fetchCrawlDelay(ctx, u) })
+	return d.delay
+}
+
+// fetchCrawlDelay fetches robots.txt from the host of u and returns its
+// Crawl-delay for ROBOTS_USER_AGENT. A missing or unreadable robots.txt sets
+// no delay.
+func (c *Crawler) fetchCrawlDelay(ctx context.Context, u url.URL) time.Duration {
+	robots := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robots.String(), nil)
+	if err != nil {
+		return 0
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Could not fetch %q for its Crawl-delay: %v\n", &robots, err)
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0
+	}
+	d := parseCrawlDelay(io.LimitReader(resp.Body, MAX_ROBOTS_SIZE), ROBOTS_USER_AGENT)
+	if d > c.CrawlDelay {
+		log.Printf("Spacing requests to %q by %v, the Crawl-delay in its robots.txt.\n", u.Hostname(), d)
+	}
+	return d
+}
@@ -0,0 +1,134 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsTagDirectives(t *testing.T) {
+	for _, tc := range []struct {
+		values            []string
+		noindex, nofollow bool
+	}{
+		{nil, false, false},
+		{[]string{"noindex"}, true, false},
+		{[]string{"NoIndex, NoFollow"}, true, true},
+		{[]string{"none"}, true, true},
+		{[]string{"noarchive", "nofollow"}, false, true},
+		{[]string{"max-snippet: 20, noindex"}, true, false},
+		// Directives for named agents are not ours.
+		{[]string{"googlebot: noindex, nofollow"}, false, false},
+		{[]string{"otherbot: noindex", "nofollow"}, false, true},
+	} {
+		h := http.Header{"X-Robots-Tag": tc.values}
+		if noindex, nofollow := robotsTag(h); noindex != tc.noindex || nofollow != tc.nofollow {
+			t.Errorf("robotsTag(%q) = %v, %v, want %v, %v", tc.values, noindex, nofollow, tc.noindex, tc.nofollow)
+		}
+	}
+}
+
+func TestRespectRobotsTag(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":        `<a href="/hidden/">1</a> <a href="/nofollow/">2</a>`,
+		"/linked/": "Linked",
+		"/behind/": "Behind",
+	})
+	tagged := func(tag, contentType, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Robots-Tag", tag)
+			w.Header().Set("Content-Type", contentType)
+			fmt.Fprint(w, body)
+		}
+	}
+	site.handle("/hidden/", tagged("noindex", "text/html", `<a href="/linked/">Linked</a>`))
+	site.handle("/nofollow/", tagged("nofollow", "text/html", `<a href="/behind/">Behind</a>`))
+	site.handle("/private.png", tagged("noindex", "image/png", "PNG"))
+
+	c, db := newTestCrawler(site)
+	c.RespectRobotsTag = true
+	stats := c.CrawlP(context.Background(), site.u("/"), 100, 1)
+	// A noindex page is not stored, but its links are still followed.
+	if got, want := storedKeys(t, db), []string{"/", "/linked/", "/nofollow/"}; !slices.Equal(got, want) {
+		t.Errorf("Stored %q, want %q", got, want)
+	}
+	if stats.Errors != 0 || site.fetches("/behind/") != 0 {
+		t.Errorf("CrawlP() = %+v, fetched /behind/ %d times, want no errors and no fetch", stats, site.fetches("/behind/"))
+	}
+
+	// Nor are noindex assets.
+	if err := c.CrawlOne(context.Background(), site.u("/private.png")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := db.Exists("/private.png"); ok {
+		t.Errorf("Stored a noindex asset")
+	}
+
+	// The header is ignored by default.
+	c, db = newTestCrawler(site)
+	c.CrawlP(context.Background(), site.u("/"), 100, 1)
+	if got := storedKeys(t, db); len(got) != 5 {
+		t.Errorf("Stored %q without RespectRobotsTag, want all 5", got)
+	}
+}
+
+func TestParseCrawlDelay(t *testing.T) {
+	for _, tc := range []struct {
+		robots string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"User-agent: *\nCrawl-delay: 2\n", 2 * time.Second},
+		{"User-agent: *\nCrawl-delay: 0.5 # Half a second.\n", 500 * time.Millisecond},
+		// A group naming us overrides "*", even without a delay of its own.
+		{"User-agent: *\nCrawl-delay: 2\n\nUser-agent: Polyester/1.0\nCrawl-delay: 1\n", time.Second},
+		{"User-agent: polyester\nDisallow: /private/\n\nUser-agent: *\nCrawl-delay: 2\n", 0},
+		{"User-agent: otherbot\nUser-agent: polyester\nCrawl-delay: 3\n", 3 * time.Second},
+		{"User-agent: otherbot\nCrawl-delay: 3\n", 0},
+		{"User-agent: *\nCrawl-delay: soon\n", 0},
+		{"User-agent: *\nCrawl-delay: -1\n", 0},
+		{"User-agent: *\nCrawl-delay: 86400\n", MAX_ROBOTS_CRAWL_DELAY},
+	} {
+		if got := parseCrawlDelay(strings.NewReader(tc.robots), ROBOTS_USER_AGENT); got != tc.want {
+			t.Errorf("parseCrawlDelay(%q) = %v, want %v", tc.robots, got, tc.want)
+		}
+	}
+}
+
+func TestRespectCrawlDelay(t *testing.T) {
+	const n = 6
+	site, requestTimes := fanOutSite(t, n)
+	site.file("/robots.txt", "text/plain", "User-agent: *\nCrawl-delay: 0.05\n")
+
+	c, _ := newTestCrawler(site)
+	const delay = 50 * time.Millisecond
+	c.RespectCrawlDelay = true
+	c.CrawlDelay = 10 * time.Millisecond
+	c.CrawlDelayJitter = 0.5
+	if stats := c.CrawlP(context.Background(), site.u("/"), 100, 4); stats.Fetched != n {
+		t.Fatalf("Fetched = %d, want %d", stats.Fetched, n)
+	}
+	if n := site.fetches("/robots.txt"); n != 1 {
+		t.Errorf("Fetched robots.txt %d times, want once", n)
+	}
+	// The robots.txt delay is longer, so it applies, and jitter only adds to
+	// it. Individual requests may arrive a little early or late relative to
+	// each other, so only the whole crawl is timed.
+	times := requestTimes()
+	const slop = 10 * time.Millisecond
+	if span, min := times[len(times)-1].Sub(times[0]), (n-1)*delay; span < min-slop {
+		t.Errorf("%d requests took %v, want at least %v", n, span, min)
+	}
+	// Limiters and robots.txt delays are both kept by host name.
+	u := site.u("/")
+	if _, ok := c.robotsDelays[u.Hostname()]; !ok {
+		t.Errorf("No robots.txt delay kept for %q", u.Hostname())
+	}
+	if l, ok := c.hostLimiters[u.Hostname()]; !ok || l.interval != delay || !l.minimum {
+		t.Errorf("Limiter for %q = %+v, want a minimum interval of %v", u.Hostname(), l, delay)
+	}
+}
@@ -0,0 +1,94 @@
+package crawler
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selector is a minimal CSS selector, matching elements by any combination
+// of tag name, id and classes, e.g. "div", "#comments", "aside.widget".
+type Selector struct {
+	Tag     string
+	ID      string
+	Classes []string
+}
+
+// Matches a simple selector: an optional tag name followed by #id and .class parts.
+var selectorRE = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*)?((?:[#.][a-zA-Z0-9_-]+)*)$`)
+
+// Matches a single #id or .class part of a selector.
+var selectorPartRE = regexp.MustCompile(`[#.][^#.]+`)
+
+// ParseSelector parses a selector of the form `tag#id.class1.class2`, where
+// each part is optional (but at least one is required).
+func ParseSelector(s string) (Selector, error) {
+	s = strings.TrimSpace(s)
+	m := selectorRE.FindStringSubmatch(s)
+	if s == "" || m == nil {
+		return Selector{}, fmt.Errorf("unsupported selector %q: only tag, #id and .class are supported", s)
+	}
+	sel := Selector{Tag: strings.ToLower(m[1])}
+	for _, part := range selectorPartRE.FindAllString(m[2], -1) {
+		if part[0] == '#' {
+			sel.ID = part[1:]
+		} else {
+			sel.Classes = append(sel.Classes, part[1:])
+		}
+	}
+	return sel, nil
+}
+
+// Match reports whether an element matches the selector.
+func (s Selector) Match(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if s.Tag != "" && n.Data != s.Tag {
+		return false
+	}
+	if s.ID != "" {
+		if a := getAttr(n, "id"); a == nil || a.Val != s.ID {
+			return false
+		}
+	}
+	if len(s.Classes) > 0 {
+		a := getAttr(n, "class")
+		if a == nil {
+			return false
+		}
+		classes := strings.Fields(a.Val)
+		for _, c := range s.Classes {
+			if !slices.Contains(classes, c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// excluded reports whether a node matches any of the crawler's exclude selectors.
+func (c *Crawler) excluded(n *html.Node) bool {
+	for _, s := range c.ExcludeSelectors {
+		if s.Match(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the selector in the form ParseSelector accepts.
+func (s Selector) String() string {
+	var b strings.Builder
+	b.WriteString(s.Tag)
+	if s.ID != "" {
+		b.WriteString("#" + s.ID)
+	}
+	for _, c := range s.Classes {
+		b.WriteString("." + c)
+	}
+	return b.String()
+}
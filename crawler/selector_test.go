@@ -0,0 +1,48 @@
+package crawler
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	for _, s := range []string{"div", "#comments", ".widget", "aside.widget.search", "DIV#main.x"} {
+		sel, err := ParseSelector(s)
+		if err != nil {
+			t.Errorf("ParseSelector(%q) = %v", s, err)
+			continue
+		}
+		if got := sel.String(); got != strings.Replace(s, "DIV", "div", 1) {
+			t.Errorf("ParseSelector(%q).String() = %q", s, got)
+		}
+	}
+	for _, s := range []string{"", "div p", "a[href]", "#", "ul > li"} {
+		if _, err := ParseSelector(s); err == nil {
+			t.Errorf("ParseSelector(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestExcludeSelectors(t *testing.T) {
+	c := New("example.com", nil, nil)
+	c.quiet = true
+	for _, s := range []string{"#comments", ".widget"} {
+		sel, _ := ParseSelector(s)
+		c.ExcludeSelectors = append(c.ExcludeSelectors, sel)
+	}
+	in := `<main><p>Post</p><div id="comments"><a href="https://example.com/reply/">Reply</a></div></main>` +
+		`<aside><div class="widget search"><form>Search</form></div><div class="widgets">Kept</div></aside>`
+	page, links := staticate(t, c, in)
+	for _, s := range []string{"comments", "Reply", "search", "Search"} {
+		if strings.Contains(page, s) {
+			t.Errorf("Staticated page still has %q:\n%s", s, page)
+		}
+	}
+	if !strings.Contains(page, "<p>Post</p>") || !strings.Contains(page, `<div class="widgets">Kept</div>`) {
+		t.Errorf("Staticated page lost content:\n%s", page)
+	}
+	if slices.Contains(links, "https://example.com/reply/") {
+		t.Errorf("Links in removed elements were followed: %q", links)
+	}
+}
@@ -0,0 +1,94 @@
+package crawler
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Sitemap priority of URLs which don't give one, as defined by sitemaps.org.
+const DEFAULT_SITEMAP_PRIORITY = 0.5
+
+// Max depth of nested sitemap indexes followed.
+const MAX_SITEMAP_DEPTH = 2
+
+// Seed is a URL to start a crawl from, along with the start URL. Seeds of
+// higher priority are fetched first.
+type Seed struct {
+	URL      url.URL
+	Priority float64
+}
+
+// sitemap holds either a sitemap's URLs or a sitemap index's sitemaps.
+type sitemap struct {
+	URLs []struct {
+		Loc      string `xml:"loc"`
+		Priority string `xml:"priority"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// FetchSitemap fetches an XML sitemap, or a sitemap index and the sitemaps
+// it lists, and returns their local URLs as seeds with their priorities.
+func (c *Crawler) FetchSitemap(ctx context.Context, u url.URL) ([]Seed, error) {
+	return c.fetchSitemap(ctx, u, 0)
+}
+
+func (c *Crawler) fetchSitemap(ctx context.Context, u url.URL, depth int) ([]Seed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching sitemap %q: HTTP %s", &u, resp.Status)
+	}
+	var sm sitemap
+	dec := xml.NewDecoder(resp.Body)
+	// Only ASCII URLs are used, so other encodings can be read as is.
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	if err := dec.Decode(&sm); err != nil {
+		return nil, fmt.Errorf("parsing sitemap %q: %w", &u, err)
+	}
+
+	var seeds []Seed
+	for _, s := range sm.URLs {
+		l, err := url.Parse(strings.TrimSpace(s.Loc))
+		if err != nil || !isWebScheme(l) || !c.isLocal(*l) {
+			continue
+		}
+		p, err := strconv.ParseFloat(strings.TrimSpace(s.Priority), 64)
+		if err != nil {
+			p = DEFAULT_SITEMAP_PRIORITY
+		}
+		seeds = append(seeds, Seed{URL: *u.ResolveReference(l), Priority: p})
+	}
+	for _, s := range sm.Sitemaps {
+		if depth >= MAX_SITEMAP_DEPTH {
+			log.Printf("Skipping sitemap %q: nested too deeply\n", s.Loc)
+			continue
+		}
+		l, err := url.Parse(strings.TrimSpace(s.Loc))
+		if err != nil || !isWebScheme(l) {
+			continue
+		}
+		more, err := c.fetchSitemap(ctx, *u.ResolveReference(l), depth+1)
+		if err != nil {
+			return seeds, err
+		}
+		seeds = append(seeds, more...)
+	}
+	return seeds, nil
+}
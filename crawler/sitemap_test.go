@@ -0,0 +1,63 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestFetchSitemap(t *testing.T) {
+	site := newTestSite(t, nil)
+	site.file("/sitemap_index.xml", "application/xml", `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>`+site.URL+`/posts.xml</loc></sitemap>
+</sitemapindex>`)
+	site.file("/posts.xml", "application/xml", `<?xml version="1.0" encoding="ISO-8859-1"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>`+site.URL+`/</loc><priority>1.0</priority></url>
+  <url><loc> `+site.URL+`/about/ </loc></url>
+  <url><loc>https://elsewhere.example/</loc><priority>0.9</priority></url>
+  <url><loc>`+site.URL+`/old/</loc><priority>0.1</priority></url>
+</urlset>`)
+	c, _ := newTestCrawler(site)
+	seeds, err := c.FetchSitemap(context.Background(), site.u("/sitemap_index.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Seed{{site.u("/"), 1}, {site.u("/about/"), DEFAULT_SITEMAP_PRIORITY}, {site.u("/old/"), 0.1}}
+	if !slices.EqualFunc(seeds, want, func(a, b Seed) bool { return a.URL == b.URL && a.Priority == b.Priority }) {
+		t.Errorf("FetchSitemap() = %v, want %v", seeds, want)
+	}
+
+	if _, err := c.FetchSitemap(context.Background(), site.u("/missing.xml")); err == nil {
+		t.Errorf("FetchSitemap() of a missing sitemap succeeded")
+	}
+}
+
+func TestSeedPriority(t *testing.T) {
+	site := newTestSite(t, nil)
+	var mu sync.Mutex
+	var order []string
+	for _, p := range []string{"/", "/low/", "/mid/", "/high/", "/linked/"} {
+		site.handle(p, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			order = append(order, r.URL.Path)
+			mu.Unlock()
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/linked/">Linked</a>`))
+		})
+	}
+	c, _ := newTestCrawler(site)
+	c.Seeds = []Seed{{site.u("/low/"), 0.1}, {site.u("/high/"), 0.9}, {site.u("/mid/"), 0.5}}
+	stats := c.CrawlP(context.Background(), site.u("/"), 3, 1)
+
+	// The start URL has the priority of a link, so is fetched after seeds.
+	if want := []string{"/high/", "/mid/", "/"}; !slices.Equal(order, want) {
+		t.Errorf("Fetched %q, want %q", order, want)
+	}
+	if want := []string{"/linked/", "/low/"}; !slices.Equal(stats.Unvisited, want) {
+		t.Errorf("Unvisited = %q, want %q", stats.Unvisited, want)
+	}
+}
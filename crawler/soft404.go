@@ -0,0 +1,56 @@
+package crawler
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// errSoft404 is returned when a page fetched successfully looks like a
+// "not found" page.
+var errSoft404 = errors.New("page looks like a soft 404")
+
+// Soft404Detector identifies "not found" pages which are served with a
+// 200 status by some CMSes.
+type Soft404Detector struct {
+	Title []*regexp.Regexp // Matched against the text of the page's <title>.
+	Body  []*regexp.Regexp // Matched against the raw HTML of the page.
+}
+
+// Match reports whether the raw HTML `body`, parsed into `doc`, is a soft 404.
+func (d *Soft404Detector) Match(body []byte, doc *html.Node) bool {
+	if len(d.Title) > 0 {
+		title := pageTitle(doc)
+		for _, re := range d.Title {
+			if re.MatchString(title) {
+				return true
+			}
+		}
+	}
+	for _, re := range d.Body {
+		if re.Match(body) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageTitle returns the text content of the first <title> element in a document.
+func pageTitle(doc *html.Node) string {
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode || n.DataAtom != atom.Title {
+			continue
+		}
+		var b strings.Builder
+		for x := n.FirstChild; x != nil; x = x.NextSibling {
+			if x.Type == html.TextNode {
+				b.WriteString(x.Data)
+			}
+		}
+		return strings.TrimSpace(b.String())
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestSoft404(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":         `<title>Home</title><a href="/gone/">Gone</a> <a href="/missing/">Missing</a>`,
+		"/gone/":    `<title>Page not found &#8211; Example</title><p>Sorry.</p>`,
+		"/missing/": `<title>Missing</title><div class="error404">Nothing here</div>`,
+	})
+	c, db := newTestCrawler(site)
+	c.Soft404 = &Soft404Detector{
+		Title: []*regexp.Regexp{regexp.MustCompile(`(?i)^page not found`)},
+		Body:  []*regexp.Regexp{regexp.MustCompile(`class="error404"`)},
+	}
+	stats := c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	if keys := storedKeys(t, db); !slices.Equal(keys, []string{"/"}) {
+		t.Errorf("Stored %q, want only /", keys)
+	}
+	if stats.Errors != 2 {
+		t.Errorf("Got %d errors, want 2 soft 404s", stats.Errors)
+	}
+
+	_, _, err := c.Fetch(context.Background(), site.u("/gone/"))
+	if !errors.Is(err, errSoft404) {
+		t.Errorf("Fetch(/gone/) error = %v, want %v", err, errSoft404)
+	}
+}
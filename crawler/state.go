@@ -0,0 +1,68 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// crawlState is the state of a crawler persisted between runs.
+type crawlState struct {
+	Seen      []string `json:"seen"`      // Keys of URLs already fetched.
+	Unvisited []string `json:"unvisited"` // Keys of URLs found but not yet fetched, or which failed.
+}
+
+// SaveState writes the set of URLs seen so far, and those found but left
+// unvisited by the last crawl, so that a later run can carry on from here.
+func (c *Crawler) SaveState(w io.Writer) error {
+	c.muSeen.Lock()
+	defer c.muSeen.Unlock()
+	if c.bloom != nil {
+		return errBloomSeen
+	}
+	s := crawlState{Seen: sortedKeys(c.seen), Unvisited: c.unvisited}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(s)
+}
+
+// LoadState restores state written by SaveState. URLs seen in earlier runs are
+// not fetched again, and URLs left unvisited are queued at the start of the
+// next crawl.
+func (c *Crawler) LoadState(r io.Reader) error {
+	var s crawlState
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+	c.muSeen.Lock()
+	defer c.muSeen.Unlock()
+	for _, k := range s.Seen {
+		if c.bloom != nil {
+			c.bloom.Add(k)
+			continue
+		}
+		c.seen[k] = struct{}{}
+	}
+	c.unvisited = s.Unvisited
+	return nil
+}
+
+// resumeURLs returns the URLs left unvisited by a previous run, resolved
+// against the crawl's start URL.
+func (c *Crawler) resumeURLs(start url.URL) []url.URL {
+	c.muSeen.Lock()
+	defer c.muSeen.Unlock()
+	var urls []url.URL
+	for _, k := range c.unvisited {
+		if strings.HasPrefix(k, EXTERNAL_KEY_PREFIX) {
+			continue
+		}
+		ref, err := url.Parse(k)
+		if err != nil {
+			continue
+		}
+		urls = append(urls, *start.ResolveReference(ref))
+	}
+	return urls
+}
@@ -0,0 +1,59 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSaveLoadState(t *testing.T) {
+	site := newTestSite(t, map[string]string{
+		"/":   `<a href="/a/">A</a> <a href="/flaky/">Flaky</a> <a href="/b/">B</a>`,
+		"/a/": `<a href="/">Home</a>`,
+		"/b/": `<a href="/c/">C</a>`,
+		"/c/": "C",
+	})
+	var down atomic.Bool
+	down.Store(true)
+	site.handle("/flaky/", func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			abort(w, r)
+		}
+		w.Write([]byte("Flaky"))
+	})
+
+	c, _ := newTestCrawler(site)
+	stats := c.CrawlP(context.Background(), site.u("/"), 3, 1)
+	if !slices.Equal(stats.Visited, []string{"/", "/a/", "/flaky/"}) || stats.Errors != 1 {
+		t.Fatalf("First CrawlP() = %+v", stats)
+	}
+	var state bytes.Buffer
+	if err := c.SaveState(&state); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+
+	down.Store(false)
+
+	// The second run starts from the start URL again, to find new links,
+	// fetches what was left unvisited and retries what failed, but fetches
+	// nothing else again.
+	c, db := newTestCrawler(site)
+	if err := c.LoadState(&state); err != nil {
+		t.Fatalf("LoadState() = %v", err)
+	}
+	stats = c.CrawlP(context.Background(), site.u("/"), 10, 1)
+	if want := []string{"/", "/b/", "/c/", "/flaky/"}; !slices.Equal(storedKeys(t, db), want) {
+		t.Errorf("Second crawl stored %q, want %q", storedKeys(t, db), want)
+	}
+	for p, want := range map[string]int{"/": 2, "/a/": 1, "/b/": 1, "/c/": 1} {
+		if got := site.fetches(p); got != want {
+			t.Errorf("Fetched %q %d times, want %d", p, got, want)
+		}
+	}
+	if got := site.fetches("/flaky/"); got < 2 {
+		t.Errorf("Fetched /flaky/ %d times, want it retried", got)
+	}
+}
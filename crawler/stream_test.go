@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/TheSnook/polyester/storage"
+)
+
+// streamingStorage is a MemStorage which can stream writes, recording the
+// keys streamed.
+type streamingStorage struct {
+	*storage.MemStorage
+	mu       sync.Mutex
+	streamed []string
+}
+
+func (s *streamingStorage) WriteStream(k string, r *resource.Resource, body io.Reader) error {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.streamed = append(s.streamed, k)
+	s.mu.Unlock()
+	r.Content = content
+	return s.MemStorage.Write(k, r)
+}
+
+// largeFile serves a path with a body of STREAM_MIN_SIZE bytes, with a
+// Content-Length unless chunked.
+func largeFile(site *testSite, path, contentType string, chunked bool) []byte {
+	body := bytes.Repeat([]byte("0123456789abcdef"), STREAM_MIN_SIZE/16)
+	site.handle(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if !chunked {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.Write(body)
+	})
+	return body
+}
+
+func TestStreamLargeAsset(t *testing.T) {
+	site := newTestSite(t, nil)
+	want := largeFile(site, "/video.mp4", "video/mp4", false)
+	db := storage.New("bbolt:" + filepath.Join(t.TempDir(), "site.db") + ":polyester")
+	defer db.Close()
+	u := site.u("/")
+	c := New(u.Hostname(), nil, db)
+	if err := c.CrawlOne(context.Background(), site.u("/video.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Read("/video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Content, want) || r.ContentType != "video/mp4" || r.CrawledAt == nil {
+		t.Errorf("Stored %d bytes of %q crawled at %v, want %d bytes of video/mp4 with a crawl time", len(r.Content), r.ContentType, r.CrawledAt, len(want))
+	}
+}
+
+func TestStreamOnlyUnprocessedContent(t *testing.T) {
+	site := newTestSite(t, nil)
+	largeFile(site, "/large.png", "image/png", false)
+	largeFile(site, "/chunked.png", "image/png", true)
+	largeFile(site, "/style.css", "text/css", false)
+	largeFile(site, "/feed/", "application/rss+xml", false)
+	largeFile(site, "/site.webmanifest", "application/manifest+json", false)
+	site.file("/small.png", "image/png", "PNG")
+	site.handle("/cut.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(2*STREAM_MIN_SIZE))
+		w.Write(make([]byte, STREAM_MIN_SIZE))
+		abort(w, r)
+	})
+	u := site.u("/")
+	db := &streamingStorage{MemStorage: storage.NewMem()}
+	c := New(u.Hostname(), nil, db)
+	c.RewriteCSS = true
+	c.CaptureIcons = true
+	for _, p := range []string{"/large.png", "/chunked.png", "/style.css", "/feed/", "/site.webmanifest", "/small.png"} {
+		if err := c.CrawlOne(context.Background(), site.u(p)); err != nil {
+			t.Errorf("CrawlOne(%s) = %v", p, err)
+		}
+	}
+	if want := []string{"/large.png"}; !slices.Equal(db.streamed, want) {
+		t.Errorf("Streamed %q, want %q", db.streamed, want)
+	}
+	if got, want := storedKeys(t, db), []string{"/chunked.png", "/feed/", "/large.png", "/site.webmanifest", "/small.png", "/style.css"}; !slices.Equal(got, want) {
+		t.Errorf("Stored %q, want %q", got, want)
+	}
+
+	// A body cut short is retried, then reported, and nothing is stored.
+	if err := c.CrawlOne(context.Background(), site.u("/cut.png")); !errors.Is(err, errTruncated) {
+		t.Errorf("CrawlOne(/cut.png) = %v, want errTruncated", err)
+	}
+	if ok, _ := db.Exists("/cut.png"); ok {
+		t.Errorf("Stored a truncated stream")
+	}
+
+	// With a Manifest, content is hashed before it is stored.
+	c.Manifest = NewContentManifest()
+	db.streamed = nil
+	if err := c.CrawlOne(context.Background(), site.u("/large.png")); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.streamed) != 0 {
+		t.Errorf("Streamed %q with a Manifest", db.streamed)
+	}
+}
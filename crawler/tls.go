@@ -0,0 +1,43 @@
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// isPrivateAddr reports whether a network address is a loopback, link-local
+// or private (e.g. RFC 1918) IP address.
+func isPrivateAddr(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcp.IP
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+// dialTLS connects to a host, verifying its certificate unless
+// AllowInsecureLocal is set and it resolved to a private address, e.g. a
+// staging server with a self-signed certificate.
+func (c *Crawler) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: c.AllowInsecureLocal && isPrivateAddr(conn.RemoteAddr()),
+	}
+	tc := tls.Client(conn, conf)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,61 @@
+package crawler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestIsPrivateAddr(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.1.1", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"2001:4860:4860::8888", false},
+	} {
+		addr := &net.TCPAddr{IP: net.ParseIP(tc.ip), Port: 443}
+		if got := isPrivateAddr(addr); got != tc.want {
+			t.Errorf("isPrivateAddr(%s) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+	if isPrivateAddr(&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}) {
+		t.Errorf("isPrivateAddr(unix socket) = true, want false")
+	}
+}
+
+func TestAllowInsecureLocal(t *testing.T) {
+	// A self-signed certificate, on a loopback address.
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>Staging</p>"))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+
+	c, _ := newTestCrawler(&testSite{Server: srv})
+	if err := c.CrawlOne(context.Background(), *u); err == nil {
+		t.Errorf("Fetched from a server with an unverifiable certificate without AllowInsecureLocal")
+	}
+
+	c, db := newTestCrawler(&testSite{Server: srv})
+	c.AllowInsecureLocal = true
+	if err := c.CrawlOne(context.Background(), *u); err != nil {
+		t.Fatalf("CrawlOne() with AllowInsecureLocal = %v", err)
+	}
+	if r, err := db.Read("/"); err != nil || string(r.Content) != "<html><head></head><body><p>Staging</p></body></html>" {
+		t.Errorf("Stored %v, %v", r, err)
+	}
+}
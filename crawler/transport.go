@@ -0,0 +1,56 @@
+package crawler
+
+import (
+	"net/http"
+	"time"
+)
+
+// Connection pool defaults for the crawler's own transport. Idle connections
+// per host are raised to the crawl's parallelism by CrawlP, unless tuned.
+const (
+	DEFAULT_MAX_IDLE_CONNS    = 100
+	DEFAULT_IDLE_CONN_TIMEOUT = 90 * time.Second
+)
+
+// TransportTuning sets the connection pool limits of the crawler's HTTP
+// transport. Zero values leave the current setting.
+type TransportTuning struct {
+	MaxIdleConns        int           // Idle connections kept across all hosts.
+	MaxIdleConnsPerHost int           // Idle connections kept to each host.
+	MaxConnsPerHost     int           // Connections, idle or active, to each host.
+	IdleConnTimeout     time.Duration // How long an idle connection is kept.
+}
+
+// TuneTransport applies connection pool limits to the crawler's transport.
+// It has no effect if the transport was replaced with SetTransport, and must
+// not be called while a crawl is running.
+func (c *Crawler) TuneTransport(t TransportTuning) {
+	tr, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	if t.MaxIdleConns > 0 {
+		tr.MaxIdleConns = t.MaxIdleConns
+	}
+	if t.MaxIdleConnsPerHost > 0 {
+		tr.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+		c.idleConnsTuned = true
+	}
+	if t.MaxConnsPerHost > 0 {
+		tr.MaxConnsPerHost = t.MaxConnsPerHost
+	}
+	if t.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = t.IdleConnTimeout
+	}
+}
+
+// fitIdleConns keeps enough idle connections per host for maxP concurrent
+// fetches to reuse them, rather than the net/http default of 2, unless set
+// with TuneTransport.
+func (c *Crawler) fitIdleConns(maxP int) {
+	tr, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok || c.idleConnsTuned || tr.MaxIdleConnsPerHost >= maxP {
+		return
+	}
+	tr.MaxIdleConnsPerHost = maxP
+}
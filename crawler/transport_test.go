@@ -0,0 +1,205 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TheSnook/polyester/storage"
+)
+
+// cannedResponse is a response served by a mockTransport.
+type cannedResponse struct {
+	status      int
+	contentType string
+	location    string
+	body        string
+}
+
+// mockTransport answers requests with canned responses by URL, and 404s for
+// anything else, without any network access. It records the URLs requested.
+type mockTransport struct {
+	responses map[string]cannedResponse
+	mu        sync.Mutex
+	requested []string
+}
+
+func (m *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m.mu.Lock()
+	m.requested = append(m.requested, req.URL.String())
+	m.mu.Unlock()
+	c, ok := m.responses[req.URL.String()]
+	if !ok {
+		c = cannedResponse{status: http.StatusNotFound, contentType: "text/plain", body: "Not found"}
+	}
+	h := http.Header{}
+	if c.contentType != "" {
+		h.Set("Content-Type", c.contentType)
+	}
+	if c.location != "" {
+		h.Set("Location", c.location)
+	}
+	return &http.Response{
+		StatusCode:    c.status,
+		Header:        h,
+		Body:          io.NopCloser(strings.NewReader(c.body)),
+		ContentLength: int64(len(c.body)),
+		Request:       req,
+	}, nil
+}
+
+func newMockCrawler(responses map[string]cannedResponse) (*Crawler, *mockTransport, *storage.MemStorage) {
+	db := storage.NewMem()
+	c := New("example.com", nil, db)
+	m := &mockTransport{responses: responses}
+	c.SetTransport(m)
+	return c, m, db
+}
+
+func mustParse(t *testing.T, s string) url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *u
+}
+
+func TestMockProcessURL(t *testing.T) {
+	c, m, _ := newMockCrawler(map[string]cannedResponse{
+		"https://example.com/": {status: 200, contentType: "text/html", body: `<a href="https://example.com/about/">About</a><a href="https://elsewhere.org/">Elsewhere</a>`},
+	})
+	res, links, err := c.processURL(context.Background(), mustParse(t, "https://example.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(res.Content), `<a href="/about/">About</a><a href="https://elsewhere.org/">`) {
+		t.Errorf("Content = %s", res.Content)
+	}
+	var got []string
+	for _, l := range links {
+		got = append(got, l.String())
+	}
+	if want := []string{"https://example.com/about/"}; !slices.Equal(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+	if want := []string{"https://example.com/"}; !slices.Equal(m.requested, want) {
+		t.Errorf("Requested %q, want %q", m.requested, want)
+	}
+}
+
+func TestMockFollowRedirects(t *testing.T) {
+	c, m, db := newMockCrawler(map[string]cannedResponse{
+		"https://example.com/a/":     {status: 301, location: "/b/"},
+		"https://example.com/b/":     {status: 302, location: "https://www.example.com/c/"},
+		"https://www.example.com/c/": {status: 200, contentType: "image/png", body: "PNG"},
+		"https://example.com/x/":     {status: 301, location: "https://elsewhere.org/x/"},
+	})
+	l, resp := c.followRedirects(mustParse(t, "https://example.com/a/"))
+	if resp == nil {
+		t.Fatalf("followRedirects() returned no response")
+	}
+	resp.Body.Close()
+	if l.String() != "https://www.example.com/c/" {
+		t.Errorf("followRedirects() ended at %q", l)
+	}
+	for k, want := range map[string]string{"/a/": "/b/", "/b/": "/c/"} {
+		if r, err := db.Read(k); err != nil || r.Redirect != want {
+			t.Errorf("Stored %s = %v, %v, want a redirect to %s", k, r, err, want)
+		}
+	}
+
+	// Off-site redirects are stored, but not followed.
+	l, resp = c.followRedirects(mustParse(t, "https://example.com/x/"))
+	if resp != nil || l.String() != "https://elsewhere.org/x/" {
+		t.Errorf("followRedirects() to off-site = %v, %v", l, resp)
+	}
+	if r, err := db.Read("/x/"); err != nil || r.Redirect != "https://elsewhere.org/x/" {
+		t.Errorf("Stored /x/ = %v, %v", r, err)
+	}
+	if slices.Contains(m.requested, "https://elsewhere.org/x/") {
+		t.Errorf("Fetched off-site redirect target")
+	}
+}
+
+func TestMockSaveRaw(t *testing.T) {
+	c, _, db := newMockCrawler(map[string]cannedResponse{
+		"https://example.com/old.css":   {status: 301, location: "/style.css"},
+		"https://example.com/style.css": {status: 200, contentType: "text/css", body: "a{}"},
+	})
+	h, err := c.saveRaw(mustParse(t, "https://example.com/old.css"), func(b []byte) ([]byte, error) {
+		return append(b, "/* mirrored */"...), nil
+	})
+	if err != nil || h == nil {
+		t.Fatalf("saveRaw() = %v, %v", h, err)
+	}
+	if r, err := db.Read("/style.css"); err != nil || string(r.Content) != "a{}/* mirrored */" || r.ContentType != "text/css" {
+		t.Errorf("Stored /style.css = %v, %v", r, err)
+	}
+	if r, err := db.Read("/old.css"); err != nil || r.Redirect != "/style.css" {
+		t.Errorf("Stored /old.css = %v, %v", r, err)
+	}
+
+	// A seen URL is fetched again when asked for, but redirects to one are
+	// not followed.
+	if h, err := c.saveRaw(mustParse(t, "https://example.com/style.css"), nil); h == nil || err != nil {
+		t.Errorf("saveRaw() of a seen URL = %v, %v, want it fetched", h, err)
+	}
+	if h, err := c.saveRaw(mustParse(t, "https://example.com/old.css"), nil); h != nil || err != nil {
+		t.Errorf("saveRaw() of a redirect to a seen URL = %v, %v, want nothing", h, err)
+	}
+
+	if _, err := c.saveRaw(mustParse(t, "https://example.com/missing.png"), nil); err == nil {
+		t.Errorf("saveRaw() of a 404 succeeded")
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	const n, maxP = 40, 4
+	site := newTestSite(t, nil)
+	var mu sync.Mutex
+	conns := map[string]bool{}
+	var links strings.Builder
+	for i := range n {
+		fmt.Fprintf(&links, `<a href="/p%d/">p%d</a>`, i, i)
+		site.handle(fmt.Sprintf("/p%d/", i), func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			conns[r.RemoteAddr] = true
+			mu.Unlock()
+			time.Sleep(2 * time.Millisecond)
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<p>Page</p>")
+		})
+	}
+	site.page("/", links.String())
+
+	c, _ := newTestCrawler(site)
+	stats := c.CrawlP(context.Background(), site.u("/"), 100, maxP)
+	if stats.Fetched != n+1 {
+		t.Fatalf("CrawlP() = %+v, want %d fetched", stats, n+1)
+	}
+	// Each worker keeps reusing its connection, rather than one being
+	// opened per request as the net/http default of 2 idle connections per
+	// host would cause. A few more may be dialed while one is being freed.
+	if len(conns) > 2*maxP {
+		t.Errorf("Opened %d connections for %d requests with %d in parallel", len(conns), n, maxP)
+	}
+	if tr := c.httpClient.Transport.(*http.Transport); tr.MaxIdleConnsPerHost != maxP {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", tr.MaxIdleConnsPerHost, maxP)
+	}
+
+	// An explicit setting is kept.
+	c, _ = newTestCrawler(site)
+	c.TuneTransport(TransportTuning{MaxIdleConnsPerHost: 1, IdleConnTimeout: time.Second})
+	c.CrawlP(context.Background(), site.u("/"), 2, maxP)
+	if tr := c.httpClient.Transport.(*http.Transport); tr.MaxIdleConnsPerHost != 1 || tr.IdleConnTimeout != time.Second {
+		t.Errorf("Tuned transport changed to %d idle conns per host and %v timeout", tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+	}
+}
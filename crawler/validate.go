@@ -0,0 +1,48 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// errInvalidPage is returned when a page fetched successfully fails
+// validation, e.g. because it is an error page.
+var errInvalidPage = errors.New("page failed validation")
+
+// PageValidator checks that a page looks like real content, rather than e.g.
+// a CDN's branded error page served with a 200 status while the origin is
+// down. Such pages must not replace good stored copies.
+type PageValidator struct {
+	MinSize      int              // Min size in bytes of the raw HTML.
+	Require      []Selector       // Elements every good page has, e.g. "#content".
+	ErrorMarkers []*regexp.Regexp // Matched against the raw HTML of error pages.
+}
+
+// Check returns why the raw HTML `body`, parsed into `doc`, is not a good
+// page, or nil if it is.
+func (v *PageValidator) Check(body []byte, doc *html.Node) error {
+	if len(body) < v.MinSize {
+		return fmt.Errorf("%w: only %d bytes", errInvalidPage, len(body))
+	}
+	for _, s := range v.Require {
+		found := false
+		for n := range doc.Descendants() {
+			if s.Match(n) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: no element matches %q", errInvalidPage, s)
+		}
+	}
+	for _, re := range v.ErrorMarkers {
+		if re.Match(body) {
+			return fmt.Errorf("%w: matches error marker %q", errInvalidPage, re)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+)
+
+func TestPageValidator(t *testing.T) {
+	sel, err := ParseSelector("#content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := `<html><body><div id="content">` + strings.Repeat("<p>Words.</p>", 20) + `</div></body></html>`
+	site := newTestSite(t, map[string]string{
+		"/good/": good,
+		"/tiny/": `<p>Oops</p>`,
+		"/cdn/":  `<html><body><h1>Error 522: Connection timed out</h1>` + strings.Repeat("<p>Branding.</p>", 20) + `</body></html>`,
+		"/bare/": `<html><body>` + strings.Repeat("<p>Words.</p>", 20) + `</body></html>`,
+	})
+	c, db := newTestCrawler(site)
+	// Good copies stored by an earlier crawl.
+	for _, k := range []string{"/tiny/", "/cdn/", "/bare/"} {
+		if err := db.Write(k, &resource.Resource{ContentType: "text/html", Content: []byte(good)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Validator = &PageValidator{
+		MinSize:      100,
+		Require:      []Selector{sel},
+		ErrorMarkers: []*regexp.Regexp{regexp.MustCompile(`Error 5\d\d`)},
+	}
+	for p, want := range map[string]string{
+		"/good/": "",
+		"/tiny/": "only 11 bytes",
+		"/cdn/":  `no element matches "#content"`,
+		"/bare/": `no element matches "#content"`,
+	} {
+		err := c.CrawlOne(context.Background(), site.u(p))
+		if want == "" {
+			if err != nil {
+				t.Errorf("CrawlOne(%s) = %v", p, err)
+			}
+			continue
+		}
+		if !errors.Is(err, errInvalidPage) || !strings.Contains(err.Error(), want) {
+			t.Errorf("CrawlOne(%s) = %v, want %s", p, err, want)
+		}
+		// The earlier copy is kept.
+		if r, err := db.Read(p); err != nil || string(r.GetContent()) != good {
+			t.Errorf("Read(%s) = %v, %v, want the good copy", p, r, err)
+		}
+	}
+
+	// Error markers are checked too.
+	c.Validator.Require = nil
+	if err := c.CrawlOne(context.Background(), site.u("/cdn/")); err == nil || !strings.Contains(err.Error(), "error marker") {
+		t.Errorf("CrawlOne(/cdn/) = %v, want an error marker match", err)
+	}
+}
@@ -2,9 +2,16 @@ module github.com/TheSnook/polyester
 
 go 1.23
 
-require github.com/aws/aws-sdk-go v1.55.6
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/aws/aws-sdk-go v1.55.6
+	golang.org/x/time v0.9.0
+)
 
-require gopkg.in/yaml.v2 v2.4.0 // indirect
+require (
+	golang.org/x/text v0.21.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+)
 
 require (
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
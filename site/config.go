@@ -2,6 +2,12 @@ package site
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
 
 	yaml "gopkg.in/yaml.v3"
 )
@@ -12,6 +18,40 @@ type Config struct {
 	//       (E.g. don't recurse into the published static site, but do relativize any links to it)
 	Domains   []string
 	Resources []Resource
+	// Optional patterns to detect "not found" pages served with a 200 status.
+	Soft404 Soft404
+	// Optional values to extract from every page and store with it.
+	Metadata []Metadata
+	// Optional checks that each page is real content, so that e.g. a CDN's
+	// error page doesn't replace a good stored copy.
+	Validation Validation
+	// Optional JSON API endpoints to capture after a crawl, e.g. for a
+	// JavaScript frontend which reads content from the WordPress REST API.
+	APIs []API
+}
+
+// API is a JSON API endpoint, e.g. "/wp-json/wp/v2/posts", fetched along
+// with any further pages linked by `Link: <...>; rel="next"` headers.
+type API struct {
+	Path string
+	// Rewrite local URLs in the JSON to point at the publish domain, or
+	// relativize them.
+	Relativize bool
+	// Optional limit on the pages of results fetched. Zero means no limit.
+	MaxPages int
+}
+
+// Validation describes what every good page looks like.
+type Validation struct {
+	MinSize      int      // Min size in bytes of the raw HTML.
+	Require      []string // Selectors of elements every page has, e.g. "#content".
+	ErrorMarkers []string // Matched against the raw HTML of error pages.
+}
+
+// Soft404 lists regular expressions which identify a "not found" page.
+type Soft404 struct {
+	Title []string // Matched against the page title.
+	Body  []string // Matched against the raw page HTML.
 }
 
 type Resource struct {
@@ -20,17 +60,113 @@ type Resource struct {
 	Follow   []string
 	Metadata []Metadata
 	Related  []Resource
+	// Optional limits on pages fetched for this resource and its `Follow`
+	// patterns, within the overall fetch limit. Zero means no limit.
+	FetchLimit int
+	// How many `Follow` links deep to crawl from this resource. Zero means no limit.
+	MaxDepth int
 }
 
 type Metadata struct {
+	// Property is a `<meta property=...>` or `<meta name=...>` tag, or
+	// "title" for the page title.
 	Var, Property string
+	// Optional regular expression applied to the property value. Its named
+	// capture groups become additional variables.
+	Pattern string
+}
+
+// Matches a `${VAR}` environment variable reference.
+var envRefRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces `${VAR}` references in every string value of a decoded
+// config with the values of environment variables, so that values can't
+// change the structure of the config. Unset variables expand to "", unless
+// `strict` is set, in which case they are an error. Bare `$VAR` references
+// are left alone, as `$` is common in regular expressions.
+func expandEnv(c *Config, strict bool) error {
+	var missing []string
+	expand := func(s string) string {
+		return envRefRE.ReplaceAllStringFunc(s, func(ref string) string {
+			name := envRefRE.FindStringSubmatch(ref)[1]
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return v
+		})
+	}
+	expandStrings(reflect.ValueOf(c).Elem(), expand)
+	if strict && len(missing) > 0 {
+		return fmt.Errorf("environment variables referenced but not set: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// expandStrings applies `expand` to every string reachable from `v`, in
+// place.
+func expandStrings(v reflect.Value, expand func(string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(expand(v.String()))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				expandStrings(v.Field(i), expand)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandStrings(v.Index(i), expand)
+		}
+	}
+}
+
+// LoadOptions control how a site config is parsed.
+type LoadOptions struct {
+	JSON      bool // Parse JSON rather than YAML.
+	StrictEnv bool // Fail if any referenced environment variable is not set.
 }
 
+// Load parses and validates a YAML site config, expanding any `${VAR}`
+// references to environment variables in its string values. Unset variables
+// expand to "".
 func Load(in []byte) (*Config, error) {
+	return LoadWith(in, LoadOptions{})
+}
+
+// LoadStrict is like Load, but fails if any referenced environment variable
+// is not set.
+func LoadStrict(in []byte) (*Config, error) {
+	return LoadWith(in, LoadOptions{StrictEnv: true})
+}
+
+// LoadJSON is like Load, but parses a JSON site config.
+func LoadJSON(in []byte) (*Config, error) {
+	return LoadWith(in, LoadOptions{JSON: true})
+}
+
+// LoadWith parses and validates a site config according to `opts`.
+// Unknown fields are an error in either format.
+func LoadWith(in []byte, opts LoadOptions) (*Config, error) {
+	var err error
 	out := Config{}
-	d := yaml.NewDecoder(bytes.NewReader(in))
-	d.KnownFields(true)
-	if err := d.Decode(&out); err != nil {
+	if opts.JSON {
+		d := json.NewDecoder(bytes.NewReader(in))
+		d.DisallowUnknownFields()
+		err = d.Decode(&out)
+	} else {
+		d := yaml.NewDecoder(bytes.NewReader(in))
+		d.KnownFields(true)
+		err = d.Decode(&out)
+	}
+	if err != nil {
+		return &Config{}, err
+	}
+	if err := expandEnv(&out, opts.StrictEnv); err != nil {
+		return &Config{}, err
+	}
+	if err := out.Validate(); err != nil {
 		return &Config{}, err
 	}
 	return &out, nil
@@ -0,0 +1,92 @@
+package site
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("POLYESTER_TEST_DOMAIN", "blog.example.com")
+	// A value which would change the structure of the YAML if substituted
+	// into it before parsing.
+	t.Setenv("POLYESTER_TEST_NAME", "x\ndomains: [evil.example.net]")
+	in := []byte(`
+name: "${POLYESTER_TEST_NAME}"
+domains:
+  - ${POLYESTER_TEST_DOMAIN}
+  - www.${POLYESTER_TEST_DOMAIN}
+resources:
+  - name: post
+    path: /archive/(?P<ID>\d+)$
+    follow: []
+`)
+	c, err := Load(in)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if got := strings.Join(c.Domains, ","); got != "blog.example.com,www.blog.example.com" {
+		t.Errorf("Domains = %q", got)
+	}
+	if c.Name != "x\ndomains: [evil.example.net]" {
+		t.Errorf("Name = %q, want the variable's value", c.Name)
+	}
+	if c.Resources[0].Path != `/archive/(?P<ID>\d+)$` {
+		t.Errorf("Path = %q, want a bare $ left alone", c.Resources[0].Path)
+	}
+}
+
+func TestLoadStrictEnv(t *testing.T) {
+	in := []byte("domains: [\"${POLYESTER_TEST_UNSET}example.com\"]\n")
+	if _, err := LoadStrict(in); err == nil || !strings.Contains(err.Error(), "POLYESTER_TEST_UNSET") {
+		t.Errorf("LoadStrict() = %v, want an error naming the unset variable", err)
+	}
+	c, err := Load(in)
+	if err != nil || c.Domains[0] != "example.com" {
+		t.Errorf("Load() = %v, %v, want the unset variable expanded to \"\"", c, err)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	y := []byte(`
+name: Some Site
+domains: [example.com, www.example.com]
+resources:
+  - name: post
+    path: /archive/(?P<ID>\d+)
+    follow:
+      - /archive/{ID}/comments/
+    fetchlimit: 5
+soft404:
+  title: ["^Page not found"]
+metadata:
+  - var: TITLE
+    property: title
+apis:
+  - path: /wp-json/wp/v2/posts
+    relativize: true
+`)
+	j := []byte(`{
+	"Name": "Some Site",
+	"Domains": ["example.com", "www.example.com"],
+	"Resources": [{"Name": "post", "Path": "/archive/(?P<ID>\\d+)", "Follow": ["/archive/{ID}/comments/"], "FetchLimit": 5}],
+	"Soft404": {"Title": ["^Page not found"]},
+	"Metadata": [{"Var": "TITLE", "Property": "title"}],
+	"APIs": [{"Path": "/wp-json/wp/v2/posts", "Relativize": true}]
+}`)
+	fromYAML, err := Load(y)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	fromJSON, err := LoadJSON(j)
+	if err != nil {
+		t.Fatalf("LoadJSON() = %v", err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("LoadJSON() = %+v, want %+v as from YAML", fromJSON, fromYAML)
+	}
+
+	if _, err := LoadJSON([]byte(`{"Domains": ["example.com"], "Bogus": 1}`)); err == nil {
+		t.Errorf("LoadJSON() with an unknown field succeeded")
+	}
+}
@@ -0,0 +1,137 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matches a `{VAR}` reference in a path or pattern.
+var varRefRE = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Validate checks that the config is semantically sound, returning an error
+// listing every problem found.
+func (c *Config) Validate() error {
+	var errs []error
+	if len(c.Domains) == 0 {
+		errs = append(errs, errors.New("no domains listed"))
+	}
+
+	names := map[string]bool{}
+	var checkResource func(r *Resource, where string, inherited map[string]bool, isRelated bool)
+	checkResource = func(r *Resource, where string, inherited map[string]bool, isRelated bool) {
+		if r.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: resource has no name", where))
+		} else if names[r.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate resource name %q", where, r.Name))
+		}
+		names[r.Name] = true
+
+		if r.FetchLimit < 0 || r.MaxDepth < 0 {
+			errs = append(errs, fmt.Errorf("%s: limits must not be negative", where))
+		}
+
+		vars := map[string]bool{}
+		for v := range inherited {
+			vars[v] = true
+		}
+		if r.Path == "" {
+			errs = append(errs, fmt.Errorf("%s: resource has no path", where))
+		} else if isRelated {
+			// Related paths are templates, filled in from the parent's variables.
+			errs = append(errs, checkRefs(r.Path, where+" path", inherited)...)
+		} else if re, err := regexp.Compile(r.Path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: bad path pattern: %w", where, err))
+		} else {
+			for _, name := range re.SubexpNames() {
+				if name != "" {
+					vars[name] = true
+				}
+			}
+		}
+		errs = append(errs, checkMetadata(r.Metadata, where+" ", vars)...)
+		for i, f := range r.Follow {
+			fwhere := fmt.Sprintf("%s follow[%d]", where, i)
+			errs = append(errs, checkRefs(f, fwhere, vars)...)
+			if _, err := regexp.Compile(varRefRE.ReplaceAllString(f, "x")); err != nil {
+				errs = append(errs, fmt.Errorf("%s: bad pattern: %w", fwhere, err))
+			}
+		}
+		for i := range r.Related {
+			checkResource(&r.Related[i], fmt.Sprintf("%s related[%d]", where, i), vars, true)
+		}
+	}
+	for i := range c.Resources {
+		checkResource(&c.Resources[i], fmt.Sprintf("resources[%d]", i), nil, false)
+	}
+
+	errs = append(errs, checkMetadata(c.Metadata, "", map[string]bool{})...)
+
+	for i, a := range c.APIs {
+		if !strings.HasPrefix(a.Path, "/") {
+			errs = append(errs, fmt.Errorf("apis[%d]: path %q is not root-relative", i, a.Path))
+		}
+		if a.MaxPages < 0 {
+			errs = append(errs, fmt.Errorf("apis[%d]: max pages must not be negative", i))
+		}
+	}
+
+	for i, p := range c.Soft404.Title {
+		if _, err := regexp.Compile(p); err != nil {
+			errs = append(errs, fmt.Errorf("soft404 title[%d]: bad pattern: %w", i, err))
+		}
+	}
+	for i, p := range c.Soft404.Body {
+		if _, err := regexp.Compile(p); err != nil {
+			errs = append(errs, fmt.Errorf("soft404 body[%d]: bad pattern: %w", i, err))
+		}
+	}
+	if c.Validation.MinSize < 0 {
+		errs = append(errs, errors.New("validation: min size must not be negative"))
+	}
+	for i, p := range c.Validation.ErrorMarkers {
+		if _, err := regexp.Compile(p); err != nil {
+			errs = append(errs, fmt.Errorf("validation errormarkers[%d]: bad pattern: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkMetadata returns an error for each invalid metadata entry, and adds the
+// variables they define to `vars`.
+func checkMetadata(ms []Metadata, where string, vars map[string]bool) []error {
+	var errs []error
+	for i, m := range ms {
+		if m.Var == "" || m.Property == "" {
+			errs = append(errs, fmt.Errorf("%smetadata[%d]: var and property are required", where, i))
+		}
+		vars[m.Var] = true
+		if m.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(m.Pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%smetadata[%d]: bad pattern: %w", where, i, err))
+			continue
+		}
+		for _, name := range re.SubexpNames() {
+			if name != "" {
+				vars[name] = true
+			}
+		}
+	}
+	return errs
+}
+
+// checkRefs returns an error for each `{VAR}` reference in `s` which is not
+// in `vars`.
+func checkRefs(s, where string, vars map[string]bool) []error {
+	var errs []error
+	for _, m := range varRefRE.FindAllStringSubmatch(s, -1) {
+		if !vars[m[1]] {
+			errs = append(errs, fmt.Errorf("%s: undefined variable %q", where, m[1]))
+		}
+	}
+	return errs
+}
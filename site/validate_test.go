@@ -0,0 +1,67 @@
+package site
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Domains: []string{"example.com"},
+		Resources: []Resource{{
+			Name:     "post",
+			Path:     `/archive/(?P<POST_ID>\d+)`,
+			Follow:   []string{`/archive/{POST_ID}/comments/`},
+			Metadata: []Metadata{{Var: "DATE", Property: "article:published_time", Pattern: `^(?P<YEAR>\d{4})`}},
+			Related:  []Resource{{Name: "archive_year", Path: "/archive/{YEAR}/"}},
+		}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Errorf("Validate() of a valid config = %v", err)
+	}
+	for _, tc := range []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"no domains", func(c *Config) { c.Domains = nil }, "no domains listed"},
+		{"duplicate name", func(c *Config) { c.Resources = append(c.Resources, Resource{Name: "post", Path: "/x"}) }, `duplicate resource name "post"`},
+		{"undefined follow variable", func(c *Config) { c.Resources[0].Follow = []string{"/tag/{TAG}/"} }, `follow[0]: undefined variable "TAG"`},
+		{"related without path", func(c *Config) { c.Resources[0].Related[0].Path = "" }, "related[0]: resource has no path"},
+		{"undefined related variable", func(c *Config) { c.Resources[0].Related[0].Path = "/archive/{MONTH}/" }, `undefined variable "MONTH"`},
+		{"bad path pattern", func(c *Config) { c.Resources[0].Path = "/(" }, "bad path pattern"},
+		{"bad metadata pattern", func(c *Config) { c.Resources[0].Metadata[0].Pattern = "(" }, "metadata[0]: bad pattern"},
+		{"negative limit", func(c *Config) { c.Resources[0].FetchLimit = -1 }, "limits must not be negative"},
+	} {
+		c := validConfig()
+		tc.modify(c)
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Validate() = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	// All problems are reported together.
+	c := validConfig()
+	c.Domains = nil
+	c.Resources[0].Related[0].Path = ""
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "no domains") || !strings.Contains(err.Error(), "no path") {
+		t.Errorf("Validate() = %v, want both problems", err)
+	}
+}
+
+func TestLoadSample(t *testing.T) {
+	in, err := os.ReadFile("../site.yaml.sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(in); err != nil {
+		t.Errorf("Load(site.yaml.sample) = %v", err)
+	}
+	if _, err := Load([]byte("name: Empty\n")); err == nil {
+		t.Errorf("Load() of a config with no domains succeeded")
+	}
+}
@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/TheSnook/polyester/proto/resource"
@@ -11,35 +16,76 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// Redirects are kept in their own bucket, named after the content bucket with
+// this suffix, so they can be looked up without decoding any content. Older
+// databases may still hold redirects in the content bucket.
+const redirectBucketSuffix = ".redirects"
+
 type BBoltStorage struct {
-	db     *bbolt.DB
-	bucket string
+	path     string
+	bucket   string
+	readOnly bool
+	// Held for reading by all operations, and for writing while reopening.
+	mu sync.RWMutex
+	db *bbolt.DB
 }
 
 func newBBolt(path string) Storage {
 	p := strings.Split(path, ":")
-	if len(p) != 2 {
+	if len(p) == 3 && (p[2] == "ro" || p[2] == "rw") {
+		// Explicit mode
+	} else if len(p) != 2 {
 		// Error
-		log.Fatalf(`BBolt path %q does not have expected format "<path>:<bucket>".`, path)
+		log.Fatalf(`BBolt path %q does not have expected format "<path>:<bucket>[:ro|:rw]".`, path)
 	}
 
-	db, err := bbolt.Open(p[0], 0600, &bbolt.Options{Timeout: 1 * time.Second})
+	s := &BBoltStorage{
+		path:     p[0],
+		bucket:   p[1],
+		readOnly: len(p) == 3 && p[2] == "ro",
+	}
+	db, err := s.open()
 	if err != nil {
-		log.Fatalf("Could not open database %q: %v", p[0], err)
+		log.Fatal(err)
 	}
+	s.db = db
+	return s
+}
 
-	db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(p[1]))
+func (s *BBoltStorage) open() (*bbolt.DB, error) {
+	db, err := bbolt.Open(s.path, 0600, &bbolt.Options{Timeout: 1 * time.Second, ReadOnly: s.readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("could not open database %q: %v", s.path, err)
+	}
+
+	if s.readOnly {
+		// Read-only transactions can't create the bucket, so it must already exist.
+		err := db.View(func(tx *bbolt.Tx) error {
+			if tx.Bucket([]byte(s.bucket)) == nil {
+				return fmt.Errorf("bucket %q not found in read-only database %q", s.bucket, s.path)
+			}
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("create bucket %q: %s", p[1], err)
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range []string{s.bucket, s.bucket + redirectBucketSuffix} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(b)); err != nil {
+				return fmt.Errorf("create bucket %q: %s", b, err)
+			}
 		}
 		return nil
 	})
-
-	return &BBoltStorage{
-		db:     db,
-		bucket: p[1],
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
+	return db, nil
 }
 
 func (s *BBoltStorage) Write(k string, r *resource.Resource) error {
@@ -48,14 +94,195 @@ func (s *BBoltStorage) Write(k string, r *resource.Resource) error {
 		return err
 	}
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(s.bucket))
-		err := b.Put([]byte(k), v)
+		rb := tx.Bucket([]byte(s.bucket + redirectBucketSuffix))
+		if b == nil || rb == nil {
+			return s.errNoBucket()
+		}
+		if r.Redirect != "" {
+			// Remove any content previously stored under the key, and vice versa.
+			b, rb = rb, b
+		}
+		if err := rb.Delete([]byte(k)); err != nil {
+			return err
+		}
+		return b.Put([]byte(k), v)
+	})
+}
+
+// errNoBucket reports that the content bucket is missing, e.g. because the
+// database file was replaced by one without it.
+func (s *BBoltStorage) errNoBucket() error {
+	return fmt.Errorf("bucket %q not found in database %q", s.bucket, s.path)
+}
+
+// buckets returns the content bucket and, if it exists, the redirect bucket.
+// Read-only databases created before redirects had their own bucket lack it.
+func (s *BBoltStorage) buckets(tx *bbolt.Tx) ([]*bbolt.Bucket, error) {
+	b := tx.Bucket([]byte(s.bucket))
+	if b == nil {
+		return nil, s.errNoBucket()
+	}
+	bs := []*bbolt.Bucket{b}
+	if rb := tx.Bucket([]byte(s.bucket + redirectBucketSuffix)); rb != nil {
+		bs = append(bs, rb)
+	}
+	return bs, nil
+}
+
+// WriteStream buffers the whole of body, since bbolt needs complete values.
+func (s *BBoltStorage) WriteStream(k string, r *resource.Resource, body io.Reader) error {
+	content, err := io.ReadAll(body)
+	if err != nil {
 		return err
+	}
+	c := proto.Clone(r).(*resource.Resource)
+	c.Content = content
+	return s.Write(k, c)
+}
+
+func (s *BBoltStorage) Read(k string) (*resource.Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := new(resource.Resource)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		// Redirects are smaller, so cheaper to check first.
+		bs, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(bs)
+		for _, b := range bs {
+			if v := b.Get([]byte(k)); v != nil {
+				return proto.Unmarshal(v, r)
+			}
+		}
+		return ErrNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *BBoltStorage) Keys() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []string{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bs, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		for _, b := range bs {
+			err := b.ForEach(func(k, _ []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// Keep the keys sorted, as bbolt returns them within each bucket.
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (s *BBoltStorage) Exists(k string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	found := false
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bs, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		for _, b := range bs {
+			found = found || b.Get([]byte(k)) != nil
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (s *BBoltStorage) Delete(k string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bs, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		for _, b := range bs {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
+// Ping confirms the database is open, the bucket exists and, unless the
+// database was opened read-only, that it can be written to.
+func (s *BBoltStorage) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	check := func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(s.bucket)) == nil {
+			return s.errNoBucket()
+		}
+		return nil
+	}
+	if s.readOnly {
+		return s.db.View(check)
+	}
+	// An empty write transaction still has to commit.
+	return s.db.Update(check)
+}
+
+// Sync flushes the database file to disk. Each write transaction is already
+// synced as it commits, unless the file system ignores it, so this is cheap.
+func (s *BBoltStorage) Sync() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Sync()
+}
+
+// Reopen closes and reopens the database file, e.g. to pick up a file which
+// was replaced on disk. Operations in progress complete first. A read-only
+// database keeps its old handle if the new one can't be opened, e.g. because
+// the replacement lacks the bucket. A read-write database holds an exclusive
+// lock on its file, so must be closed before it can be opened again.
+func (s *BBoltStorage) Reopen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.readOnly {
+		if err := s.db.Close(); err != nil {
+			return err
+		}
+	}
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	if s.readOnly {
+		s.db.Close()
+	}
+	s.db = db
+	return nil
+}
+
 func (s *BBoltStorage) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.db.Close()
 }
 
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newTestBBolt opens a fresh read-write bbolt database in a temporary
+// directory, closed at the end of the test.
+func newTestBBolt(t *testing.T) *BBoltStorage {
+	t.Helper()
+	s := New("bbolt:" + filepath.Join(t.TempDir(), "test.db") + ":polyester").(*BBoltStorage)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestBBoltExists(t *testing.T) {
+	s := newTestBBolt(t)
+	if err := s.Write("/page/", &resource.Resource{ContentType: "text/html", Content: []byte("<p>Hi</p>")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("/old/", &resource.Resource{Redirect: "/page/"}); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]bool{"/page/": true, "/old/": true, "/missing/": false} {
+		if got, err := s.Exists(k); err != nil || got != want {
+			t.Errorf("Exists(%q) = %v, %v, want %v", k, got, err, want)
+		}
+	}
+}
+
+func TestBBoltRoundTrip(t *testing.T) {
+	s := newTestBBolt(t)
+	want := &resource.Resource{
+		ContentType: "text/html",
+		Content:     []byte("<p>Hi</p>"),
+		SourceUrl:   "https://example.com/page/",
+		CrawledAt:   timestamppb.New(time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)),
+	}
+	if err := s.Write("/page/", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Read("/page/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("Read() = %v, want %v", got, want)
+	}
+}
+
+// writeBBolt creates a bbolt database at path holding a page under each key.
+func writeBBolt(t *testing.T, path, bucket string, keys ...string) {
+	t.Helper()
+	s := New("bbolt:" + path + ":" + bucket)
+	defer s.Close()
+	for _, k := range keys {
+		if err := s.Write(k, &resource.Resource{ContentType: "text/html", Content: []byte(k)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBBoltReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "site.db")
+	writeBBolt(t, path, "polyester", "/a/")
+	s := New("bbolt:" + path + ":polyester:ro").(*BBoltStorage)
+	defer s.Close()
+
+	// A newly crawled database replaces the file being served.
+	next := filepath.Join(dir, "next.db")
+	writeBBolt(t, next, "polyester", "/a/", "/b/")
+	if err := os.Rename(next, path); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := s.Exists("/b/"); found {
+		t.Errorf("Found /b/ before Reopen")
+	}
+	if err := s.Reopen(); err != nil {
+		t.Fatalf("Reopen() = %v", err)
+	}
+	if found, err := s.Exists("/b/"); !found || err != nil {
+		t.Errorf("Exists(/b/) after Reopen = %v, %v, want true", found, err)
+	}
+
+	// A replacement without the bucket is rejected, and the old one kept.
+	writeBBolt(t, next, "other", "/c/")
+	if err := os.Rename(next, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reopen(); err == nil {
+		t.Errorf("Reopen() with the bucket missing succeeded")
+	}
+	if found, err := s.Exists("/b/"); !found || err != nil {
+		t.Errorf("Exists(/b/) after failed Reopen = %v, %v, want true", found, err)
+	}
+}
+
+func TestBBoltReopenReadWrite(t *testing.T) {
+	s := newTestBBolt(t)
+	if err := s.Reopen(); err != nil {
+		t.Fatalf("Reopen() = %v", err)
+	}
+	if err := s.Write("/a/", &resource.Resource{Content: []byte("A")}); err != nil {
+		t.Errorf("Write() after Reopen = %v", err)
+	}
+}
+
+func TestBBoltReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "site.db")
+	writeBBolt(t, path, "polyester", "/a/")
+
+	// Read-only handles share the file.
+	s1 := New("bbolt:" + path + ":polyester:ro")
+	defer s1.Close()
+	s2 := New("bbolt:" + path + ":polyester:ro")
+	defer s2.Close()
+	for _, s := range []Storage{s1, s2} {
+		if r, err := s.Read("/a/"); err != nil || string(r.GetContent()) != "/a/" {
+			t.Errorf("Read(/a/) = %v, %v", r, err)
+		}
+	}
+	if err := s1.Write("/b/", &resource.Resource{Content: []byte("B")}); err == nil {
+		t.Errorf("Write() to a read-only database succeeded")
+	}
+
+	// A missing bucket is an error rather than being created.
+	s := &BBoltStorage{path: path, bucket: "other", readOnly: true}
+	if db, err := s.open(); err == nil || !strings.Contains(err.Error(), `bucket "other" not found`) {
+		if db != nil {
+			db.Close()
+		}
+		t.Errorf("open() with a missing bucket = %v, want bucket not found", err)
+	}
+}
+
+func TestBBoltPingReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	rw := New("bbolt:" + path + ":polyester")
+	if err := rw.Write("/", &resource.Resource{ContentType: "text/html", Content: []byte("Hi")}); err != nil {
+		t.Fatal(err)
+	}
+	rw.Close()
+	s := New("bbolt:" + path + ":polyester:ro").(*BBoltStorage)
+	defer s.Close()
+	if err := s.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() on a read-only database = %v", err)
+	}
+	// As if the bucket had gone since the database was opened.
+	s.bucket = "missing"
+	if err := s.Ping(context.Background()); err == nil || !strings.Contains(err.Error(), `bucket "missing" not found`) {
+		t.Errorf("Ping() without the bucket = %v, want bucket not found", err)
+	}
+}
+
+func TestBBoltMissingBucket(t *testing.T) {
+	s := newTestBBolt(t)
+	if err := s.Write("/", &resource.Resource{ContentType: "text/html", Content: []byte("Hi")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Update(func(tx *bbolt.Tx) error { return tx.DeleteBucket([]byte(s.bucket)) }); err != nil {
+		t.Fatal(err)
+	}
+	const want = `bucket "polyester" not found`
+	if _, err := s.Read("/"); err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), want) {
+		t.Errorf("Read() without the bucket = %v, want %s", err, want)
+	}
+	if _, err := s.Exists("/"); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Exists() without the bucket = %v, want %s", err, want)
+	}
+	if _, err := s.Keys(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Keys() without the bucket = %v, want %s", err, want)
+	}
+}
+
+func TestBBoltPing(t *testing.T) {
+	s := newTestBBolt(t)
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Ping(ctx); err != context.Canceled {
+		t.Errorf("Ping() with a cancelled context = %v", err)
+	}
+	if err := s.db.Update(func(tx *bbolt.Tx) error { return tx.DeleteBucket([]byte(s.bucket)) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Ping(context.Background()); err == nil || !strings.Contains(err.Error(), `bucket "polyester" not found`) {
+		t.Errorf("Ping() without the bucket = %v, want bucket not found", err)
+	}
+}
+
+func TestBBoltRedirectBucket(t *testing.T) {
+	s := newTestBBolt(t)
+	page := &resource.Resource{ContentType: "text/html", Content: []byte("<p>Hi</p>")}
+	redirect := &resource.Resource{Redirect: "/page/"}
+	for k, r := range map[string]*resource.Resource{"/page/": page, "/old/": redirect, "/moved/": page} {
+		if err := s.Write(k, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A page replaced by a redirect moves buckets.
+	if err := s.Write("/moved/", redirect); err != nil {
+		t.Fatal(err)
+	}
+
+	// bucket returns the name of the bucket holding a key.
+	bucket := func(k string) string {
+		var name string
+		s.db.View(func(tx *bbolt.Tx) error {
+			for _, b := range []string{s.bucket, s.bucket + redirectBucketSuffix} {
+				if tx.Bucket([]byte(b)).Get([]byte(k)) != nil {
+					name += b
+				}
+			}
+			return nil
+		})
+		return name
+	}
+	for k, want := range map[string]string{"/page/": "polyester", "/old/": "polyester.redirects", "/moved/": "polyester.redirects"} {
+		if got := bucket(k); got != want {
+			t.Errorf("%s stored in bucket %q, want %q", k, got, want)
+		}
+	}
+	for k, want := range map[string]*resource.Resource{"/page/": page, "/old/": redirect, "/moved/": redirect} {
+		if got, err := s.Read(k); err != nil || !proto.Equal(got, want) {
+			t.Errorf("Read(%q) = %v, %v, want %v", k, got, err, want)
+		}
+	}
+	if keys, err := s.Keys(); err != nil || strings.Join(keys, " ") != "/moved/ /old/ /page/" {
+		t.Errorf("Keys() = %q, %v", keys, err)
+	}
+
+	if err := s.Delete("/old/"); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := s.Exists("/old/"); found || err != nil {
+		t.Errorf("Exists(/old/) after Delete = %v, %v", found, err)
+	}
+}
+
+func TestBBoltLegacyRedirects(t *testing.T) {
+	// Databases written before redirects had their own bucket keep them with
+	// the content.
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := proto.Marshal(&resource.Resource{Redirect: "/new/"})
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("polyester"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("/old/"), v)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New("bbolt:" + path + ":polyester:ro")
+	defer s.Close()
+	if r, err := s.Read("/old/"); err != nil || r.Redirect != "/new/" {
+		t.Errorf("Read(/old/) = %v, %v, want a redirect to /new/", r, err)
+	}
+	if keys, err := s.Keys(); err != nil || strings.Join(keys, " ") != "/old/" {
+		t.Errorf("Keys() = %q, %v", keys, err)
+	}
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CopyProgress is called by Copy after each key is processed. `err` is nil if
+// the resource was copied successfully.
+type CopyProgress func(done, total int, k string, err error)
+
+// Copy writes every resource stored in `src` to `dst` under the same key.
+// If `keepGoing` is set, failures on individual keys are collected and
+// returned together once all other keys have been copied. Otherwise Copy stops
+// at the first failure. `progress` may be nil.
+func Copy(src, dst Storage, keepGoing bool, progress CopyProgress) error {
+	keys, err := src.Keys()
+	if err != nil {
+		return fmt.Errorf("listing source keys: %w", err)
+	}
+
+	var errs []error
+	for i, k := range keys {
+		err := copyOne(src, dst, k)
+		if progress != nil {
+			progress(i+1, len(keys), k, err)
+		}
+		if err == nil {
+			continue
+		}
+		if !keepGoing {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func copyOne(src, dst Storage, k string) error {
+	r, err := src.Read(k)
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", k, err)
+	}
+	if err := dst.Write(k, r); err != nil {
+		return fmt.Errorf("writing %q: %w", k, err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCopy(t *testing.T) {
+	src, dst := NewMem(), NewMem()
+	want := map[string]*resource.Resource{
+		"/":            {ContentType: "text/html", Content: []byte("<p>Home</p>"), CrawledAt: timestamppb.Now()},
+		"/old/":        {Redirect: "/"},
+		"/a.png":       {ContentType: "image/png", Content: []byte{0x89, 'P', 'N', 'G'}},
+		"/feed/?p=1&q": {ContentType: "application/rss+xml", Content: []byte("<rss/>"), SourceUrl: "https://example.com/feed/?p=1&q"},
+	}
+	for k, r := range want {
+		src.Write(k, r)
+	}
+	var progress []string
+	err := Copy(src, dst, false, func(done, total int, k string, err error) {
+		if err != nil || total != len(want) {
+			t.Errorf("Progress(%d, %d, %q, %v)", done, total, k, err)
+		}
+		progress = append(progress, k)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != len(want) {
+		t.Errorf("Got progress for %q, want all %d keys", progress, len(want))
+	}
+	keys, _ := dst.Keys()
+	if len(keys) != len(want) {
+		t.Errorf("Copied keys %q, want %d", keys, len(want))
+	}
+	for k, w := range want {
+		got, err := dst.Read(k)
+		if err != nil {
+			t.Errorf("Read(%q): %v", k, err)
+			continue
+		}
+		if !proto.Equal(got, w) {
+			t.Errorf("Read(%q) = %v, want %v", k, got, w)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"google.golang.org/protobuf/proto"
+)
+
+// MemStorage holds resources in memory, e.g. for tests or a dry run. Nothing
+// is persisted. It is safe for concurrent use.
+type MemStorage struct {
+	mu        sync.Mutex
+	resources map[string]*resource.Resource
+}
+
+func NewMem() *MemStorage {
+	return &MemStorage{resources: map[string]*resource.Resource{}}
+}
+
+func newMem(string) Storage {
+	return NewMem()
+}
+
+func (s *MemStorage) Write(k string, r *resource.Resource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[k] = proto.Clone(r).(*resource.Resource)
+	return nil
+}
+
+func (s *MemStorage) Read(k string) (*resource.Resource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.resources[k]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.Clone(r).(*resource.Resource), nil
+}
+
+// Keys returns the stored keys in sorted order.
+func (s *MemStorage) Keys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.resources))
+	for k := range s.resources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemStorage) Exists(k string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.resources[k]
+	return ok, nil
+}
+
+func (s *MemStorage) Delete(k string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.resources, k)
+	return nil
+}
+
+func (s *MemStorage) Close() {}
+
+func init() {
+	register("mem", newMem)
+}
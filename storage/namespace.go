@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"google.golang.org/protobuf/proto"
+)
+
+// Namespaced keeps pages and assets apart within one store by prefixing
+// their keys, e.g. "/about/" is stored as "html/about/" and "/logo.png" as
+// "assets/logo.png", so that each can have its own lifecycle or caching
+// rules. Pages are HTML resources and redirects; everything else is an
+// asset. Keys passed to and returned from a Namespaced store are unprefixed.
+type Namespaced struct {
+	s      Storage
+	pages  string
+	assets string
+}
+
+// NewNamespaced stores pages under the prefix `pages` and assets under
+// `assets`, which must differ, and neither of which may be nested in the
+// other.
+func NewNamespaced(s Storage, pages, assets string) *Namespaced {
+	return &Namespaced{s: s, pages: strings.TrimSuffix(pages, "/"), assets: strings.TrimSuffix(assets, "/")}
+}
+
+// ParseNamespaces builds a Namespaced store from a "<pages>,<assets>" pair
+// of prefixes, e.g. "html,assets".
+func ParseNamespaces(s Storage, spec string) (*Namespaced, error) {
+	pages, assets, ok := strings.Cut(spec, ",")
+	pages, assets = strings.Trim(pages, " /"), strings.Trim(assets, " /")
+	if !ok || pages == "" || assets == "" || pages == assets || strings.Contains(assets, ",") {
+		return nil, fmt.Errorf(`namespaces %q do not have expected format "<pages>,<assets>" with two different prefixes`, spec)
+	}
+	// E.g. the page "/assets/x" under "site" would be the asset "/x" under
+	// "site/assets".
+	if strings.HasPrefix(pages+"/", assets+"/") || strings.HasPrefix(assets+"/", pages+"/") {
+		return nil, fmt.Errorf("namespaces %q are nested, so their keys could collide", spec)
+	}
+	return NewNamespaced(s, pages, assets), nil
+}
+
+// isPage reports whether a resource belongs in the pages namespace. As for
+// the crawler, content of no stated type is taken to be HTML.
+func isPage(r *resource.Resource) bool {
+	if r.GetRedirect() != "" || r.GetContentType() == "" {
+		return true
+	}
+	t, _, err := mime.ParseMediaType(r.GetContentType())
+	return err == nil && t == "text/html"
+}
+
+// keys returns the key k is stored under for a resource, and the key it
+// would have been stored under as the other kind of resource.
+func (s *Namespaced) keys(k string, r *resource.Resource) (string, string) {
+	if isPage(r) {
+		return s.pages + k, s.assets + k
+	}
+	return s.assets + k, s.pages + k
+}
+
+// Write also deletes any copy stored as the other kind of resource, which
+// would otherwise shadow it or be shadowed by it. Checking for one costs a
+// lookup per write, e.g. a HEAD request on S3, but that is cheaper than a
+// delete, which would be a write transaction on bbolt.
+func (s *Namespaced) Write(k string, r *resource.Resource) error {
+	key, other := s.keys(k, r)
+	if err := s.deleteOther(other); err != nil {
+		return err
+	}
+	return s.s.Write(key, r)
+}
+
+// deleteOther deletes the other kind of copy of a resource, if there is one.
+func (s *Namespaced) deleteOther(other string) error {
+	ok, err := s.s.Exists(other)
+	if err != nil || !ok {
+		return err
+	}
+	return s.s.Delete(other)
+}
+
+// WriteStream streams to the underlying store if it supports streaming, and
+// otherwise buffers the content.
+func (s *Namespaced) WriteStream(k string, r *resource.Resource, body io.Reader) error {
+	key, other := s.keys(k, r)
+	if err := s.deleteOther(other); err != nil {
+		return err
+	}
+	if sw, ok := s.s.(StreamWriter); ok {
+		return sw.WriteStream(key, r, body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	c := proto.Clone(r).(*resource.Resource)
+	c.Content = content
+	return s.s.Write(key, c)
+}
+
+// Read looks for a page first, as those are most requested.
+func (s *Namespaced) Read(k string) (*resource.Resource, error) {
+	r, err := s.s.Read(s.pages + k)
+	if errors.Is(err, ErrNotFound) {
+		return s.s.Read(s.assets + k)
+	}
+	return r, err
+}
+
+func (s *Namespaced) ReadMetadata(k string) (*resource.Resource, error) {
+	r, err := ReadMetadata(s.s, s.pages+k)
+	if errors.Is(err, ErrNotFound) {
+		return ReadMetadata(s.s, s.assets+k)
+	}
+	return r, err
+}
+
+func (s *Namespaced) Exists(k string) (bool, error) {
+	ok, err := s.s.Exists(s.pages + k)
+	if ok || err != nil {
+		return ok, err
+	}
+	return s.s.Exists(s.assets + k)
+}
+
+func (s *Namespaced) Delete(k string) error {
+	return errors.Join(s.s.Delete(s.pages+k), s.s.Delete(s.assets+k))
+}
+
+// Keys lists the keys in both namespaces, ignoring any others in the store.
+func (s *Namespaced) Keys() ([]string, error) {
+	all, err := s.s.Keys()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	var keys []string
+	for _, k := range all {
+		u, ok := strings.CutPrefix(k, s.pages)
+		if !ok || !strings.HasPrefix(u, "/") {
+			if u, ok = strings.CutPrefix(k, s.assets); !ok || !strings.HasPrefix(u, "/") {
+				continue
+			}
+		}
+		if _, dup := seen[u]; !dup {
+			seen[u] = struct{}{}
+			keys = append(keys, u)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *Namespaced) Reopen() error {
+	if r, ok := s.s.(Reopener); ok {
+		return r.Reopen()
+	}
+	return nil
+}
+
+func (s *Namespaced) Ping(ctx context.Context) error {
+	if p, ok := s.s.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (s *Namespaced) Sync() error {
+	if y, ok := s.s.(Syncer); ok {
+		return y.Sync()
+	}
+	return nil
+}
+
+func (s *Namespaced) Close() {
+	s.s.Close()
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	for spec, wantErr := range map[string]bool{
+		"html,assets":        false,
+		"/html/, /assets/":   false,
+		"site,site-assets":   false,
+		"html":               true,
+		",assets":            true,
+		"html,":              true,
+		"html,html":          true,
+		"html,assets,more":   true,
+		"site,site/assets":   true,
+		"site/pages,site":    true,
+		"site/,site/assets/": true,
+	} {
+		if _, err := ParseNamespaces(NewMem(), spec); (err != nil) != wantErr {
+			t.Errorf("ParseNamespaces(%q) = %v, want error: %v", spec, err, wantErr)
+		}
+	}
+}
+
+// deleteCountingStorage counts the deletes made on a store.
+type deleteCountingStorage struct {
+	*MemStorage
+	deletes []string
+}
+
+func (s *deleteCountingStorage) Delete(k string) error {
+	s.deletes = append(s.deletes, k)
+	return s.MemStorage.Delete(k)
+}
+
+func TestNamespaced(t *testing.T) {
+	db := &deleteCountingStorage{MemStorage: NewMem()}
+	ns, err := ParseNamespaces(db, "html,assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := &resource.Resource{ContentType: "text/html", Content: []byte("<p>Logo</p>")}
+	image := &resource.Resource{ContentType: "image/png", Content: []byte("PNG")}
+	if err := ns.Write("/logo/", page); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.WriteStream("/logo.png", image, strings.NewReader("PNG")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Write("/old/", &resource.Resource{Redirect: "/logo/"}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := db.Keys(); !slices.Equal(keys, []string{"assets/logo.png", "html/logo/", "html/old/"}) {
+		t.Errorf("Stored keys = %q", keys)
+	}
+	if keys, _ := ns.Keys(); !slices.Equal(keys, []string{"/logo.png", "/logo/", "/old/"}) {
+		t.Errorf("Keys() = %q", keys)
+	}
+	for k, want := range map[string]string{"/logo/": "<p>Logo</p>", "/logo.png": "PNG"} {
+		if got, err := ns.Read(k); err != nil || string(got.GetContent()) != want {
+			t.Errorf("Read(%q) = %v, %v, want %q", k, got, err, want)
+		}
+	}
+	// Nothing was stored as the other kind of resource, so there was
+	// nothing to delete.
+	if len(db.deletes) != 0 {
+		t.Errorf("Writes made deletes %q, want none", db.deletes)
+	}
+
+	// A page that becomes an asset replaces the stored page.
+	if err := ns.Write("/logo/", image); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(db.deletes, []string{"html/logo/"}) {
+		t.Errorf("Deletes = %q, want [html/logo/]", db.deletes)
+	}
+	if got, err := ns.Read("/logo/"); err != nil || got.GetContentType() != "image/png" {
+		t.Errorf("Read(/logo/) = %v, %v, want the image", got, err)
+	}
+	if _, err := ns.Read("/missing"); err != ErrNotFound {
+		t.Errorf("Read(/missing) = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"slices"
+	"strings"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"google.golang.org/protobuf/proto"
+)
+
+// Route sends resources matching a pattern to a back-end. A pattern is one of
+//   - a key prefix, starting with "/", e.g. /wp-content/uploads/
+//   - a key extension, starting with ".", e.g. .jpg
+//   - a content type, optionally with a wildcard subtype, e.g. image/*
+type Route struct {
+	Pattern string
+	Backend Storage
+}
+
+// byKey reports whether the route is matched on the key rather than the
+// content type.
+func (r Route) byKey() bool {
+	return strings.HasPrefix(r.Pattern, "/") || strings.HasPrefix(r.Pattern, ".")
+}
+
+func (r Route) matchKey(k string) bool {
+	if strings.HasPrefix(r.Pattern, "/") {
+		return strings.HasPrefix(k, r.Pattern)
+	}
+	p, _, _ := strings.Cut(k, "?")
+	return strings.HasPrefix(r.Pattern, ".") && strings.HasSuffix(p, r.Pattern)
+}
+
+func (r Route) matchContentType(ct string) bool {
+	if r.byKey() {
+		return false
+	}
+	if t, _, err := mime.ParseMediaType(ct); err == nil {
+		ct = t
+	}
+	if prefix, ok := strings.CutSuffix(r.Pattern, "/*"); ok {
+		return strings.HasPrefix(ct, prefix+"/")
+	}
+	return ct == r.Pattern
+}
+
+// Router dispatches to one of several back-ends according to the first
+// matching route, or to a default back-end if none match.
+type Router struct {
+	routes []Route
+	def    Storage
+}
+
+func NewRouter(def Storage, routes ...Route) *Router {
+	return &Router{routes: routes, def: def}
+}
+
+// ParseRoutes builds a Router from a comma-separated list of
+// "<pattern>=<target>" routes, opening each target with New.
+func ParseRoutes(def Storage, spec string) *Router {
+	var routes []Route
+	for _, s := range strings.Split(spec, ",") {
+		pattern, target, ok := strings.Cut(s, "=")
+		if !ok {
+			log.Fatalf(`Storage route %q does not have expected format "<pattern>=<scheme>:<path>".`, s)
+		}
+		routes = append(routes, Route{Pattern: pattern, Backend: New(target)})
+	}
+	return NewRouter(def, routes...)
+}
+
+// route returns the back-end to write a resource to.
+func (s *Router) route(k string, r *resource.Resource) Storage {
+	for _, rt := range s.routes {
+		if rt.matchKey(k) || rt.matchContentType(r.GetContentType()) {
+			return rt.Backend
+		}
+	}
+	return s.def
+}
+
+// Write also deletes any copy of the resource in the other back-ends which
+// might be read instead, e.g. if an image has become a page. As for
+// Namespaced, checking for one costs a lookup per back-end per write.
+func (s *Router) Write(k string, r *resource.Resource) error {
+	b := s.route(k, r)
+	if err := s.deleteOthers(k, b); err != nil {
+		return err
+	}
+	return b.Write(k, r)
+}
+
+// deleteOthers deletes any copy of the resource under key k in the
+// candidate back-ends other than b.
+func (s *Router) deleteOthers(k string, b Storage) error {
+	for _, o := range s.candidates(k) {
+		if o == b {
+			continue
+		}
+		ok, err := o.Exists(k)
+		if err == nil && ok {
+			err = o.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteStream streams to the routed back-end if it supports streaming, and
+// otherwise buffers the content.
+func (s *Router) WriteStream(k string, r *resource.Resource, body io.Reader) error {
+	b := s.route(k, r)
+	if err := s.deleteOthers(k, b); err != nil {
+		return err
+	}
+	if sw, ok := b.(StreamWriter); ok {
+		return sw.WriteStream(k, r, body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	c := proto.Clone(r).(*resource.Resource)
+	c.Content = content
+	return b.Write(k, c)
+}
+
+// candidates lists the back-ends which route might have chosen for key k, in
+// the order it tries them. Content types aren't known until a resource is
+// read, so any back-end routed by content type before the first route
+// matching k is a candidate.
+func (s *Router) candidates(k string) []Storage {
+	var cs []Storage
+	add := func(b Storage) {
+		if !slices.Contains(cs, b) {
+			cs = append(cs, b)
+		}
+	}
+	for _, rt := range s.routes {
+		if rt.matchKey(k) {
+			add(rt.Backend)
+			return cs
+		}
+		if !rt.byKey() {
+			add(rt.Backend)
+		}
+	}
+	add(s.def)
+	return cs
+}
+
+func (s *Router) Read(k string) (*resource.Resource, error) {
+	for _, b := range s.candidates(k) {
+		r, err := b.Read(k)
+		if !errors.Is(err, ErrNotFound) {
+			return r, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Router) ReadMetadata(k string) (*resource.Resource, error) {
+	for _, b := range s.candidates(k) {
+		r, err := ReadMetadata(b, k)
+		if !errors.Is(err, ErrNotFound) {
+			return r, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Router) Exists(k string) (bool, error) {
+	for _, b := range s.candidates(k) {
+		ok, err := b.Exists(k)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (s *Router) Delete(k string) error {
+	var errs []error
+	for _, b := range s.backends() {
+		errs = append(errs, b.Delete(k))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Router) Keys() ([]string, error) {
+	seen := make(map[string]struct{})
+	var keys []string
+	for _, b := range s.backends() {
+		ks, err := b.Keys()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range ks {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// backends lists each distinct back-end once.
+func (s *Router) backends() []Storage {
+	bs := []Storage{s.def}
+outer:
+	for _, rt := range s.routes {
+		for _, b := range bs {
+			if b == rt.Backend {
+				continue outer
+			}
+		}
+		bs = append(bs, rt.Backend)
+	}
+	return bs
+}
+
+func (s *Router) Reopen() error {
+	var errs []error
+	for _, b := range s.backends() {
+		if r, ok := b.(Reopener); ok {
+			if err := r.Reopen(); err != nil {
+				errs = append(errs, fmt.Errorf("reopening back-end: %w", err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Router) Ping(ctx context.Context) error {
+	var errs []error
+	for _, b := range s.backends() {
+		if p, ok := b.(Pinger); ok {
+			errs = append(errs, p.Ping(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Router) Sync() error {
+	var errs []error
+	for _, b := range s.backends() {
+		if y, ok := b.(Syncer); ok {
+			errs = append(errs, y.Sync())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Router) Close() {
+	for _, b := range s.backends() {
+		b.Close()
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/TheSnook/polyester/proto/resource"
+)
+
+func TestRouter(t *testing.T) {
+	def, images, uploads, css := NewMem(), NewMem(), NewMem(), NewMem()
+	r := NewRouter(def,
+		Route{Pattern: "image/*", Backend: images},
+		Route{Pattern: "/wp-content/", Backend: uploads},
+		Route{Pattern: ".css", Backend: css},
+	)
+	for k, tc := range map[string]struct {
+		contentType string
+		want        *MemStorage
+	}{
+		"/wp-content/x.jpg":     {"image/jpeg", images},
+		"/wp-content/x.pdf":     {"application/pdf", uploads},
+		"/wp-content/style.css": {"text/css", uploads},
+		"/theme/style.css?v=1":  {"text/css", css},
+		"/photo.png":            {"image/png", images},
+		"/about/":               {"text/html", def},
+	} {
+		want := &resource.Resource{ContentType: tc.contentType, Content: []byte(k)}
+		if err := r.Write(k, want); err != nil {
+			t.Fatalf("Write(%q) = %v", k, err)
+		}
+		if ok, _ := tc.want.Exists(k); !ok {
+			t.Errorf("%q not written to the expected back-end", k)
+		}
+		// Whichever back-end it went to, it can be read back.
+		if got, err := r.Read(k); err != nil || string(got.GetContent()) != k {
+			t.Errorf("Read(%q) = %v, %v", k, got, err)
+		}
+		if ok, err := r.Exists(k); !ok || err != nil {
+			t.Errorf("Exists(%q) = %v, %v", k, ok, err)
+		}
+	}
+	keys, err := r.Keys()
+	if err != nil || len(keys) != 6 {
+		t.Errorf("Keys() = %q, %v, want 6 keys", keys, err)
+	}
+	if err := r.Delete("/wp-content/x.jpg"); err != nil {
+		t.Errorf("Delete() = %v", err)
+	}
+	if ok, _ := r.Exists("/wp-content/x.jpg"); ok {
+		t.Errorf("Exists() after Delete() = true")
+	}
+	if _, err := r.Read("/missing/"); err != ErrNotFound {
+		t.Errorf("Read(/missing/) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRouterCandidates(t *testing.T) {
+	def, a, b := NewMem(), NewMem(), NewMem()
+	r := NewRouter(def, Route{Pattern: "image/*", Backend: a}, Route{Pattern: "/wp-content/", Backend: b}, Route{Pattern: "video/*", Backend: a})
+	for k, want := range map[string][]Storage{
+		"/wp-content/x.jpg": {a, b},
+		"/about/":           {a, def},
+	} {
+		if got := r.candidates(k); !slices.Equal(got, want) {
+			t.Errorf("candidates(%q) = %v, want %v", k, got, want)
+		}
+	}
+}
+
+func TestRouterTypeChange(t *testing.T) {
+	def, images := NewMem(), NewMem()
+	r := NewRouter(def, Route{Pattern: "image/*", Backend: images})
+	image := &resource.Resource{ContentType: "image/png", Content: []byte("PNG")}
+	page := &resource.Resource{ContentType: "text/html", Content: []byte("<p>Page</p>")}
+	if err := r.Write("/x", image); err != nil {
+		t.Fatal(err)
+	}
+	// The image becomes a page, which is then what is read, rather than the
+	// stale image in the back-end tried first.
+	if err := r.Write("/x", page); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Read("/x"); err != nil || got.GetContentType() != "text/html" {
+		t.Errorf("Read() = %v, %v, want the page", got, err)
+	}
+	if ok, _ := images.Exists("/x"); ok {
+		t.Errorf("Stale image still stored")
+	}
+	// And back again, streamed.
+	if err := r.WriteStream("/x", image, strings.NewReader("PNG")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := def.Exists("/x"); ok {
+		t.Errorf("Stale page still stored")
+	}
+	if got, err := r.Read("/x"); err != nil || got.GetContentType() != "image/png" {
+		t.Errorf("Read() = %v, %v, want the image", got, err)
+	}
+}
@@ -5,15 +5,45 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/TheSnook/polyester/proto/resource"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// Object metadata key holding Resource.CrawledAt in RFC 3339 format.
+const crawledAtMetadataKey = "Crawled-At"
+
+// Content type of the empty objects standing in for redirects. S3 website
+// hosting serves the redirect instead, but the REST API serves the object.
+const redirectContentType = "text/html"
+
+// Max times an S3 request is retried after throttling (503 SlowDown), a 5xx
+// error or a connection failure, with exponential backoff. Many writes in
+// quick succession, as in a crawl, can be throttled for a while.
+const S3_MAX_RETRIES = 8
+
+// s3Retryer retries S3 requests for longer than the SDK's default of 3
+// retries. Writes are whole-object PUTs, so are safe to repeat.
+var s3Retryer = client.DefaultRetryer{
+	NumMaxRetries:    S3_MAX_RETRIES,
+	MinRetryDelay:    100 * time.Millisecond,
+	MaxRetryDelay:    20 * time.Second,
+	MinThrottleDelay: time.Second,
+	MaxThrottleDelay: time.Minute,
+}
+
 type S3Storage struct {
 	svc    *s3.S3
 	bucket string
@@ -24,9 +54,9 @@ func newS3(path string) Storage {
 	if !ok {
 		log.Fatalf(`S3 path %q does not have expected format "<region>:<bucket>".`, path)
 	}
-	sess := session.Must(session.NewSession(&aws.Config{
+	sess := session.Must(session.NewSession(request.WithRetryer(&aws.Config{
 		Region: aws.String(region),
-	}))
+	}, s3Retryer)))
 	svc := s3.New(sess)
 	return &S3Storage{
 		svc:    svc,
@@ -40,15 +70,138 @@ func (s *S3Storage) Write(k string, r *resource.Resource) error {
 		Key:    aws.String(k),
 	}
 	if r.Redirect != "" {
+		// Not S3's default binary/octet-stream, for clients of the object itself.
 		obj.SetWebsiteRedirectLocation(r.Redirect)
+		obj.SetBody(bytes.NewReader(nil))
+		obj.SetContentType(redirectContentType)
 	} else {
 		obj.SetBody(bytes.NewReader(r.Content))
 		obj.SetContentType(r.ContentType)
 	}
+	if r.CrawledAt != nil {
+		obj.SetMetadata(map[string]*string{
+			crawledAtMetadataKey: aws.String(r.CrawledAt.AsTime().Format(time.RFC3339)),
+		})
+	}
 	_, err := s.svc.PutObject(obj)
 	return err
 }
 
+// WriteStream uploads body in parts, so it needn't fit in memory.
+func (s *S3Storage) WriteStream(k string, r *resource.Resource, body io.Reader) error {
+	in := &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(k),
+		Body:        body,
+		ContentType: aws.String(r.ContentType),
+	}
+	if r.CrawledAt != nil {
+		in.Metadata = map[string]*string{
+			crawledAtMetadataKey: aws.String(r.CrawledAt.AsTime().Format(time.RFC3339)),
+		}
+	}
+	_, err := s3manager.NewUploaderWithClient(s.svc).Upload(in)
+	return err
+}
+
+func (s *S3Storage) Read(k string) (*resource.Resource, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(k),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	r := &resource.Resource{Redirect: aws.StringValue(out.WebsiteRedirectLocation), CrawledAt: crawledAt(out.Metadata)}
+	if r.Redirect != "" {
+		return r, nil
+	}
+	r.ContentType = aws.StringValue(out.ContentType)
+	if r.Content, err = io.ReadAll(out.Body); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// crawledAt returns the crawl time recorded in an object's metadata, if any.
+func crawledAt(metadata map[string]*string) *timestamppb.Timestamp {
+	t, err := time.Parse(time.RFC3339, aws.StringValue(metadata[crawledAtMetadataKey]))
+	if err != nil {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// ReadMetadata reads a resource with a HEAD request, without its content.
+func (s *S3Storage) ReadMetadata(k string) (*resource.Resource, error) {
+	out, err := s.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(k),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	r := &resource.Resource{Redirect: aws.StringValue(out.WebsiteRedirectLocation), CrawledAt: crawledAt(out.Metadata)}
+	if r.Redirect == "" {
+		r.ContentType = aws.StringValue(out.ContentType)
+	}
+	return r, nil
+}
+
+func (s *S3Storage) Keys() ([]string, error) {
+	keys := []string{}
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			keys = append(keys, aws.StringValue(o.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+func (s *S3Storage) Exists(k string) (bool, error) {
+	_, err := s.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(k),
+	})
+	if err != nil {
+		// HEAD responses have no body, so a missing key is reported as a bare "NotFound".
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3Storage) Delete(k string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(k),
+	})
+	return err
+}
+
+// Ping confirms the bucket exists and is accessible. Whether it is writable
+// can't be checked without writing to it.
+func (s *S3Storage) Ping(ctx context.Context) error {
+	_, err := s.svc.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	return err
+}
+
+// Reopen is a no-op. S3 reads are always up to date.
+func (s *S3Storage) Reopen() error { return nil }
+
 func (s *S3Storage) Close() {}
 
 func init() {
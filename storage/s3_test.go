@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TheSnook/polyester/proto/resource"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeS3Object is an object stored by fakeS3, with the request headers it
+// was PUT with.
+type fakeS3Object struct {
+	body   []byte
+	header http.Header
+}
+
+// fakeS3 is an in-memory S3 endpoint for a single bucket, supporting the
+// object requests S3Storage makes.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+	puts    int // PUT requests received, including failed ones.
+	// failPuts is the number of PUT requests to answer with failStatus
+	// before accepting any. Without a failStatus, they are 503 SlowDown.
+	failPuts   int
+	failStatus int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != "test" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		f.puts++
+		body, _ := io.ReadAll(r.Body)
+		if f.failPuts > 0 {
+			f.failPuts--
+			switch f.failStatus {
+			case 0, http.StatusServiceUnavailable:
+				w.WriteHeader(http.StatusServiceUnavailable)
+				io.WriteString(w, `<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`)
+			default:
+				w.WriteHeader(f.failStatus)
+				fmt.Fprintf(w, `<Error><Code>Status%d</Code><Message>Failed.</Message></Error>`, f.failStatus)
+			}
+			return
+		}
+		f.objects[key] = fakeS3Object{body: body, header: r.Header.Clone()}
+	case http.MethodGet, http.MethodHead:
+		if key == "" {
+			// HeadBucket.
+			return
+		}
+		o, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			}
+			return
+		}
+		for k, v := range o.header {
+			if k == "Content-Type" || strings.HasPrefix(k, "X-Amz-Meta-") || k == "X-Amz-Website-Redirect-Location" {
+				w.Header()[k] = v
+			}
+		}
+		if r.Method == http.MethodGet {
+			w.Write(o.body)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// newTestS3 returns S3Storage backed by a fakeS3, retrying as in production.
+func newTestS3(t *testing.T) (*S3Storage, *fakeS3) {
+	t.Helper()
+	return newTestS3WithRetryer(t, s3Retryer)
+}
+
+// newTestS3WithRetryer returns S3Storage backed by a fakeS3, retrying with r.
+func newTestS3WithRetryer(t *testing.T, r request.Retryer) (*S3Storage, *fakeS3) {
+	t.Helper()
+	f := &fakeS3{objects: map[string]fakeS3Object{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	sess := session.Must(session.NewSession(request.WithRetryer(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}, r)))
+	return &S3Storage{svc: s3.New(sess), bucket: "test"}, f
+}
+
+func TestS3Exists(t *testing.T) {
+	s, _ := newTestS3(t)
+	if err := s.Write("/page/", &resource.Resource{ContentType: "text/html", Content: []byte("<p>Hi</p>")}); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]bool{"/page/": true, "/missing/": false} {
+		if got, err := s.Exists(k); err != nil || got != want {
+			t.Errorf("Exists(%q) = %v, %v, want %v", k, got, err, want)
+		}
+	}
+}
+
+func TestS3Ping(t *testing.T) {
+	s, _ := newTestS3(t)
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() = %v", err)
+	}
+	missing := &S3Storage{svc: s.svc, bucket: "missing"}
+	if err := missing.Ping(context.Background()); err == nil {
+		t.Errorf("Ping() of a missing bucket succeeded")
+	}
+}
+
+func TestS3CrawledAtRoundTrip(t *testing.T) {
+	s, _ := newTestS3(t)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.Write("/page/", &resource.Resource{ContentType: "text/html", Content: []byte("Hi"), CrawledAt: timestamppb.New(at)}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Read("/page/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.GetCrawledAt().AsTime(); !got.Equal(at) {
+		t.Errorf("CrawledAt = %v, want %v", got, at)
+	}
+
+	// As is the metadata alone, without the content.
+	if r, err = ReadMetadata(s, "/page/"); err != nil {
+		t.Fatal(err)
+	}
+	if !r.GetCrawledAt().AsTime().Equal(at) || r.GetContentType() != "text/html" || r.GetContent() != nil {
+		t.Errorf("ReadMetadata() = %v, want the crawl time and type without content", r)
+	}
+	if _, err := ReadMetadata(s, "/missing/"); err != ErrNotFound {
+		t.Errorf("ReadMetadata(/missing/) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3WriteRetries(t *testing.T) {
+	// As in production, but without waiting as long between attempts.
+	fast := s3Retryer
+	fast.MinRetryDelay, fast.MaxRetryDelay = time.Millisecond, time.Millisecond
+	fast.MinThrottleDelay, fast.MaxThrottleDelay = time.Millisecond, time.Millisecond
+	page := &resource.Resource{ContentType: "text/html", Content: []byte("Hi")}
+	for _, tc := range []struct {
+		status, fails int
+		wantPuts      int
+		wantErr       bool
+	}{
+		{http.StatusServiceUnavailable, 2, 3, false},
+		{http.StatusServiceUnavailable, S3_MAX_RETRIES, S3_MAX_RETRIES + 1, false},
+		{http.StatusServiceUnavailable, S3_MAX_RETRIES + 1, S3_MAX_RETRIES + 1, true},
+		{http.StatusInternalServerError, 1, 2, false},
+		// Client errors are not retried.
+		{http.StatusForbidden, 1, 1, true},
+	} {
+		s, f := newTestS3WithRetryer(t, fast)
+		f.failStatus, f.failPuts = tc.status, tc.fails
+		err := s.Write("/page/", page)
+		if (err != nil) != tc.wantErr || f.puts != tc.wantPuts {
+			t.Errorf("Write() failing %d times with %d = %v after %d PUTs, want error: %v after %d", tc.fails, tc.status, err, f.puts, tc.wantErr, tc.wantPuts)
+		}
+		// The SDK cleans the doubled slash out of the object's path.
+		if _, stored := f.objects["page/"]; stored == tc.wantErr {
+			t.Errorf("Write() failing %d times with %d: stored = %v", tc.fails, tc.status, stored)
+		}
+	}
+}
+
+func TestS3RedirectObject(t *testing.T) {
+	s, f := newTestS3(t)
+	if err := s.Write("/old/", &resource.Resource{Redirect: "/new/", ContentType: "image/png", Content: []byte("ignored")}); err != nil {
+		t.Fatal(err)
+	}
+	o, ok := f.objects["old/"]
+	if !ok {
+		t.Fatal("Redirect not stored")
+	}
+	if len(o.body) != 0 {
+		t.Errorf("Redirect object body = %q, want empty", o.body)
+	}
+	if got := o.header.Get("Content-Type"); got != redirectContentType {
+		t.Errorf("Redirect object Content-Type = %q, want %q", got, redirectContentType)
+	}
+	if got := o.header.Get("X-Amz-Website-Redirect-Location"); got != "/new/" {
+		t.Errorf("Redirect object location = %q, want /new/", got)
+	}
+	r, err := s.Read("/old/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GetRedirect() != "/new/" || r.GetContentType() != "" || len(r.GetContent()) != 0 {
+		t.Errorf("Read() = %v, want a bare redirect to /new/", r)
+	}
+}
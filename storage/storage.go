@@ -1,23 +1,81 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
 	"strings"
 
 	"github.com/TheSnook/polyester/proto/resource"
 )
 
+// ErrNotFound is returned by Read when no resource is stored under a key.
+var ErrNotFound = errors.New("resource not found")
+
 type Storage interface {
 	Write(k string, r *resource.Resource) error
+	Read(k string) (*resource.Resource, error)
+	// Keys lists the keys of all stored resources.
+	Keys() ([]string, error)
+	// Exists reports whether a resource is stored under key k, without
+	// fetching its content.
+	Exists(k string) (bool, error)
+	// Delete removes any resource stored under key k.
+	Delete(k string) error
 	Close()
 }
 
+// Reopener is implemented by storage back-ends which can reopen their
+// underlying store, e.g. to pick up changes made by another process.
+type Reopener interface {
+	Reopen() error
+}
+
+// StreamWriter is implemented by storage back-ends which can store content
+// without holding all of it in memory, e.g. large media files. The content is
+// read from body; r supplies everything else, and its Content is ignored.
+type StreamWriter interface {
+	WriteStream(k string, r *resource.Resource, body io.Reader) error
+}
+
+// MetadataReader is implemented by storage back-ends which can read all of a
+// resource but its content more cheaply than Read, e.g. with a HEAD request
+// on S3.
+type MetadataReader interface {
+	ReadMetadata(k string) (*resource.Resource, error)
+}
+
+// ReadMetadata reads a resource without its content, if s supports that, or
+// else with Read, in which case the content may be included. Like Read, it
+// returns ErrNotFound if nothing is stored under key k.
+func ReadMetadata(s Storage, k string) (*resource.Resource, error) {
+	if m, ok := s.(MetadataReader); ok {
+		return m.ReadMetadata(k)
+	}
+	return s.Read(k)
+}
+
+// Pinger is implemented by storage back-ends which can check that they are
+// reachable and, unless opened read-only, writable, e.g. before a crawl or
+// for a server health check.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Syncer is implemented by storage back-ends which may hold writes that are
+// not yet durable, e.g. in OS buffers. Sync returns once they are.
+type Syncer interface {
+	Sync() error
+}
+
 var registry map[string]constructor
 
 // Factory to construct a back-end for a given target.
 // The target should include a scheme and path, e.g.
-//   - bbolt:</path/to/db.file>:<bucket>
+//   - bbolt:</path/to/db.file>:<bucket>[:ro|:rw]
 //   - s3:<bucket>
+//   - mem: (in memory, not persisted)
 func New(target string) Storage {
 	scheme, path, ok := strings.Cut(target, ":")
 	if !ok {